@@ -1,7 +1,27 @@
 package main
 
-import "tagallery.com/api/server"
+import (
+	"os"
+
+	"tagallery.com/api/server"
+)
 
 func main() {
+	// "api fsck" checks the consistency of the store instead of starting the server.
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		os.Exit(server.Fsck(os.Args[2:], os.Stdout))
+	}
+	// "api export" and "api import" move the library between instances and storage backends.
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(server.Export(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(server.Import(os.Args[2:], os.Stdin, os.Stdout))
+	}
+	// "api migrate" migrates the category references of images stored by older versions.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(server.Migrate(os.Args[2:], os.Stdout))
+	}
+
 	server.StartServer()
 }
@@ -0,0 +1,88 @@
+// Package boltdb implements the image and category store on top of an embedded bbolt database,
+// which persists all data in a single local file.
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+)
+
+var (
+	imageBucket            = []byte("image")
+	imageFileBucket        = []byte("image_file")
+	imageUnprocessedBucket = []byte("image_unprocessed")
+	categoryBucket         = []byte("category")
+)
+
+// Store persists images and categories in a bbolt database file.
+//
+// Images are kept in insertion order, keyed by a sequence number, so that the pagination
+// matches the one of the MongoDB store. A second bucket maps the file of an image to its key,
+// a third one indexes the keys of the unprocessed images.
+type Store struct {
+	db *bolt.DB
+}
+
+var _ store.ImageStore = (*Store)(nil)
+var _ store.CategoryStore = (*Store)(nil)
+
+// Open opens (and creates if necessary) the database file and its buckets.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		indexed := tx.Bucket(imageUnprocessedBucket) != nil
+
+		for _, bucket := range [][]byte{imageBucket, imageFileBucket, imageUnprocessedBucket, categoryBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+
+		// Databases of older versions have no index of the unprocessed images yet.
+		if !indexed {
+			return tx.Bucket(imageBucket).ForEach(func(key, value []byte) error {
+				var image model.Image
+				if err := json.Unmarshal(value, &image); err != nil {
+					return err
+				}
+				return indexUnprocessed(tx, key, image)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// indexUnprocessed adds the key of an unprocessed image to the index, or removes the key of a processed one.
+func indexUnprocessed(tx *bolt.Tx, key []byte, image model.Image) error {
+	if image.Unprocessed {
+		return tx.Bucket(imageUnprocessedBucket).Put(key, []byte{})
+	}
+	return tx.Bucket(imageUnprocessedBucket).Delete(key)
+}
+
+// itob encodes a sequence number as a big endian key, which keeps the keys sorted.
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,126 @@
+package boltdb_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"tagallery.com/api/boltdb"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+func init() {
+	logger.Setup(true)
+}
+
+// openStore opens a new database file in a temporary directory and closes it after the test.
+func openStore(t *testing.T, path string) *boltdb.Store {
+	db, err := boltdb.Open(path)
+	if err != nil {
+		format, args := testutil.FormatTestError(
+			"Unable to open the database file.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Fatalf(format, args...)
+	}
+
+	return db
+}
+
+func TestStore(t *testing.T) {
+	testutil.RunStoreTests(t, func(t *testing.T) store.Store {
+		db := openStore(t, filepath.Join(t.TempDir(), "tagallery.db"))
+		t.Cleanup(func() { db.Close() })
+		return store.Store{Images: db, Categories: db}
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tagallery.db")
+	image := model.Image{File: "test.jpg", AssignedCategories: []string{"Category 1"}}
+
+	db := openStore(t, path)
+	category, err := db.UpsertCategory(model.Category{Name: "Category 1"})
+	if err == nil {
+		err = db.UpsertImage(image)
+	}
+	if err != nil {
+		format, args := testutil.FormatTestError(
+			"Unable to create the fixtures.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Fatalf(format, args...)
+	}
+	db.Close()
+
+	db = openStore(t, path)
+	defer db.Close()
+
+	categories, err := db.QueryCategories()
+	if err != nil || !reflect.DeepEqual(categories, []model.Category{*category}) {
+		format, args := testutil.FormatTestError(
+			"Expected the category to be read from the database file.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": []model.Category{*category},
+				"got":      categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	images, err := db.GetImages(model.ImageOptions{}, &model.CategoryMap{})
+	if err != nil || !reflect.DeepEqual(images, []model.Image{image}) {
+		format, args := testutil.FormatTestError(
+			"Expected the image to be read from the database file.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": []model.Image{image},
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestUnprocessedIndexMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tagallery.db")
+	image := model.Image{File: "unprocessed/test.jpg", Unprocessed: true}
+
+	db := openStore(t, path)
+	if err := db.UpsertImage(image); err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+	db.Close()
+
+	// Databases of older versions have no index of the unprocessed images.
+	raw, err := bolt.Open(path, 0600, nil)
+	if err == nil {
+		err = raw.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte("image_unprocessed"))
+		})
+		raw.Close()
+	}
+	if err != nil {
+		t.Fatal("Unable to remove the index.", err)
+	}
+
+	db = openStore(t, path)
+	defer db.Close()
+
+	images, _, err := db.GetUnprocessedImages(model.ImageOptions{})
+	if err != nil || !reflect.DeepEqual(images, []model.Image{image}) {
+		format, args := testutil.FormatTestError(
+			"Expected the index to be built when opening the database file.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": []model.Image{image},
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,124 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+)
+
+// QueryCategories returns all categories.
+func (s *Store) QueryCategories() ([]model.Category, error) {
+	categories := []model.Category{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(categoryBucket).ForEach(func(_, value []byte) error {
+			var category model.Category
+			if err := json.Unmarshal(value, &category); err != nil {
+				return err
+			}
+			categories = append(categories, category)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// UpsertCategory inserts a category or updates it.
+// If the provided category has a valid id, then the entire category is updated.
+// If the id is missing, then the name is taken as an identifier and everything else is updated.
+// You can also provide a valid id {category.id} for a new category.
+// The names and aliases are compared case insensitive and must be unique across all categories.
+// The parent must exist and must not create a cycle.
+func (s *Store) UpsertCategory(category model.Category) (*model.Category, error) {
+	var id string
+	inserted := false
+
+	if category.ID != nil && len(*category.ID) > 0 {
+		if !store.ValidID(*category.ID) {
+			return nil, fmt.Errorf("%w: %s", store.ErrInvalidID, *category.ID)
+		}
+		id = *category.ID
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(categoryBucket)
+		existing := id
+		categories := []model.Category{}
+
+		err := bucket.ForEach(func(key, value []byte) error {
+			var v model.Category
+			if err := json.Unmarshal(value, &v); err != nil {
+				return err
+			}
+			categories = append(categories, v)
+			// Like a MongoDB filter without collation, the name lookup is case sensitive.
+			if id == "" && v.Name == category.Name {
+				existing = string(key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		store.NormalizeAliases(&category)
+		if err := store.CheckNames(categories, existing, category); err != nil {
+			return err
+		}
+
+		if category.ParentID != nil && *category.ParentID == "" {
+			category.ParentID = nil
+		}
+		if err := store.CheckParent(categories, existing, category.ParentID); err != nil {
+			return err
+		}
+
+		if existing == "" {
+			existing = store.NewID()
+		}
+		inserted = bucket.Get([]byte(existing)) == nil
+
+		value, err := json.Marshal(model.Category{
+			ID:          &existing,
+			Name:        category.Name,
+			Description: category.Description,
+			ParentID:    category.ParentID,
+			Aliases:     category.Aliases,
+		})
+		if err != nil {
+			return err
+		}
+
+		id = existing
+		return bucket.Put([]byte(existing), value)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	category.ID = nil
+	if inserted {
+		category.ID = &id
+	}
+	return &category, nil
+}
+
+// DeleteCategory deletes a category.
+func (s *Store) DeleteCategory(id string) error {
+	if !store.ValidID(id) {
+		return fmt.Errorf("%w: %s", store.ErrInvalidID, id)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(categoryBucket).Delete([]byte(id))
+	})
+}
@@ -0,0 +1,277 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+)
+
+// GetImages queries the database for images. See store.ImageStore for the filter semantics.
+func (s *Store) GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.Image, error) {
+	images := []model.Image{}
+
+	// Filtering and sorting by confidence needs all images, the cursor is only used in insertion order.
+	if opts.MinConfidence != nil || opts.SortByConfidence {
+		all, err := s.AllImages()
+		if err != nil {
+			return nil, err
+		}
+		return store.FilterImages(all, opts, categories), nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(imageBucket).Cursor()
+		key, value := cursor.First()
+
+		if opts.LastImage != nil {
+			if lastKey := tx.Bucket(imageFileBucket).Get([]byte(*opts.LastImage)); lastKey == nil {
+				logger.Logger().Warnw("Unable to find lastImage in the database.", "lastImage", *opts.LastImage)
+			} else {
+				cursor.Seek(lastKey)
+				key, value = cursor.Next()
+			}
+		}
+
+		for ; key != nil; key, value = cursor.Next() {
+			if opts.Count != nil && *opts.Count > 0 && len(images) >= *opts.Count {
+				break
+			}
+
+			var image model.Image
+			if err := json.Unmarshal(value, &image); err != nil {
+				return err
+			}
+			if store.MatchImage(image, categories) && store.MatchMetadata(image, opts) {
+				images = append(images, image)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// UpsertImage inserts or updates an existing image in the db.
+func (s *Store) UpsertImage(image model.Image) error {
+	value, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imageBucket)
+		files := tx.Bucket(imageFileBucket)
+
+		key := files.Get([]byte(image.File))
+		if key == nil {
+			seq, err := images.NextSequence()
+			if err != nil {
+				return err
+			}
+			key = itob(seq)
+			if err := files.Put([]byte(image.File), key); err != nil {
+				return err
+			}
+		}
+
+		if err := indexUnprocessed(tx, key, image); err != nil {
+			return err
+		}
+		return images.Put(key, value)
+	})
+}
+
+// UpdateImage atomically applies {update} to the image of a file within a single transaction.
+func (s *Store) UpdateImage(file string, update func(image *model.Image) error) (*model.Image, error) {
+	var image *model.Image
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imageBucket)
+
+		key := tx.Bucket(imageFileBucket).Get([]byte(file))
+		if key == nil {
+			return store.ErrNotFound
+		}
+
+		image = &model.Image{}
+		if err := json.Unmarshal(images.Get(key), image); err != nil {
+			return err
+		}
+		if err := update(image); err != nil {
+			return err
+		}
+		image.File = file
+
+		value, err := json.Marshal(image)
+		if err != nil {
+			return err
+		}
+		if err := indexUnprocessed(tx, key, *image); err != nil {
+			return err
+		}
+		return images.Put(key, value)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// GetUnprocessedImages returns the unprocessed images after a cursor or the last image.
+// The cursor is the sequence number of the last returned image, the pages are read from the index.
+func (s *Store) GetUnprocessedImages(opts model.ImageOptions) ([]model.Image, string, error) {
+	images := []model.Image{}
+	next := ""
+
+	var after []byte
+	if opts.Cursor != nil {
+		seq, err := strconv.ParseUint(*opts.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		after = itob(seq)
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if after == nil && opts.LastImage != nil {
+			if after = tx.Bucket(imageFileBucket).Get([]byte(*opts.LastImage)); after == nil {
+				logger.Logger().Warnw("Unable to find lastImage in the database.", "lastImage", *opts.LastImage)
+			}
+		}
+
+		cursor := tx.Bucket(imageUnprocessedBucket).Cursor()
+		key, _ := cursor.First()
+		if after != nil {
+			// Seek positions on the key itself if it's still indexed, or on the next one.
+			if key, _ = cursor.Seek(after); key != nil && bytes.Equal(key, after) {
+				key, _ = cursor.Next()
+			}
+		}
+
+		for ; key != nil; key, _ = cursor.Next() {
+			var image model.Image
+			if err := json.Unmarshal(tx.Bucket(imageBucket).Get(key), &image); err != nil {
+				return err
+			}
+			if !store.MatchMetadata(image, opts) {
+				continue
+			}
+
+			if opts.Count != nil && *opts.Count > 0 && len(images) >= *opts.Count {
+				return nil
+			}
+			images = append(images, image)
+			next = strconv.FormatUint(binary.BigEndian.Uint64(key), 10)
+		}
+
+		next = ""
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return images, next, nil
+}
+
+// AllImages returns all images, including the unprocessed ones.
+func (s *Store) AllImages() ([]model.Image, error) {
+	images := []model.Image{}
+
+	err := s.forEachImage(func(image model.Image) error {
+		images = append(images, image)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// GetImage returns the image of a file.
+func (s *Store) GetImage(file string) (*model.Image, error) {
+	var image *model.Image
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(imageFileBucket).Get([]byte(file))
+		if key == nil {
+			return store.ErrNotFound
+		}
+
+		image = &model.Image{}
+		return json.Unmarshal(tx.Bucket(imageBucket).Get(key), image)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// GetImagesByHash returns all images with the given content hash.
+func (s *Store) GetImagesByHash(hash string) ([]model.Image, error) {
+	images := []model.Image{}
+
+	err := s.forEachImage(func(image model.Image) error {
+		if image.Hash == hash {
+			images = append(images, image)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// DeleteImage deletes the image of a file.
+func (s *Store) DeleteImage(file string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		files := tx.Bucket(imageFileBucket)
+
+		key := files.Get([]byte(file))
+		if key == nil {
+			return nil
+		}
+
+		if err := tx.Bucket(imageBucket).Delete(key); err != nil {
+			return err
+		}
+		if err := tx.Bucket(imageUnprocessedBucket).Delete(key); err != nil {
+			return err
+		}
+		return files.Delete([]byte(file))
+	})
+}
+
+// forEachImage calls fn for every image in insertion order.
+func (s *Store) forEachImage(fn func(image model.Image) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imageBucket).ForEach(func(_, value []byte) error {
+			var image model.Image
+			if err := json.Unmarshal(value, &image); err != nil {
+				return err
+			}
+			return fn(image)
+		})
+	})
+}
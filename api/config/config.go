@@ -4,17 +4,46 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Configuration structures all available configuration options.
 type Configuration struct {
+	Storage                 string
 	Database                string
 	DatabaseHost            string
+	DatabaseFile            string
 	Debug                   bool
 	Port                    int
 	Images                  string
 	UnprocessedImagesFolder string
 	ProcessedImagesFolder   string
+	ThumbnailsFolder        string
+	JournalFolder           string
+	Proposer                string
+	ProposalInterval        time.Duration
+	ProposalNeighbours      int
+	ProposalMinScore        float64
+	ProposalMax             int
+	ClassifierURL           string
+	ClassifierTimeout       time.Duration
+	ClassifierRetries       int
+	ClassifierBatchSize     int
+	ClassifierSendData      bool
+	Watch                   bool
+	WatchDelay              time.Duration
+	Recursive               bool
+	FolderCategories        string
+	FolderCategoryRules     []string
+	KeywordExport           string
+	KeywordExportStarred    bool
+	KeywordImportIPTC       string
+	KeywordImportXMP        string
+	KeywordImportLightroom  string
+	KeywordImportCreate     bool
+	Datasets                string
+	MaxImagePixels          int
 }
 
 var config *Configuration
@@ -27,13 +56,40 @@ func Get() *Configuration {
 // Load loads the configuration options from the env variables.
 func Load() *Configuration {
 	config = &Configuration{
+		Storage:                 getEnv("STORAGE", "mongodb"),
 		DatabaseHost:            getEnv("DATABASE_HOST", "localhost:27017"),
 		Database:                getEnv("DATABASE", "tagallery"),
+		DatabaseFile:            getEnv("DATABASE_FILE", "tagallery.db"),
 		Debug:                   getEnvAsBool("DEBUG", false),
 		Port:                    getEnvAsInt("PORT", 3333),
 		Images:                  getEnv("IMAGES", filepath.Join(getExecutableDir(), "images")),
 		UnprocessedImagesFolder: "unprocessed",
 		ProcessedImagesFolder:   "processed",
+		ThumbnailsFolder:        ".thumbnails",
+		JournalFolder:           ".journal",
+		Proposer:                getEnv("PROPOSER", "knn"),
+		ProposalInterval:        getEnvAsDuration("PROPOSAL_INTERVAL", 10*time.Minute),
+		ProposalNeighbours:      getEnvAsInt("PROPOSAL_NEIGHBOURS", 5),
+		ProposalMinScore:        getEnvAsFloat("PROPOSAL_MIN_SCORE", 0.5),
+		ProposalMax:             getEnvAsInt("PROPOSAL_MAX", 3),
+		ClassifierURL:           getEnv("CLASSIFIER_URL", "http://localhost:5000/classify"),
+		ClassifierTimeout:       getEnvAsDuration("CLASSIFIER_TIMEOUT", 30*time.Second),
+		ClassifierRetries:       getEnvAsInt("CLASSIFIER_RETRIES", 3),
+		ClassifierBatchSize:     getEnvAsInt("CLASSIFIER_BATCH_SIZE", 16),
+		ClassifierSendData:      getEnvAsBool("CLASSIFIER_SEND_DATA", false),
+		Watch:                   getEnvAsBool("WATCH", true),
+		WatchDelay:              getEnvAsDuration("WATCH_DELAY", 500*time.Millisecond),
+		Recursive:               getEnvAsBool("RECURSIVE", false),
+		FolderCategories:        getEnv("FOLDER_CATEGORIES", "none"),
+		FolderCategoryRules:     getEnvAsList("FOLDER_CATEGORY_RULES", []string{}),
+		KeywordExport:           getEnv("KEYWORD_EXPORT", "none"),
+		KeywordExportStarred:    getEnvAsBool("KEYWORD_EXPORT_STARRED", false),
+		KeywordImportIPTC:       getEnv("KEYWORD_IMPORT_IPTC", "none"),
+		KeywordImportXMP:        getEnv("KEYWORD_IMPORT_XMP", "none"),
+		KeywordImportLightroom:  getEnv("KEYWORD_IMPORT_LIGHTROOM", "none"),
+		KeywordImportCreate:     getEnvAsBool("KEYWORD_IMPORT_CREATE", false),
+		Datasets:                getEnv("DATASETS", filepath.Join(getExecutableDir(), "datasets")),
+		MaxImagePixels:          getEnvAsInt("MAX_IMAGE_PIXELS", 100000000),
 	}
 
 	return config
@@ -70,3 +126,34 @@ func getEnvAsBool(name string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+func getEnvAsFloat(name string, defaultValue float64) float64 {
+	valStr := getEnv(name, "")
+	if value, err := strconv.ParseFloat(valStr, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsDuration(name string, defaultValue time.Duration) time.Duration {
+	valStr := getEnv(name, "")
+	if value, err := time.ParseDuration(valStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsList(name string, defaultValue []string) []string {
+	valStr := getEnv(name, "")
+	if valStr == "" {
+		return defaultValue
+	}
+
+	values := []string{}
+	for _, value := range strings.Split(valStr, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
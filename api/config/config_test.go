@@ -2,6 +2,7 @@ package config_test
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"tagallery.com/api/config"
@@ -23,6 +24,7 @@ func TestLoad(t *testing.T) {
 	os.Setenv("DEBUG", "true")
 	os.Setenv("PORT", "8080")
 	os.Setenv("IMAGES", "imgs")
+	os.Setenv("FOLDER_CATEGORY_RULES", "Vacation=Holiday, tmp*=")
 
 	configuration = config.Load()
 
@@ -30,7 +32,8 @@ func TestLoad(t *testing.T) {
 		configuration.Database != "database" ||
 		!configuration.Debug ||
 		configuration.Port != 8080 ||
-		configuration.Images != "imgs" {
+		configuration.Images != "imgs" ||
+		!reflect.DeepEqual(configuration.FolderCategoryRules, []string{"Vacation=Holiday", "tmp*="}) {
 		t.Error("Load() should load the settings from the env variables.")
 	}
 }
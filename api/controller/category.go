@@ -0,0 +1,495 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/util"
+)
+
+// Policies for deleting a category with child categories, see DeleteCategory().
+const (
+	DeleteRefuse   = "refuse"
+	DeleteReparent = "reparent"
+	DeleteCascade  = "cascade"
+)
+
+// ErrInvalidDeletePolicy indicates a delete policy other than "refuse", "reparent" or "cascade".
+var ErrInvalidDeletePolicy = errors.New(`the delete policy has to be either "refuse", "reparent" or "cascade"`)
+
+// ErrInvalidMerge indicates a category that should be merged into itself.
+var ErrInvalidMerge = errors.New("a category can't be merged into itself")
+
+// ErrHasChildren indicates that a category can't be deleted, because it has child categories.
+var ErrHasChildren = errors.New("the category has child categories")
+
+// UpsertCategory inserts or updates a category, see CategoryStore.UpsertCategory().
+// A category that is renamed by its id is renamed on all images as well.
+func (c *Controller) UpsertCategory(category model.Category) (*model.Category, error) {
+	previous := ""
+	if category.ID != nil && *category.ID != "" {
+		categories, err := c.store.Categories.QueryCategories()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range categories {
+			if *v.ID == *category.ID {
+				previous = v.Name
+			}
+		}
+	}
+
+	upserted, err := c.store.Categories.UpsertCategory(category)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != "" && previous != category.Name {
+		if _, err := c.replaceCategory(previous, category.Name); err != nil {
+			return nil, err
+		}
+	}
+	return upserted, nil
+}
+
+// CategoryTree returns the categories as a tree. Categories without parent, or whose parent doesn't exist,
+// are the roots. The categories keep the order of the store on every level.
+func (c *Controller) CategoryTree() ([]model.CategoryNode, error) {
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{}
+	for _, category := range categories {
+		ids[*category.ID] = true
+	}
+
+	children := map[string][]model.Category{}
+	roots := []model.CategoryNode{}
+	for _, category := range categories {
+		if category.ParentID != nil && ids[*category.ParentID] {
+			children[*category.ParentID] = append(children[*category.ParentID], category)
+		} else {
+			roots = append(roots, model.CategoryNode{Category: category})
+		}
+	}
+
+	var addChildren func(node *model.CategoryNode)
+	addChildren = func(node *model.CategoryNode) {
+		node.Children = []model.CategoryNode{}
+		for _, child := range children[*node.ID] {
+			node.Children = append(node.Children, model.CategoryNode{Category: child})
+		}
+		for k := range node.Children {
+			addChildren(&node.Children[k])
+		}
+	}
+	for k := range roots {
+		addChildren(&roots[k])
+	}
+
+	return roots, nil
+}
+
+// DeleteCategory deletes a category. A category with child categories is deleted according to the policy:
+// "refuse" (the default) keeps it and returns ErrHasChildren, "reparent" moves its children to its parent
+// and "cascade" deletes its descendants as well. The deleted categories are removed from all images.
+func (c *Controller) DeleteCategory(id string, policy string) error {
+	if policy == "" {
+		policy = DeleteRefuse
+	}
+	if policy != DeleteRefuse && policy != DeleteReparent && policy != DeleteCascade {
+		return ErrInvalidDeletePolicy
+	}
+
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return err
+	}
+
+	var parentID *string
+	for _, category := range categories {
+		if *category.ID == id {
+			parentID = category.ParentID
+		}
+	}
+
+	descendants := store.Descendants(categories, id)
+	deleted := []string{}
+	if len(descendants) > 0 {
+		switch policy {
+		case DeleteRefuse:
+			return ErrHasChildren
+		case DeleteReparent:
+			for _, child := range descendants {
+				if *child.ParentID != id {
+					continue
+				}
+				child.ParentID = parentID
+				if _, err := c.store.Categories.UpsertCategory(child); err != nil {
+					return err
+				}
+			}
+		case DeleteCascade:
+			for k := len(descendants) - 1; k >= 0; k-- {
+				if err := c.store.Categories.DeleteCategory(*descendants[k].ID); err != nil {
+					return err
+				}
+				deleted = append(deleted, descendants[k].Name)
+				logger.Logger().Infow("Descendant category deleted.", "category", descendants[k].Name)
+			}
+		}
+	}
+
+	if err := c.store.Categories.DeleteCategory(id); err != nil {
+		return err
+	}
+	for _, category := range categories {
+		if *category.ID == id {
+			deleted = append(deleted, category.Name)
+		}
+	}
+
+	for _, name := range deleted {
+		if _, err := c.replaceCategory(name, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeCategory merges a category into the target category: the images refer to the target instead, arrays
+// that contain both are deduplicated (keeping the higher score of two proposals), the children of the merged
+// category become children of the target and the merged category is deleted, its name and aliases become
+// aliases of the target. The target keeps its description,
+// unless the request concatenates the descriptions. A target below the merged category is moved to its parent.
+// The merge isn't atomic: if an image can't be updated, then the categories are merged already and the images
+// left are migrated by MigrateCategories().
+func (c *Controller) MergeCategory(id string, request model.CategoryMergeRequest) (*model.CategoryMergeReport, error) {
+	if id == request.TargetID {
+		return nil, ErrInvalidMerge
+	}
+	for _, v := range []string{id, request.TargetID} {
+		if !store.ValidID(v) {
+			return nil, fmt.Errorf("%w: %s", store.ErrInvalidID, v)
+		}
+	}
+
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	var source, target *model.Category
+	for k := range categories {
+		switch *categories[k].ID {
+		case id:
+			source = &categories[k]
+		case request.TargetID:
+			target = &categories[k]
+		}
+	}
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("%w: the category to merge or the target", store.ErrNotFound)
+	}
+
+	update := *target
+	if request.ConcatenateDescription && source.Description != "" && source.Description != target.Description {
+		update.Description = strings.TrimSpace(target.Description + "\n" + source.Description)
+	}
+
+	descendants := store.Descendants(categories, id)
+	for _, descendant := range descendants {
+		if *descendant.ID == *target.ID {
+			update.ParentID = source.ParentID
+		}
+	}
+	if !reflect.DeepEqual(update, *target) {
+		if _, err := c.store.Categories.UpsertCategory(update); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, child := range descendants {
+		if *child.ParentID != id || *child.ID == *target.ID {
+			continue
+		}
+		child.ParentID = target.ID
+		if _, err := c.store.Categories.UpsertCategory(child); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.store.Categories.DeleteCategory(id); err != nil {
+		return nil, err
+	}
+
+	// The name and the aliases of the merged category become aliases of the target.
+	update.Aliases = append(update.Aliases, source.Names()...)
+	if _, err := c.store.Categories.UpsertCategory(update); err != nil {
+		return nil, err
+	}
+	store.NormalizeAliases(&update)
+
+	// The images are rewritten last: references to the merged category that are left by a failure are aliases
+	// of the target then, which "api migrate" renames.
+	files, err := c.replaceCategory(source.Name, target.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.CategoryMergeReport{Source: source.Name, Target: update, Images: len(files), Files: files}, nil
+}
+
+// resolveCategories replaces names that are aliases of a category, or differ from its name in case only,
+// by the name of the category. Unknown names are kept.
+func (c *Controller) resolveCategories(names []string) ([]string, error) {
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make([]string, len(names))
+	for k, name := range names {
+		if resolved[k] = store.ResolveCategory(categories, name); resolved[k] == "" {
+			resolved[k] = name
+		}
+	}
+	return resolved, nil
+}
+
+// categoryDescendants maps the given category names to the names of the descendants of these categories.
+// Unknown categories have no descendants.
+func (c *Controller) categoryDescendants(names []string) (map[string][]string, error) {
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]string{}
+	for _, name := range names {
+		for _, category := range categories {
+			if category.Name != name {
+				continue
+			}
+			for _, descendant := range store.Descendants(categories, *category.ID) {
+				result[name] = append(result[name], descendant.Name)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// replaceCategory replaces a category by another one on all images, or removes it if the replacement is empty.
+// It returns the files of the updated images.
+func (c *Controller) replaceCategory(name string, replacement string) ([]string, error) {
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, image := range images {
+		if !replaceImageCategory(&image, name, replacement) {
+			continue
+		}
+
+		if _, err := c.store.Images.UpdateImage(image.File, func(image *model.Image) error {
+			replaceImageCategory(image, name, replacement)
+			return nil
+		}); errors.Is(err, store.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		files = append(files, image.File)
+	}
+
+	if len(files) > 0 {
+		logger.Logger().Infow("Category replaced on the images.", "category", name, "replacement", replacement, "images", len(files))
+	}
+	return files, nil
+}
+
+// replaceImageCategory replaces a category (compared case insensitive) by another one in the assigned, proposed,
+// starred and rejected categories of an image, or removes it if the replacement is empty. A replacement that
+// the image has already is kept once, with the higher score of both proposals. A replacement that ends up assigned
+// and rejected is no longer rejected, one that ends up proposed and rejected is no longer proposed.
+// It reports whether the image changed.
+func replaceImageCategory(image *model.Image, name string, replacement string) bool {
+	changed := false
+	// Only conflicts that arise from the replacement are resolved, not those the image has already.
+	conflicting := func(category string) bool {
+		return util.ContainsString(image.RejectedCategories, category, false) &&
+			(util.ContainsString(image.AssignedCategories, category, false) ||
+				util.ContainsString(model.ProposalNames(image.ProposedCategories), category, false))
+	}
+	conflicted := conflicting(name) || conflicting(replacement)
+
+	replace := func(categories []string) []string {
+		if categories == nil {
+			return nil
+		}
+		result := []string{}
+		for _, category := range categories {
+			if strings.EqualFold(category, name) {
+				changed = true
+				category = replacement
+			}
+			if category != "" && !util.ContainsString(result, category, false) {
+				result = append(result, category)
+			}
+		}
+		return result
+	}
+
+	image.AssignedCategories = replace(image.AssignedCategories)
+	if image.RejectedCategories = replace(image.RejectedCategories); len(image.RejectedCategories) == 0 {
+		image.RejectedCategories = nil
+	}
+
+	if image.ProposedCategories != nil {
+		proposals := []model.Proposal{}
+		for _, proposal := range image.ProposedCategories {
+			if strings.EqualFold(proposal.Category, name) {
+				changed = true
+				proposal.Category = replacement
+			}
+			if proposal.Category == "" {
+				continue
+			}
+
+			if k := indexOfProposal(proposals, proposal.Category); k < 0 {
+				proposals = append(proposals, proposal)
+			} else if proposal.Score > proposals[k].Score {
+				proposals[k] = proposal
+			}
+		}
+		image.ProposedCategories = proposals
+	}
+
+	if image.StarredCategory != nil && *image.StarredCategory != "" && strings.EqualFold(*image.StarredCategory, name) {
+		changed = true
+		image.StarredCategory = nil
+		if replacement != "" {
+			image.StarredCategory = util.StringPtr(replacement)
+		}
+	}
+
+	// Like on a review, an assignment lifts the rejection and a rejection removes the proposal.
+	if changed && replacement != "" && !conflicted {
+		if util.ContainsString(image.AssignedCategories, replacement, false) {
+			if image.RejectedCategories = withoutCategories(image.RejectedCategories, []string{replacement}); len(image.RejectedCategories) == 0 {
+				image.RejectedCategories = nil
+			}
+		} else if image.ProposedCategories != nil && util.ContainsString(image.RejectedCategories, replacement, false) {
+			image.ProposedCategories = withoutProposals(image.ProposedCategories, []string{replacement})
+		}
+	}
+
+	return changed
+}
+
+// indexOfProposal returns the index of the proposal of a category (compared case insensitive), or -1.
+func indexOfProposal(proposals []model.Proposal, category string) int {
+	for k, proposal := range proposals {
+		if strings.EqualFold(proposal.Category, category) {
+			return k
+		}
+	}
+	return -1
+}
+
+// MigrateCategories repairs the category references of images that were stored before categories were renamed
+// and deleted on the images as well. References that differ from their category in case only, or that are
+// aliases of a category, are renamed.
+// Only assigned and starred categories without category get their category created, proposals (e. g. stale
+// ones of a classifier) and rejections of categories that don't exist are removed. This can't be undone, so the
+// migration only runs on demand ("api migrate") and reports the created and removed categories.
+// Migrated images are updated only once, running it again changes nothing.
+func (c *Controller) MigrateCategories() (*model.CategoryMigration, error) {
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	migration := &model.CategoryMigration{CategoriesCreated: []string{}, CategoriesRemoved: []string{}}
+	for _, image := range images {
+		missing := append([]string{}, image.AssignedCategories...)
+		if image.StarredCategory != nil && *image.StarredCategory != "" {
+			missing = append(missing, *image.StarredCategory)
+		}
+		for _, category := range missing {
+			if store.ResolveCategory(categories, category) == "" {
+				categories = append(categories, model.Category{Name: category})
+				migration.CategoriesCreated = append(migration.CategoriesCreated, category)
+			}
+		}
+	}
+	if _, err := c.ensureCategories(migration.CategoriesCreated); err != nil {
+		return nil, err
+	}
+
+	for _, image := range images {
+		migrated := image
+		if !migrateImageCategories(&migrated, categories) {
+			continue
+		}
+
+		if _, err := c.store.Images.UpdateImage(image.File, func(image *model.Image) error {
+			migrateImageCategories(image, categories)
+			return nil
+		}); errors.Is(err, store.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		migration.ImagesUpdated++
+
+		for _, category := range imageCategories(image) {
+			if store.ResolveCategory(categories, category) == "" &&
+				!util.ContainsString(migration.CategoriesRemoved, category, false) {
+				migration.CategoriesRemoved = append(migration.CategoriesRemoved, category)
+			}
+		}
+	}
+
+	return migration, nil
+}
+
+// migrateImageCategories renames the categories of an image to the names of the matching categories (by name
+// or alias, compared case insensitive) and removes proposals and rejections of other categories.
+// It reports whether the image changed.
+func migrateImageCategories(image *model.Image, categories []model.Category) bool {
+	changed := false
+	for _, category := range imageCategories(*image) {
+		name := store.ResolveCategory(categories, category)
+		if name != category {
+			changed = replaceImageCategory(image, category, name) || changed
+		}
+	}
+	return changed
+}
+
+// imageCategories returns the names of all categories an image refers to.
+func imageCategories(image model.Image) []string {
+	categories := append([]string{}, image.AssignedCategories...)
+	categories = append(categories, model.ProposalNames(image.ProposedCategories)...)
+	categories = append(categories, image.RejectedCategories...)
+	if image.StarredCategory != nil && *image.StarredCategory != "" {
+		categories = append(categories, *image.StarredCategory)
+	}
+	return categories
+}
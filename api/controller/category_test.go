@@ -0,0 +1,605 @@
+package controller_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/util"
+)
+
+// Ids of the category hierarchy created by hierarchyStore.
+const (
+	animalsID = "5f1e9b3c0000000000000001"
+	catsID    = "5f1e9b3c0000000000000002"
+	kittensID = "5f1e9b3c0000000000000003"
+	dogsID    = "5f1e9b3c0000000000000004"
+	plantsID  = "5f1e9b3c0000000000000005"
+)
+
+// hierarchyStore creates a store with the categories Animals (with Cats, which has Kittens, and Dogs) and Plants.
+func hierarchyStore(t *testing.T, images []model.Image) *memory.Store {
+	return libraryStore(t, []model.Category{
+		{ID: util.StringPtr(animalsID), Name: "Animals"},
+		{ID: util.StringPtr(catsID), Name: "Cats", ParentID: util.StringPtr(animalsID)},
+		{ID: util.StringPtr(kittensID), Name: "Kittens", ParentID: util.StringPtr(catsID)},
+		{ID: util.StringPtr(dogsID), Name: "Dogs", ParentID: util.StringPtr(animalsID)},
+		{ID: util.StringPtr(plantsID), Name: "Plants"},
+	}, images)
+}
+
+func TestCategoryTree(t *testing.T) {
+	config.Load()
+
+	db := hierarchyStore(t, nil)
+	tree, err := controller.New(store.Store{Images: db, Categories: db}).CategoryTree()
+	if err != nil {
+		t.Fatal("Unable to query the category tree.", err)
+	}
+
+	categories, _ := db.QueryCategories()
+	expected := []model.CategoryNode{
+		{Category: categories[0], Children: []model.CategoryNode{
+			{Category: categories[1], Children: []model.CategoryNode{
+				{Category: categories[2], Children: []model.CategoryNode{}},
+			}},
+			{Category: categories[3], Children: []model.CategoryNode{}},
+		}},
+		{Category: categories[4], Children: []model.CategoryNode{}},
+	}
+	if !reflect.DeepEqual(tree, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the categories to be nested below their parents.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      tree,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestDeleteCategory(t *testing.T) {
+	config.Load()
+
+	tests := []struct {
+		desc     string
+		id       string
+		policy   string
+		err      error
+		expected map[string]*string
+	}{
+		{
+			desc:     "leaf",
+			id:       kittensID,
+			expected: map[string]*string{"Animals": nil, "Cats": util.StringPtr(animalsID), "Dogs": util.StringPtr(animalsID), "Plants": nil},
+		},
+		{
+			desc: "refuse",
+			id:   animalsID,
+			err:  controller.ErrHasChildren,
+			expected: map[string]*string{
+				"Animals": nil, "Cats": util.StringPtr(animalsID), "Kittens": util.StringPtr(catsID), "Dogs": util.StringPtr(animalsID), "Plants": nil,
+			},
+		},
+		{
+			desc:     "reparent",
+			id:       catsID,
+			policy:   controller.DeleteReparent,
+			expected: map[string]*string{"Animals": nil, "Kittens": util.StringPtr(animalsID), "Dogs": util.StringPtr(animalsID), "Plants": nil},
+		},
+		{
+			desc:     "reparent to the root",
+			id:       animalsID,
+			policy:   controller.DeleteReparent,
+			expected: map[string]*string{"Cats": nil, "Kittens": util.StringPtr(catsID), "Dogs": nil, "Plants": nil},
+		},
+		{
+			desc:     "cascade",
+			id:       animalsID,
+			policy:   controller.DeleteCascade,
+			expected: map[string]*string{"Plants": nil},
+		},
+		{
+			desc:   "invalid policy",
+			id:     animalsID,
+			policy: "orphan",
+			err:    controller.ErrInvalidDeletePolicy,
+			expected: map[string]*string{
+				"Animals": nil, "Cats": util.StringPtr(animalsID), "Kittens": util.StringPtr(catsID), "Dogs": util.StringPtr(animalsID), "Plants": nil,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		db := hierarchyStore(t, nil)
+		err := controller.New(store.Store{Images: db, Categories: db}).DeleteCategory(test.id, test.policy)
+
+		categories, _ := db.QueryCategories()
+		got := map[string]*string{}
+		for _, category := range categories {
+			got[category.Name] = category.ParentID
+		}
+
+		if !errors.Is(err, test.err) || !reflect.DeepEqual(got, test.expected) {
+			format, args := testutil.FormatTestError(
+				"Expected the category to be deleted according to the policy.",
+				map[string]interface{}{
+					"test":          test.desc,
+					"expectedError": test.err,
+					"error":         err,
+					"expected":      test.expected,
+					"got":           got,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestGetImagesWithDescendants(t *testing.T) {
+	config.Load()
+
+	images := []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"Animals"}},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Kittens"}},
+		{File: "processed/c.jpg", AssignedCategories: []string{"Dogs", "Plants"}},
+		{File: "processed/d.jpg", AssignedCategories: []string{"Plants"}},
+	}
+	db := hierarchyStore(t, images)
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	tests := []struct {
+		desc        string
+		categories  []string
+		descendants bool
+		expected    []model.Image
+	}{
+		{"category only", []string{"Animals"}, false, images[:1]},
+		{"with descendants", []string{"Animals"}, true, images[:3]},
+		{"all categories with descendants", []string{"Animals", "Plants"}, true, images[2:3]},
+		{"leaf with descendants", []string{"Kittens"}, true, images[1:2]},
+	}
+
+	for _, test := range tests {
+		got, _, err := ctrl.GetImages("categorized", model.ImageOptions{Descendants: test.descendants}, test.categories)
+		if err != nil || !reflect.DeepEqual(got, test.expected) {
+			format, args := testutil.FormatTestError(
+				"Expected the categories to match the images of their descendants on request.",
+				map[string]interface{}{
+					"test":     test.desc,
+					"error":    err,
+					"expected": test.expected,
+					"got":      got,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestUpsertCategoryRename(t *testing.T) {
+	config.Load()
+
+	db := hierarchyStore(t, []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"Cats", "Kitty"},
+			ProposedCategories: []model.Proposal{{Category: "cats", Score: 0.4}, {Category: "Kitty", Score: 0.9}},
+			StarredCategory:    util.StringPtr("Cats"),
+			RejectedCategories: []string{"CATS"},
+		},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Dogs"}, ProposedCategories: []model.Proposal{}},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	if _, err := ctrl.UpsertCategory(model.Category{ID: util.StringPtr(catsID), Name: "Kitty", ParentID: util.StringPtr(animalsID)}); err != nil {
+		t.Fatal("Unable to rename the category.", err)
+	}
+
+	expected := []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"Kitty"},
+			ProposedCategories: []model.Proposal{{Category: "Kitty", Score: 0.9}},
+			StarredCategory:    util.StringPtr("Kitty"),
+			RejectedCategories: []string{"Kitty"},
+		},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Dogs"}, ProposedCategories: []model.Proposal{}},
+	}
+	if images, _ := db.AllImages(); !reflect.DeepEqual(images, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the category to be renamed on the images.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+
+	// Upserts by name can't rename, the images stay untouched.
+	if _, err := ctrl.UpsertCategory(model.Category{Name: "Dogs", Description: "Woof"}); err != nil {
+		t.Fatal("Unable to update the category.", err)
+	}
+	if images, _ := db.AllImages(); !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected the images to be untouched, got %v.", images)
+	}
+}
+
+func TestDeleteCategoryFromImages(t *testing.T) {
+	config.Load()
+
+	db := hierarchyStore(t, []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"Animals", "Kittens", "Plants"},
+			ProposedCategories: []model.Proposal{{Category: "Cats", Score: 0.5}, {Category: "Plants", Score: 0.5}},
+			StarredCategory:    util.StringPtr("Kittens"),
+			RejectedCategories: []string{"Dogs"},
+		},
+	})
+
+	if err := controller.New(store.Store{Images: db, Categories: db}).DeleteCategory(animalsID, controller.DeleteCascade); err != nil {
+		t.Fatal("Unable to delete the category.", err)
+	}
+
+	expected := []model.Image{{
+		File:               "processed/a.jpg",
+		AssignedCategories: []string{"Plants"},
+		ProposedCategories: []model.Proposal{{Category: "Plants", Score: 0.5}},
+	}}
+	if images, _ := db.AllImages(); !reflect.DeepEqual(images, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the deleted categories to be removed from the images.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestMigrateCategories(t *testing.T) {
+	config.Load()
+
+	db := libraryStore(t, []model.Category{{Name: "Cats"}}, []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"cats", "Birds"},
+			ProposedCategories: []model.Proposal{{Category: "CATS", Score: 0.5}, {Category: "Fish", Score: 0.5}},
+			StarredCategory:    util.StringPtr("Dogs"),
+			RejectedCategories: []string{"Horses"},
+		},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Cats"}, ProposedCategories: []model.Proposal{}},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	migration, err := ctrl.MigrateCategories()
+	expectedMigration := &model.CategoryMigration{
+		CategoriesCreated: []string{"Birds", "Dogs"},
+		CategoriesRemoved: []string{"Fish", "Horses"},
+		ImagesUpdated:     1,
+	}
+	if err != nil || !reflect.DeepEqual(migration, expectedMigration) {
+		format, args := testutil.FormatTestError(
+			"Expected the missing categories to be created and the image to be migrated.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expectedMigration,
+				"got":      migration,
+			})
+		t.Errorf(format, args...)
+	}
+
+	expected := model.Image{
+		File:               "processed/a.jpg",
+		AssignedCategories: []string{"Cats", "Birds"},
+		ProposedCategories: []model.Proposal{{Category: "Cats", Score: 0.5}},
+		StarredCategory:    util.StringPtr("Dogs"),
+	}
+	if image, _ := db.GetImage("processed/a.jpg"); !reflect.DeepEqual(*image, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the categories of the image to refer to existing categories.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	names := []string{}
+	if categories, err := db.QueryCategories(); err == nil {
+		for _, category := range categories {
+			names = append(names, category.Name)
+		}
+	}
+	if expected := []string{"Cats", "Birds", "Dogs"}; !reflect.DeepEqual(names, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected proposals and rejections of unknown categories not to create categories.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      names,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if migration, err := ctrl.MigrateCategories(); err != nil || migration.ImagesUpdated != 0 || len(migration.CategoriesCreated) != 0 {
+		t.Errorf("Expected a second migration to change nothing, got %+v (%v).", migration, err)
+	}
+}
+
+// deletingStore deletes the image of a file right before it is updated, like a concurrent deletion.
+type deletingStore struct {
+	*memory.Store
+	file string
+}
+
+func (s deletingStore) UpdateImage(file string, update func(image *model.Image) error) (*model.Image, error) {
+	if file == s.file {
+		s.DeleteImage(file)
+	}
+	return s.Store.UpdateImage(file, update)
+}
+
+func TestMigrateCategoriesDeletedImage(t *testing.T) {
+	config.Load()
+
+	db := libraryStore(t, []model.Category{{Name: "Cats"}}, []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"cats"}, RejectedCategories: []string{"Horses"}},
+		{File: "processed/b.jpg", AssignedCategories: []string{"cats"}},
+	})
+	ctrl := controller.New(store.Store{Images: deletingStore{db, "processed/a.jpg"}, Categories: db})
+
+	migration, err := ctrl.MigrateCategories()
+	expected := &model.CategoryMigration{CategoriesCreated: []string{}, CategoriesRemoved: []string{}, ImagesUpdated: 1}
+	if err != nil || !reflect.DeepEqual(migration, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected images deleted during the migration not to be counted.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      migration,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestMergeCategory(t *testing.T) {
+	config.Load()
+
+	db := libraryStore(t, []model.Category{
+		{ID: util.StringPtr(animalsID), Name: "Animals"},
+		{ID: util.StringPtr(dogsID), Name: "Dog", Description: "A dog", ParentID: util.StringPtr(animalsID)},
+		{ID: util.StringPtr(catsID), Name: "Puppies", ParentID: util.StringPtr(dogsID)},
+		{ID: util.StringPtr(kittensID), Name: "Dogs", Description: "Dogs", ParentID: util.StringPtr(dogsID)},
+	}, []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"Dog", "Dogs"},
+			ProposedCategories: []model.Proposal{{Category: "Dog", Score: 0.8}, {Category: "Dogs", Score: 0.3}},
+			StarredCategory:    util.StringPtr("Dog"),
+		},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Dogs"}, ProposedCategories: []model.Proposal{}},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	report, err := ctrl.MergeCategory(dogsID, model.CategoryMergeRequest{TargetID: kittensID, ConcatenateDescription: true})
+	if err != nil {
+		t.Fatal("Unable to merge the category.", err)
+	}
+
+	target := model.Category{ID: util.StringPtr(kittensID), Name: "Dogs", Description: "Dogs\nA dog", ParentID: util.StringPtr(animalsID),
+		Aliases: []string{"Dog"}}
+	expectedReport := &model.CategoryMergeReport{Source: "Dog", Target: target, Images: 1, Files: []string{"processed/a.jpg"}}
+	if !reflect.DeepEqual(report, expectedReport) {
+		format, args := testutil.FormatTestError(
+			"Expected a summary of the merge.",
+			map[string]interface{}{
+				"expected": expectedReport,
+				"got":      report,
+			})
+		t.Errorf(format, args...)
+	}
+
+	expectedCategories := []model.Category{
+		{ID: util.StringPtr(animalsID), Name: "Animals"},
+		{ID: util.StringPtr(catsID), Name: "Puppies", ParentID: util.StringPtr(kittensID)},
+		target,
+	}
+	if categories, _ := db.QueryCategories(); !reflect.DeepEqual(categories, expectedCategories) {
+		format, args := testutil.FormatTestError(
+			"Expected the merged category to be deleted, its children moved to the target and its name kept as an alias.",
+			map[string]interface{}{
+				"expected": expectedCategories,
+				"got":      categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	expectedImage := model.Image{
+		File:               "processed/a.jpg",
+		AssignedCategories: []string{"Dogs"},
+		ProposedCategories: []model.Proposal{{Category: "Dogs", Score: 0.8}},
+		StarredCategory:    util.StringPtr("Dogs"),
+	}
+	if image, _ := db.GetImage("processed/a.jpg"); !reflect.DeepEqual(*image, expectedImage) {
+		format, args := testutil.FormatTestError(
+			"Expected the references of the image to be rewritten and deduplicated.",
+			map[string]interface{}{
+				"expected": expectedImage,
+				"got":      image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	for _, test := range []struct {
+		id       string
+		target   string
+		expected error
+	}{
+		{kittensID, kittensID, controller.ErrInvalidMerge},
+		{dogsID, kittensID, store.ErrNotFound},
+		{"invalid", kittensID, store.ErrInvalidID},
+	} {
+		if _, err := ctrl.MergeCategory(test.id, model.CategoryMergeRequest{TargetID: test.target}); !errors.Is(err, test.expected) {
+			format, args := testutil.FormatTestError(
+				"Expected an invalid merge to be rejected.",
+				map[string]interface{}{
+					"id":       test.id,
+					"target":   test.target,
+					"expected": test.expected,
+					"got":      err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestMergeCategoryRejected(t *testing.T) {
+	config.Load()
+
+	db := libraryStore(t, []model.Category{
+		{ID: util.StringPtr(dogsID), Name: "Dog"},
+		{ID: util.StringPtr(kittensID), Name: "Dogs"},
+	}, []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"Dog"}, RejectedCategories: []string{"Dogs"}},
+		{File: "processed/b.jpg", ProposedCategories: []model.Proposal{{Category: "Dog", Score: 0.5}}, RejectedCategories: []string{"Dogs"}},
+		{File: "processed/c.jpg", AssignedCategories: []string{"Dogs"}, RejectedCategories: []string{"Dog"}},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	if _, err := ctrl.MergeCategory(dogsID, model.CategoryMergeRequest{TargetID: kittensID}); err != nil {
+		t.Fatal("Unable to merge the category.", err)
+	}
+
+	expected := []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"Dogs"}},
+		{File: "processed/b.jpg", ProposedCategories: []model.Proposal{}, RejectedCategories: []string{"Dogs"}},
+		{File: "processed/c.jpg", AssignedCategories: []string{"Dogs"}},
+	}
+	for _, image := range expected {
+		if got, _ := db.GetImage(image.File); !reflect.DeepEqual(*got, image) {
+			format, args := testutil.FormatTestError(
+				"Expected an assignment to lift the rejection of the target and a rejection to remove its proposal.",
+				map[string]interface{}{
+					"expected": image,
+					"got":      got,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+// brokenUpdateStore fails to update the image of a single file.
+type brokenUpdateStore struct {
+	*memory.Store
+	file string
+}
+
+func (s brokenUpdateStore) UpdateImage(file string, update func(image *model.Image) error) (*model.Image, error) {
+	if file == s.file {
+		return nil, errStoreFailed
+	}
+	return s.Store.UpdateImage(file, update)
+}
+
+func TestMergeCategoryFailure(t *testing.T) {
+	config.Load()
+
+	db := libraryStore(t, []model.Category{
+		{ID: util.StringPtr(dogsID), Name: "Dog"},
+		{ID: util.StringPtr(kittensID), Name: "Dogs"},
+	}, []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"Dog"}},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Dog"}},
+	})
+	ctrl := controller.New(store.Store{Images: brokenUpdateStore{db, "processed/b.jpg"}, Categories: db})
+
+	if _, err := ctrl.MergeCategory(dogsID, model.CategoryMergeRequest{TargetID: kittensID}); !errors.Is(err, errStoreFailed) {
+		t.Errorf("Expected the failed image update to be returned, got %v.", err)
+	}
+
+	expectedCategories := []model.Category{{ID: util.StringPtr(kittensID), Name: "Dogs", Aliases: []string{"Dog"}}}
+	if categories, _ := db.QueryCategories(); !reflect.DeepEqual(categories, expectedCategories) {
+		format, args := testutil.FormatTestError(
+			"Expected the categories to be merged before the images are rewritten.",
+			map[string]interface{}{
+				"expected": expectedCategories,
+				"got":      categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	ctrl = controller.New(store.Store{Images: db, Categories: db})
+	if migration, err := ctrl.MigrateCategories(); err != nil || migration.ImagesUpdated != 1 {
+		t.Errorf("Expected the image left by the failure to be migrated, got %+v (%v).", migration, err)
+	}
+	for _, file := range []string{"processed/a.jpg", "processed/b.jpg"} {
+		if image, _ := db.GetImage(file); !reflect.DeepEqual(image.AssignedCategories, []string{"Dogs"}) {
+			t.Errorf("Expected %s to refer to the target, got %v.", file, image.AssignedCategories)
+		}
+	}
+}
+
+func TestCategoryAliases(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.KeywordImportIPTC = "assign"
+
+	db := libraryStore(t, []model.Category{{Name: "Cats", Aliases: []string{"Kitty", "Cat"}}, {Name: "Dogs"}}, []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"kitty", "Dogs"},
+			ProposedCategories: []model.Proposal{{Category: "cat", Score: 0.5}},
+			StarredCategory:    util.StringPtr("KITTY"),
+		},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	if migration, err := ctrl.MigrateCategories(); err != nil || migration.ImagesUpdated != 1 || len(migration.CategoriesCreated) != 0 {
+		t.Errorf("Expected the aliases of the image to be migrated to the category, got %+v (%v).", migration, err)
+	}
+	expected := model.Image{
+		File:               "processed/a.jpg",
+		AssignedCategories: []string{"Cats", "Dogs"},
+		ProposedCategories: []model.Proposal{{Category: "Cats", Score: 0.5}},
+		StarredCategory:    util.StringPtr("Cats"),
+	}
+	if image, _ := db.GetImage("processed/a.jpg"); !reflect.DeepEqual(*image, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the aliases to be replaced by the name of the category.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if images, _, err := ctrl.GetImages("categorized", model.ImageOptions{}, []string{"kitty"}); err != nil || len(images) != 1 {
+		format, args := testutil.FormatTestError(
+			"Expected a search by alias to match the images of the category.",
+			map[string]interface{}{
+				"error": err,
+				"got":   images,
+			})
+		t.Errorf(format, args...)
+	}
+
+	writeImageFiles(t, map[string]string{"unprocessed/b.jpg": iptcJPEG("Kitty", "cat")})
+	image, _, err := ctrl.IndexFile("unprocessed/b.jpg")
+	if err != nil || !reflect.DeepEqual(image.AssignedCategories, []string{"Cats"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the imported keywords to be resolved to the category of the alias.",
+			map[string]interface{}{
+				"error": err,
+				"got":   image,
+			})
+		t.Errorf(format, args...)
+	}
+	if categories, _ := db.QueryCategories(); len(categories) != 2 {
+		t.Errorf("Expected no category to be created for an alias, got %v.", categories)
+	}
+}
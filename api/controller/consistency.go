@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/util"
+)
+
+// ErrNoIssue indicates that a file to repair has no consistency issue.
+var ErrNoIssue = errors.New("the file has no consistency issue")
+
+// ErrUnknownRepair indicates a repair action other than "reimport", "unprocess" or "prune".
+var ErrUnknownRepair = errors.New(`the repair action has to be either "reimport", "unprocess" or "prune"`)
+
+// ErrInvalidRepair indicates a repair action that the issue of a file doesn't offer.
+var ErrInvalidRepair = errors.New("the repair action can't be applied to the issue of the file")
+
+// CheckConsistency compares the images of the store with the files of the unprocessed and processed folder.
+// An image whose file is missing has a stale path, if a file without image and with the same hash exists.
+// Files of the unprocessed folder without an image are reported as orphaned as well, see Reindex().
+func (c *Controller) CheckConsistency() (*model.ConsistencyReport, error) {
+	report := &model.ConsistencyReport{
+		MissingFiles:  []model.ConsistencyIssue{},
+		OrphanedFiles: []model.ConsistencyIssue{},
+		StalePaths:    []model.ConsistencyIssue{},
+	}
+
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := map[string]bool{}
+	for _, image := range images {
+		recorded[image.File] = true
+	}
+
+	orphans := []string{}
+	for _, folder := range []string{config.Get().UnprocessedImagesFolder, config.Get().ProcessedImagesFolder} {
+		files, err := listFiles(folder)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if !recorded[file] {
+				orphans = append(orphans, file)
+			}
+		}
+	}
+
+	// The orphans are only hashed if there are images with missing files.
+	var hashes map[string]string
+	claimed := map[string]bool{}
+
+	for _, image := range images {
+		if _, err := os.Stat(filepath.Join(config.Get().Images, image.File)); !os.IsNotExist(err) {
+			continue
+		}
+
+		if hashes == nil {
+			hashes = map[string]string{}
+			for _, orphan := range orphans {
+				if hash, err := HashFile(filepath.Join(config.Get().Images, orphan)); err != nil {
+					logger.Logger().Warnw("Unable to hash an orphaned file.", "file", orphan, "error", err)
+				} else {
+					hashes[orphan] = hash
+				}
+			}
+		}
+
+		path := ""
+		for _, orphan := range orphans {
+			if image.Hash != "" && hashes[orphan] == image.Hash && !claimed[orphan] {
+				path = orphan
+				claimed[orphan] = true
+				break
+			}
+		}
+
+		if path != "" {
+			report.StalePaths = append(report.StalePaths, model.ConsistencyIssue{
+				File:    image.File,
+				Path:    path,
+				Repairs: []string{model.RepairReimport, model.RepairPrune},
+			})
+		} else {
+			report.MissingFiles = append(report.MissingFiles, model.ConsistencyIssue{
+				File:    image.File,
+				Repairs: []string{model.RepairPrune},
+			})
+		}
+	}
+
+	for _, orphan := range orphans {
+		if claimed[orphan] {
+			continue
+		}
+
+		repairs := []string{model.RepairReimport}
+		if imageFolder(orphan) == config.Get().ProcessedImagesFolder {
+			repairs = append(repairs, model.RepairUnprocess)
+		}
+		report.OrphanedFiles = append(report.OrphanedFiles, model.ConsistencyIssue{File: orphan, Repairs: repairs})
+	}
+
+	return report, nil
+}
+
+// Repair applies a repair action to the consistency issues of the given files,
+// or to all issues that offer the action if no files are given.
+// Every issue is repaired on its own, a failed repair doesn't affect the others.
+// Unknown actions result in ErrUnknownRepair.
+func (c *Controller) Repair(action string, files []string) ([]model.RepairResult, error) {
+	if !util.ContainsString([]string{model.RepairReimport, model.RepairUnprocess, model.RepairPrune}, action, true) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRepair, action)
+	}
+
+	report, err := c.CheckConsistency()
+	if err != nil {
+		return nil, err
+	}
+
+	issues := map[string]model.ConsistencyIssue{}
+	all := []string{}
+	for _, list := range [][]model.ConsistencyIssue{report.MissingFiles, report.OrphanedFiles, report.StalePaths} {
+		for _, issue := range list {
+			issues[issue.File] = issue
+			if util.ContainsString(issue.Repairs, action, true) {
+				all = append(all, issue.File)
+			}
+		}
+	}
+	if len(files) == 0 {
+		files = all
+	}
+
+	results := make([]model.RepairResult, len(files))
+	for k, file := range files {
+		results[k] = model.RepairResult{File: file}
+
+		issue, ok := issues[file]
+		if !ok {
+			err = ErrNoIssue
+		} else if !util.ContainsString(issue.Repairs, action, true) {
+			err = fmt.Errorf("%w: %s", ErrInvalidRepair, action)
+		} else {
+			results[k].Image, err = c.repair(action, issue)
+		}
+
+		if err != nil {
+			logger.Logger().Warnw("Unable to repair a consistency issue.", "file", file, "action", action, "error", err)
+			results[k].Error = err.Error()
+		} else {
+			logger.Logger().Infow("Consistency issue repaired.", "file", file, "action", action)
+		}
+	}
+
+	return results, nil
+}
+
+// repair applies a repair action to an issue and returns the repaired image, if any.
+func (c *Controller) repair(action string, issue model.ConsistencyIssue) (*model.Image, error) {
+	switch action {
+	case model.RepairReimport:
+		// The image of a stale path is adopted by the image of its new path.
+		path := issue.File
+		if issue.Path != "" {
+			path = issue.Path
+		}
+		image, _, err := c.IndexFile(path)
+		return image, err
+	case model.RepairUnprocess:
+		file, err := unprocessFile(issue.File)
+		if err != nil {
+			return nil, err
+		}
+		image, _, err := c.IndexFile(file)
+		return image, err
+	case model.RepairPrune:
+		return nil, c.store.Images.DeleteImage(issue.File)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRepair, action)
+	}
+}
+
+// unprocessFile moves a processed file back into the unprocessed folder and returns its new relative path.
+// Files of subfolders keep their subfolders. The file gets a -N suffix if the name is already taken.
+func unprocessFile(file string) (string, error) {
+	root := config.Get().Images
+	folder := filepath.Join(config.Get().UnprocessedImagesFolder, filepath.Dir(subPath(file)))
+	imageDir := filepath.Join(root, folder)
+
+	if err := os.MkdirAll(imageDir, 0755); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(file)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+
+		// Link fails if the target exists, which makes claiming the name atomic.
+		if err := os.Link(filepath.Join(root, file), filepath.Join(imageDir, candidate)); err == nil {
+			return filepath.Join(folder, candidate), os.Remove(filepath.Join(root, file))
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
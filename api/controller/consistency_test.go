@@ -0,0 +1,142 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+func TestConsistency(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	files := map[string]string{
+		"processed/a.jpg":      "a",
+		"processed/new.jpg":    "moved",
+		"processed/orphan.jpg": "orphan",
+		"unprocessed/u.jpg":    "u",
+	}
+	for file, content := range files {
+		path := filepath.Join(configuration.Images, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal("Unable to create the image folder.", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal("Unable to create the file fixture.", err)
+		}
+	}
+
+	moved, _ := controller.HashFile(filepath.Join(configuration.Images, "processed/new.jpg"))
+	for _, image := range []model.Image{
+		{File: "processed/a.jpg"},
+		{File: "processed/missing.jpg", Hash: "missing"},
+		{File: "processed/old.jpg", Hash: moved, AssignedCategories: []string{"Category 1"}},
+	} {
+		db.UpsertImage(image)
+	}
+
+	expected := &model.ConsistencyReport{
+		MissingFiles: []model.ConsistencyIssue{
+			{File: "processed/missing.jpg", Repairs: []string{model.RepairPrune}},
+		},
+		OrphanedFiles: []model.ConsistencyIssue{
+			{File: "unprocessed/u.jpg", Repairs: []string{model.RepairReimport}},
+			{File: "processed/orphan.jpg", Repairs: []string{model.RepairReimport, model.RepairUnprocess}},
+		},
+		StalePaths: []model.ConsistencyIssue{
+			{File: "processed/old.jpg", Path: "processed/new.jpg", Repairs: []string{model.RepairReimport, model.RepairPrune}},
+		},
+	}
+	report, err := ctrl.CheckConsistency()
+	if err != nil || !reflect.DeepEqual(report, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected missing files, orphaned files and stale paths to be reported.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      report,
+			})
+		t.Errorf(format, args...)
+	}
+
+	results, err := ctrl.Repair(model.RepairReimport, []string{"processed/old.jpg"})
+	if err != nil || len(results) != 1 || results[0].Image == nil ||
+		results[0].Image.File != "processed/new.jpg" ||
+		!reflect.DeepEqual(results[0].Image.AssignedCategories, []string{"Category 1"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the image of a stale path to be moved to the new path.",
+			map[string]interface{}{
+				"error":   err,
+				"results": results,
+			})
+		t.Errorf(format, args...)
+	}
+
+	results, err = ctrl.Repair(model.RepairUnprocess, nil)
+	if err != nil || len(results) != 1 || results[0].Error != "" || results[0].Image == nil ||
+		results[0].Image.File != "unprocessed/orphan.jpg" || !results[0].Image.Unprocessed {
+		format, args := testutil.FormatTestError(
+			"Expected the orphaned file to be moved back to the unprocessed folder.",
+			map[string]interface{}{
+				"error":   err,
+				"results": results,
+			})
+		t.Errorf(format, args...)
+	}
+
+	results, err = ctrl.Repair(model.RepairPrune, []string{"processed/missing.jpg", "processed/a.jpg", "unprocessed/u.jpg"})
+	if err != nil || len(results) != 3 || results[0].Error != "" ||
+		results[1].Error != controller.ErrNoIssue.Error() || !strings.HasPrefix(results[2].Error, controller.ErrInvalidRepair.Error()) {
+		format, args := testutil.FormatTestError(
+			"Expected the image of the missing file to be pruned and the other repairs to fail.",
+			map[string]interface{}{
+				"error":   err,
+				"results": results,
+			})
+		t.Errorf(format, args...)
+	}
+
+	for _, files := range [][]string{nil, {"unprocessed/u.jpg"}} {
+		if _, err := ctrl.Repair("delete", files); !errors.Is(err, controller.ErrUnknownRepair) {
+			format, args := testutil.FormatTestError(
+				"Expected an unknown repair action to be rejected before anything is repaired.",
+				map[string]interface{}{
+					"files": files,
+					"error": err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	expected = &model.ConsistencyReport{
+		MissingFiles: []model.ConsistencyIssue{},
+		OrphanedFiles: []model.ConsistencyIssue{
+			{File: "unprocessed/u.jpg", Repairs: []string{model.RepairReimport}},
+		},
+		StalePaths: []model.ConsistencyIssue{},
+	}
+	if report, err := ctrl.CheckConsistency(); err != nil || !reflect.DeepEqual(report, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected only the unprocessed orphan to be left.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      report,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,434 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/util"
+)
+
+// Layouts of the links and formats of the manifest of a dataset, see ExportDataset().
+const (
+	DatasetSymlink  = "symlink"
+	DatasetHardlink = "hardlink"
+	DatasetCSV      = "csv"
+	DatasetJSONL    = "jsonl"
+	DatasetNone     = "none"
+)
+
+// Splits of a dataset.
+const (
+	SplitTrain = "train"
+	SplitVal   = "val"
+	SplitTest  = "test"
+)
+
+// datasetStateFile records the links of a dataset, so that an export only touches the links of changed images.
+const datasetStateFile = ".tagallery-dataset.json"
+
+// ErrInvalidDataset indicates a dataset request with an invalid name, layout, manifest format or split.
+var ErrInvalidDataset = errors.New("invalid dataset request")
+
+// datasetState is the content of the datasetStateFile. Links maps the links, relative to the dataset,
+// to the hash of their image.
+type datasetState struct {
+	Layout string            `json:"layout"`
+	Links  map[string]string `json:"links"`
+}
+
+// datasetImage is an image of a dataset with the categories that are exported and its split.
+type datasetImage struct {
+	file       string
+	hash       string
+	categories []string
+	stratum    string
+	split      string
+}
+
+// ExportDataset materializes the categorized images as a training dataset in a folder of the datasets folder.
+// Every image is assigned to the train, val or test split. The split is stratified by the starred category
+// of the images, or by their first category in alphabetical order: within each stratum the images are ordered
+// by a hash of the seed and their file, and the first ones go into the test split, the next ones into the val
+// split. The same seed always results in the same split, new images hardly move the other ones.
+// The images are linked as <split>/<category>/<file>, images with multiple categories in each category folder,
+// the subfolders of the files are flattened into the names of the links, see datasetFiles(), and categories
+// whose folders collide get distinct folders, see datasetFolders().
+// The manifest lists every image with its split and categories. Exports are incremental, only the links of
+// changed images are touched and the manifest is only rewritten if it changes.
+func (c *Controller) ExportDataset(request model.DatasetRequest) (*model.DatasetReport, error) {
+	if err := datasetDefaults(&request); err != nil {
+		return nil, err
+	}
+
+	images, err := c.datasetImages(request.Categories)
+	if err != nil {
+		return nil, err
+	}
+	assignSplits(images, *request.Split, request.Seed)
+
+	dir := filepath.Join(config.Get().Datasets, request.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	report := &model.DatasetReport{Path: dir, Images: len(images), Splits: map[string]int{SplitTrain: 0, SplitVal: 0, SplitTest: 0}}
+	for _, image := range images {
+		report.Splits[image.split]++
+	}
+
+	state := readDatasetState(dir)
+	links := map[string]datasetImage{}
+	if request.Links != DatasetNone {
+		names, folders := datasetFiles(images), datasetFolders(images)
+		for _, image := range images {
+			for _, category := range image.categories {
+				links[filepath.Join(image.split, folders[category], names[image.file])] = image
+			}
+		}
+	}
+
+	// Links that are no longer wanted are removed, links of another layout are all replaced.
+	for link := range state.Links {
+		if _, ok := links[link]; !ok {
+			links[link] = datasetImage{}
+		}
+	}
+	replace := state.Layout != request.Links
+	state.Layout = request.Links
+
+	for link, image := range links {
+		path := filepath.Join(dir, link)
+
+		if image.file == "" {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			removeEmptyFolders(filepath.Dir(path), dir)
+			delete(state.Links, link)
+			report.Removed++
+			continue
+		}
+
+		if hash, ok := state.Links[link]; ok && hash == image.hash && !replace {
+			if _, err := os.Lstat(path); err == nil {
+				report.Unchanged++
+				continue
+			}
+		}
+
+		if err := datasetLink(request.Links, filepath.Join(config.Get().Images, image.file), path); err != nil {
+			return nil, err
+		}
+		state.Links[link] = image.hash
+		report.Added++
+	}
+
+	if err := writeDatasetState(dir, state); err != nil {
+		return nil, err
+	}
+	if err := writeManifest(dir, request.Manifest, images); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// datasetDefaults validates a dataset request and sets the defaults of its options.
+func datasetDefaults(request *model.DatasetRequest) error {
+	if request.Name == "" || strings.HasPrefix(request.Name, ".") || strings.ContainsAny(request.Name, `/\`) {
+		return fmt.Errorf("%w: the name has to be a folder name", ErrInvalidDataset)
+	}
+
+	if request.Links == "" {
+		request.Links = DatasetSymlink
+	}
+	if request.Links != DatasetSymlink && request.Links != DatasetHardlink && request.Links != DatasetNone {
+		return fmt.Errorf(`%w: the links have to be either "symlink", "hardlink" or "none"`, ErrInvalidDataset)
+	}
+
+	if request.Manifest == "" {
+		request.Manifest = DatasetCSV
+	}
+	if request.Manifest != DatasetCSV && request.Manifest != DatasetJSONL && request.Manifest != DatasetNone {
+		return fmt.Errorf(`%w: the manifest has to be either "csv", "jsonl" or "none"`, ErrInvalidDataset)
+	}
+
+	if request.Split == nil {
+		request.Split = &model.DatasetSplit{Train: 0.8, Val: 0.1, Test: 0.1}
+	}
+	split := request.Split
+	if split.Train < 0 || split.Val < 0 || split.Test < 0 || math.Abs(split.Train+split.Val+split.Test-1) > 1e-6 {
+		return fmt.Errorf("%w: the split fractions have to add up to 1", ErrInvalidDataset)
+	}
+
+	return nil
+}
+
+// datasetImages returns the processed images with assigned categories, sorted by file. With categories,
+// only these are exported and images without any of them are skipped. Aliases select their category.
+// Images without file are skipped as well.
+func (c *Controller) datasetImages(categories []string) ([]datasetImage, error) {
+	categories, err := c.resolveCategories(categories)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	images := []datasetImage{}
+	for _, image := range all {
+		if image.Unprocessed {
+			continue
+		}
+
+		exported := []string{}
+		for _, category := range image.AssignedCategories {
+			if (len(categories) == 0 || util.ContainsString(categories, category, false)) &&
+				!util.ContainsString(exported, category, false) {
+				exported = append(exported, category)
+			}
+		}
+		if len(exported) == 0 {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(config.Get().Images, image.File)); err != nil {
+			logger.Logger().Warnw("Skipping an image of the dataset without file.", "file", image.File, "error", err)
+			continue
+		}
+
+		sorted := append([]string{}, exported...)
+		sort.Strings(sorted)
+		stratum := sorted[0]
+		if image.StarredCategory != nil && util.ContainsString(exported, *image.StarredCategory, false) {
+			stratum = *image.StarredCategory
+		}
+
+		images = append(images, datasetImage{file: image.File, hash: image.Hash, categories: exported, stratum: stratum})
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].file < images[j].file })
+	return images, nil
+}
+
+// assignSplits assigns the images to the splits, stratified by their stratum, see ExportDataset().
+func assignSplits(images []datasetImage, split model.DatasetSplit, seed int64) {
+	strata := map[string][]int{}
+	keys := make([]string, len(images))
+	for k, image := range images {
+		strata[image.stratum] = append(strata[image.stratum], k)
+		hash := sha256.Sum256([]byte(strconv.FormatInt(seed, 10) + ":" + image.file))
+		keys[k] = hex.EncodeToString(hash[:])
+	}
+
+	for _, members := range strata {
+		sort.Slice(members, func(i, j int) bool { return keys[members[i]] < keys[members[j]] })
+
+		n := float64(len(members))
+		test := int(math.Round(n * split.Test))
+		val := int(math.Min(math.Round(n*split.Val), n-float64(test)))
+		for k, member := range members {
+			switch {
+			case k < test:
+				images[member].split = SplitTest
+			case k < test+val:
+				images[member].split = SplitVal
+			default:
+				images[member].split = SplitTrain
+			}
+		}
+	}
+}
+
+// datasetFolder returns the folder name of a category, without path separators and not hidden.
+func datasetFolder(category string) string {
+	folder := strings.NewReplacer("/", "_", `\`, "_").Replace(category)
+	if strings.HasPrefix(folder, ".") {
+		folder = "_" + folder
+	}
+	return folder
+}
+
+// datasetFolders maps the categories of the images to their folders, see datasetFolder(). Categories whose
+// folders collide, like a/b and a_b, get a hash of their name as suffix, e. g. a_b-1f2e3d4c, like datasetFiles().
+func datasetFolders(images []datasetImage) map[string]string {
+	byName := map[string][]string{}
+	for _, image := range images {
+		for _, category := range image.categories {
+			name := datasetFolder(category)
+			if !util.ContainsString(byName[name], category, true) {
+				byName[name] = append(byName[name], category)
+			}
+		}
+	}
+
+	folders := map[string]string{}
+	for name, categories := range byName {
+		for _, category := range categories {
+			if len(categories) == 1 {
+				folders[category] = name
+				continue
+			}
+			sum := sha256.Sum256([]byte(category))
+			folders[category] = name + "-" + hex.EncodeToString(sum[:4])
+		}
+	}
+	return folders
+}
+
+// datasetFile returns the name of the link of a file, e. g. Vacation__beach.jpg for processed/Vacation/beach.jpg.
+func datasetFile(file string) string {
+	return strings.ReplaceAll(filepath.ToSlash(subPath(file)), "/", "__")
+}
+
+// datasetFiles maps the files of the images to the names of their links, see datasetFile(). Files whose names
+// collide, like a__b.jpg and a/b.jpg, get a hash of their file as suffix, e. g. a__b-1f2e3d4c.jpg, which keeps
+// the names independent of the order of the images.
+func datasetFiles(images []datasetImage) map[string]string {
+	byName := map[string][]string{}
+	for _, image := range images {
+		name := datasetFile(image.file)
+		byName[name] = append(byName[name], image.file)
+	}
+
+	names := map[string]string{}
+	for name, files := range byName {
+		for _, file := range files {
+			if len(files) == 1 {
+				names[file] = name
+				continue
+			}
+			sum := sha256.Sum256([]byte(file))
+			ext := filepath.Ext(name)
+			names[file] = strings.TrimSuffix(name, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+		}
+	}
+	return names
+}
+
+// datasetLink creates or replaces the link of an image.
+func datasetLink(layout string, source string, link string) error {
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if layout == DatasetHardlink {
+		return os.Link(source, link)
+	}
+
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(source, link)
+}
+
+// removeEmptyFolders removes a folder and its parents up to the root, as long as they are empty.
+func removeEmptyFolders(folder string, root string) {
+	for folder != root && strings.HasPrefix(folder, root) {
+		if err := os.Remove(folder); err != nil {
+			return
+		}
+		folder = filepath.Dir(folder)
+	}
+}
+
+// readDatasetState reads the state of a dataset. A missing or unreadable state results in an empty state.
+func readDatasetState(dir string) *datasetState {
+	state := &datasetState{}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, datasetStateFile)); err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			logger.Logger().Warnw("Ignoring the invalid state of a dataset.", "dataset", dir, "error", err)
+		}
+	}
+	if state.Links == nil {
+		state.Links = map[string]string{}
+	}
+	return state
+}
+
+// writeDatasetState writes the state of a dataset.
+func writeDatasetState(dir string, state *datasetState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, datasetStateFile), data, 0644)
+}
+
+// manifestEscaper escapes the separator of the categories of the CSV manifest and the escape character itself.
+var manifestEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`)
+
+// writeManifest writes the manifest of a dataset, unless it didn't change. The manifests of the other formats
+// are removed. The CSV manifest has the columns file, path, split and categories, which are separated by
+// semicolons, see manifestEscaper. The JSONL manifest has an object with these fields per line.
+func writeManifest(dir string, format string, images []datasetImage) error {
+	for _, other := range []string{DatasetCSV, DatasetJSONL} {
+		if other != format {
+			if err := os.Remove(filepath.Join(dir, "manifest."+other)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	if format == DatasetNone {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if format == DatasetCSV {
+		writer := csv.NewWriter(&buf)
+		_ = writer.Write([]string{"file", "path", "split", "categories"})
+		for _, image := range images {
+			path, _ := filepath.Abs(filepath.Join(config.Get().Images, image.file))
+			categories := make([]string, len(image.categories))
+			for k, category := range image.categories {
+				categories[k] = manifestEscaper.Replace(category)
+			}
+			_ = writer.Write([]string{image.file, path, image.split, strings.Join(categories, ";")})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	} else {
+		encoder := json.NewEncoder(&buf)
+		for _, image := range images {
+			path, _ := filepath.Abs(filepath.Join(config.Get().Images, image.file))
+			if err := encoder.Encode(map[string]interface{}{
+				"file":       image.file,
+				"path":       path,
+				"split":      image.split,
+				"categories": image.categories,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	manifest := filepath.Join(dir, "manifest."+format)
+	if existing, err := ioutil.ReadFile(manifest); err == nil && bytes.Equal(existing, buf.Bytes()) {
+		return nil
+	}
+	return ioutil.WriteFile(manifest, buf.Bytes(), 0644)
+}
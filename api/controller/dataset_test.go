@@ -0,0 +1,328 @@
+package controller_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/util"
+)
+
+func TestExportDataset(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Datasets = t.TempDir()
+
+	db := libraryStore(t, nil, []model.Image{
+		{File: "processed/Trip/a.jpg", AssignedCategories: []string{"Cat", "Dog"}, StarredCategory: util.StringPtr("Dog"), Hash: "a"},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Cat"}, Hash: "b"},
+		{File: "processed/c.jpg", AssignedCategories: []string{}, Hash: "c"},
+		{File: "unprocessed/d.jpg", AssignedCategories: []string{"Cat"}, Unprocessed: true, Hash: "d"},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	writeImageFiles(t, map[string]string{
+		"processed/Trip/a.jpg": "a",
+		"processed/b.jpg":      "b",
+		"processed/c.jpg":      "c",
+		"unprocessed/d.jpg":    "d",
+	})
+
+	request := model.DatasetRequest{Name: "pets", Split: &model.DatasetSplit{Train: 1}}
+	report, err := ctrl.ExportDataset(request)
+	if err != nil {
+		t.Fatal("Unable to export the dataset.", err)
+	}
+
+	dir := filepath.Join(configuration.Datasets, "pets")
+	expectedReport := &model.DatasetReport{
+		Path:   dir,
+		Images: 2,
+		Splits: map[string]int{controller.SplitTrain: 2, controller.SplitVal: 0, controller.SplitTest: 0},
+		Added:  3,
+	}
+	if !reflect.DeepEqual(report, expectedReport) {
+		format, args := testutil.FormatTestError(
+			"Expected a link per category of the processed images.",
+			map[string]interface{}{
+				"expected": expectedReport,
+				"got":      report,
+			})
+		t.Errorf(format, args...)
+	}
+
+	for _, link := range []string{"train/Cat/Trip__a.jpg", "train/Dog/Trip__a.jpg", "train/Cat/b.jpg"} {
+		if target, err := os.Readlink(filepath.Join(dir, link)); err != nil || !filepath.IsAbs(target) {
+			t.Errorf("Expected %s to be a symlink to the absolute path of the image, got %s (%v).", link, target, err)
+		}
+	}
+
+	manifest, _ := ioutil.ReadFile(filepath.Join(dir, "manifest.csv"))
+	expectedManifest := fmt.Sprintf("file,path,split,categories\nprocessed/Trip/a.jpg,%s,train,Cat;Dog\nprocessed/b.jpg,%s,train,Cat\n",
+		filepath.Join(configuration.Images, "processed/Trip/a.jpg"), filepath.Join(configuration.Images, "processed/b.jpg"))
+	if string(manifest) != expectedManifest {
+		format, args := testutil.FormatTestError(
+			"Expected the manifest to list the images with their split and categories.",
+			map[string]interface{}{
+				"expected": expectedManifest,
+				"got":      string(manifest),
+			})
+		t.Errorf(format, args...)
+	}
+
+	// The second export only touches the changed images.
+	if _, err := db.UpdateImage("processed/b.jpg", func(image *model.Image) error {
+		image.AssignedCategories = []string{}
+		return nil
+	}); err != nil {
+		t.Fatal("Unable to update the image.", err)
+	}
+	request.Manifest = controller.DatasetJSONL
+	if report, err = ctrl.ExportDataset(request); err != nil {
+		t.Fatal("Unable to export the dataset again.", err)
+	}
+	if report.Added != 0 || report.Removed != 1 || report.Unchanged != 2 {
+		t.Errorf("Expected only the link of the changed image to be removed, got %+v.", report)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "train/Cat/b.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale link to be removed, got %v.", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manifest.csv")); !os.IsNotExist(err) {
+		t.Errorf("Expected the manifest of the previous format to be removed, got %v.", err)
+	}
+	if manifest, _ := ioutil.ReadFile(filepath.Join(dir, "manifest.jsonl")); !strings.Contains(string(manifest), `"categories":["Cat","Dog"]`) ||
+		strings.Count(string(manifest), "\n") != 1 {
+		t.Errorf("Expected a JSONL manifest with a line per image, got %s.", manifest)
+	}
+
+	// Another layout replaces all links.
+	request.Links = controller.DatasetHardlink
+	if report, err = ctrl.ExportDataset(request); err != nil {
+		t.Fatal("Unable to export the dataset with hardlinks.", err)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "train/Dog/Trip__a.jpg")); err != nil || report.Added != 2 ||
+		info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("Expected the symlinks to be replaced by hardlinks, got %+v (%v).", report, err)
+	}
+}
+
+func TestExportDatasetSplit(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Datasets = t.TempDir()
+
+	images := []model.Image{}
+	files := map[string]string{}
+	for k := 0; k < 20; k++ {
+		category := "Cat"
+		if k%2 == 0 {
+			category = "Dog"
+		}
+		file := fmt.Sprintf("processed/%02d.jpg", k)
+		images = append(images, model.Image{File: file, AssignedCategories: []string{category}, Hash: file})
+		files[file] = file
+	}
+	db := libraryStore(t, nil, images)
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	writeImageFiles(t, files)
+
+	request := model.DatasetRequest{
+		Name:     "split",
+		Links:    controller.DatasetNone,
+		Split:    &model.DatasetSplit{Train: 0.6, Val: 0.2, Test: 0.2},
+		Seed:     42,
+		Manifest: controller.DatasetCSV,
+	}
+	report, err := ctrl.ExportDataset(request)
+	if err != nil {
+		t.Fatal("Unable to export the dataset.", err)
+	}
+	expected := map[string]int{controller.SplitTrain: 12, controller.SplitVal: 4, controller.SplitTest: 4}
+	if !reflect.DeepEqual(report.Splits, expected) || report.Added != 0 {
+		format, args := testutil.FormatTestError(
+			"Expected the split to be stratified by category.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      report,
+			})
+		t.Errorf(format, args...)
+	}
+
+	manifest := filepath.Join(configuration.Datasets, "split", "manifest.csv")
+	first, _ := ioutil.ReadFile(manifest)
+	for _, line := range strings.Split(string(first), "\n") {
+		if strings.HasSuffix(line, ",test,Cat") {
+			expected[controller.SplitTest]--
+		}
+	}
+	if expected[controller.SplitTest] != 2 {
+		t.Errorf("Expected 2 cats in the test split, got %s.", first)
+	}
+
+	if _, err := ctrl.ExportDataset(request); err != nil {
+		t.Fatal("Unable to export the dataset again.", err)
+	}
+	if second, _ := ioutil.ReadFile(manifest); string(second) != string(first) {
+		t.Errorf("Expected the same seed to result in the same split, got %s and %s.", first, second)
+	}
+
+	request.Seed = 7
+	if _, err := ctrl.ExportDataset(request); err != nil {
+		t.Fatal("Unable to export the dataset with another seed.", err)
+	}
+	if third, _ := ioutil.ReadFile(manifest); string(third) == string(first) {
+		t.Errorf("Expected another seed to result in another split, got %s.", third)
+	}
+}
+
+func TestExportDatasetCollision(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Datasets = t.TempDir()
+
+	db := libraryStore(t, nil, []model.Image{
+		{File: "processed/Trip/a.jpg", AssignedCategories: []string{"Cat"}, Hash: "a"},
+		{File: "processed/Trip__a.jpg", AssignedCategories: []string{"Cat"}, Hash: "b"},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	writeImageFiles(t, map[string]string{"processed/Trip/a.jpg": "a", "processed/Trip__a.jpg": "b"})
+
+	report, err := ctrl.ExportDataset(model.DatasetRequest{Name: "pets", Split: &model.DatasetSplit{Train: 1}})
+	if err != nil {
+		t.Fatal("Unable to export the dataset.", err)
+	}
+
+	links, _ := filepath.Glob(filepath.Join(configuration.Datasets, "pets", "train", "Cat", "*"))
+	targets := map[string]bool{}
+	for _, link := range links {
+		if target, err := os.Readlink(link); err == nil && strings.HasPrefix(filepath.Base(link), "Trip__a-") {
+			targets[target] = true
+		}
+	}
+	if report.Added != 2 || len(links) != 2 || len(targets) != 2 {
+		format, args := testutil.FormatTestError(
+			"Expected files whose flattened names collide to get distinct links.",
+			map[string]interface{}{
+				"report": report,
+				"links":  links,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestExportDatasetFolderCollision(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Datasets = t.TempDir()
+
+	db := libraryStore(t, nil, []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"a/b"}, Hash: "a"},
+		{File: "processed/b.jpg", AssignedCategories: []string{"a_b"}, Hash: "b"},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	writeImageFiles(t, map[string]string{"processed/a.jpg": "a", "processed/b.jpg": "b"})
+
+	if _, err := ctrl.ExportDataset(model.DatasetRequest{Name: "pets", Split: &model.DatasetSplit{Train: 1}}); err != nil {
+		t.Fatal("Unable to export the dataset.", err)
+	}
+
+	folders := map[string][]string{}
+	links, _ := filepath.Glob(filepath.Join(configuration.Datasets, "pets", "train", "*", "*"))
+	for _, link := range links {
+		folder := filepath.Base(filepath.Dir(link))
+		folders[folder] = append(folders[folder], filepath.Base(link))
+	}
+	if len(folders) != 2 {
+		format, args := testutil.FormatTestError(
+			"Expected categories whose folders collide to get distinct folders.",
+			map[string]interface{}{
+				"got": folders,
+			})
+		t.Errorf(format, args...)
+	}
+	for folder, files := range folders {
+		if !strings.HasPrefix(folder, "a_b-") || len(files) != 1 {
+			t.Errorf("Expected the folder %s of a colliding category to have a suffix and one image, got %v.", folder, files)
+		}
+	}
+}
+
+func TestExportDatasetManifestSeparator(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Datasets = t.TempDir()
+
+	db := libraryStore(t, nil, []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"Cat;Dog", `Mouse\`}, Hash: "a"},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	writeImageFiles(t, map[string]string{"processed/a.jpg": "a"})
+
+	request := model.DatasetRequest{Name: "pets", Links: controller.DatasetNone, Split: &model.DatasetSplit{Train: 1}}
+	if _, err := ctrl.ExportDataset(request); err != nil {
+		t.Fatal("Unable to export the dataset.", err)
+	}
+
+	manifest, _ := ioutil.ReadFile(filepath.Join(configuration.Datasets, "pets", "manifest.csv"))
+	if !strings.HasSuffix(string(manifest), `,train,Cat\;Dog;Mouse\\`+"\n") {
+		t.Errorf("Expected the separators in the categories of the CSV manifest to be escaped, got %s.", manifest)
+	}
+}
+
+func TestExportDatasetAlias(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Datasets = t.TempDir()
+
+	db := libraryStore(t, []model.Category{{Name: "Cat", Aliases: []string{"Kitty"}}, {Name: "Dog"}}, []model.Image{
+		{File: "processed/a.jpg", AssignedCategories: []string{"Cat"}, Hash: "a"},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Dog"}, Hash: "b"},
+	})
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	writeImageFiles(t, map[string]string{"processed/a.jpg": "a", "processed/b.jpg": "b"})
+
+	report, err := ctrl.ExportDataset(model.DatasetRequest{Name: "pets", Categories: []string{"kitty"}, Split: &model.DatasetSplit{Train: 1}})
+	if err != nil {
+		t.Fatal("Unable to export the dataset.", err)
+	}
+	if _, err := os.Lstat(filepath.Join(configuration.Datasets, "pets", "train", "Cat", "a.jpg")); err != nil || report.Images != 1 {
+		t.Errorf("Expected an alias to select the images of its category, got %+v (%v).", report, err)
+	}
+}
+
+func TestExportDatasetInvalid(t *testing.T) {
+	configuration := config.Load()
+	configuration.Datasets = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	for _, request := range []model.DatasetRequest{
+		{Name: "../pets"},
+		{Name: ".pets"},
+		{Name: "pets", Links: "copy"},
+		{Name: "pets", Manifest: "xml"},
+		{Name: "pets", Split: &model.DatasetSplit{Train: 0.8, Val: 0.3, Test: -0.1}},
+		{Name: "pets", Split: &model.DatasetSplit{Train: 0.5}},
+	} {
+		if _, err := ctrl.ExportDataset(request); !errors.Is(err, controller.ErrInvalidDataset) {
+			format, args := testutil.FormatTestError(
+				"Expected an invalid dataset request to be rejected.",
+				map[string]interface{}{
+					"request": request,
+					"got":     err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/thumbnail"
+)
+
+// ErrInvalidPath indicates that a requested file lies outside of the image folders.
+var ErrInvalidPath = errors.New("the path does not point to a file inside the image folders")
+
+// MinThumbnailSize and MaxThumbnailSize limit the size of a thumbnail in pixels.
+const (
+	MinThumbnailSize = 16
+	MaxThumbnailSize = 2048
+)
+
+// ErrInvalidThumbnailSize indicates that a requested thumbnail size is out of bounds.
+var ErrInvalidThumbnailSize = errors.New("the thumbnail size is out of bounds")
+
+// ImagePath resolves the path of an image, relative to the images root like model.Image.File, to the absolute path.
+// Only files inside the unprocessed and processed folders may be resolved, which also rules out
+// path traversal via ".." or symlinks.
+func ImagePath(file string) (string, error) {
+	root, err := filepath.Abs(config.Get().Images)
+	if err != nil {
+		return "", err
+	}
+
+	// Cleaning the path as an absolute one removes all leading "..".
+	rel := strings.TrimPrefix(filepath.Clean(string(filepath.Separator)+file), string(filepath.Separator))
+	folder := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+	if rel == folder ||
+		(folder != config.Get().UnprocessedImagesFolder && folder != config.Get().ProcessedImagesFolder) {
+		return "", ErrInvalidPath
+	}
+
+	path := filepath.Join(root, rel)
+
+	// Symlinks could point anywhere, therefore the resolved path has to be checked again.
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+		return "", ErrInvalidPath
+	}
+
+	return path, nil
+}
+
+// ThumbnailPath returns the path of a thumbnail of an image that fits into a {size}x{size} square.
+// Thumbnails are cached in the thumbnail folder and regenerated once the image changes.
+// Their extension matches the format they are encoded in, see thumbnail.Name().
+func ThumbnailPath(file string, size int) (string, error) {
+	if size < MinThumbnailSize || size > MaxThumbnailSize {
+		return "", ErrInvalidThumbnailSize
+	}
+
+	path, err := ImagePath(file)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", ErrInvalidPath
+	}
+
+	root, err := filepath.Abs(config.Get().Images)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	thumbPath := filepath.Join(root, config.Get().ThumbnailsFolder, strconv.Itoa(size), thumbnail.Name(rel))
+
+	if thumbInfo, err := os.Stat(thumbPath); err == nil && !thumbInfo.ModTime().Before(info.ModTime()) {
+		return thumbPath, nil
+	}
+
+	if err := thumbnail.Create(path, thumbPath, size, config.Get().MaxImagePixels); err != nil {
+		return "", err
+	}
+
+	return thumbPath, nil
+}
@@ -0,0 +1,108 @@
+package controller_test
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/testutil"
+)
+
+func TestImagePath(t *testing.T) {
+	dir := t.TempDir()
+
+	configuration := config.Load()
+	configuration.Images = filepath.Join(dir, "images")
+	processedImages := filepath.Join(configuration.Images, configuration.ProcessedImagesFolder)
+
+	if err := os.MkdirAll(processedImages, 0755); err != nil {
+		t.Fatal("Unable to create the processed image folder.", err)
+	}
+	if err := testutil.TouchFile(filepath.Join(processedImages, "test.jpg")); err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+	if err := testutil.TouchFile(filepath.Join(dir, "secret.txt")); err != nil {
+		t.Fatal("Unable to create the test file.", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(processedImages, "link.jpg")); err != nil {
+		t.Fatal("Unable to create the symlink.", err)
+	}
+
+	path, err := controller.ImagePath("/processed/test.jpg")
+	if err != nil || path != filepath.Join(processedImages, "test.jpg") {
+		format, args := testutil.FormatTestError(
+			"Expected the image path to be resolved.",
+			map[string]interface{}{
+				"error": err,
+				"path":  path,
+			})
+		t.Errorf(format, args...)
+	}
+
+	for _, file := range []string{
+		"../secret.txt",
+		"processed/../../secret.txt",
+		"/processed/../../../secret.txt",
+		"processed",
+		"thumbnails/test.jpg",
+		"processed/link.jpg",
+	} {
+		if _, err := controller.ImagePath(file); !errors.Is(err, controller.ErrInvalidPath) {
+			format, args := testutil.FormatTestError(
+				"Expected paths outside of the image folders to be rejected.",
+				map[string]interface{}{
+					"file":  file,
+					"error": err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestThumbnailPath(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	processedImages := filepath.Join(configuration.Images, configuration.ProcessedImagesFolder)
+
+	if err := os.MkdirAll(processedImages, 0755); err != nil {
+		t.Fatal("Unable to create the processed image folder.", err)
+	}
+	file, err := os.Create(filepath.Join(processedImages, "test.png"))
+	if err == nil {
+		err = png.Encode(file, image.NewNRGBA(image.Rect(0, 0, 200, 100)))
+		file.Close()
+	}
+	if err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+
+	path, err := controller.ThumbnailPath("processed/test.png", 50)
+	expected := filepath.Join(configuration.Images, configuration.ThumbnailsFolder, "50", "processed", "test.png")
+	if err != nil || path != expected {
+		format, args := testutil.FormatTestError(
+			"Expected the thumbnail to be created in the thumbnail folder.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      path,
+			})
+		t.Fatalf(format, args...)
+	}
+
+	info, _ := os.Stat(path)
+	if cached, err := controller.ThumbnailPath("processed/test.png", 50); err != nil || cached != path {
+		t.Error("Expected the cached thumbnail to be returned.", err)
+	}
+	if cachedInfo, _ := os.Stat(path); !cachedInfo.ModTime().Equal(info.ModTime()) {
+		t.Error("Expected the cached thumbnail not to be recreated.")
+	}
+
+	if _, err := controller.ThumbnailPath("processed/test.png", 1); !errors.Is(err, controller.ErrInvalidThumbnailSize) {
+		t.Error("Expected too small thumbnail sizes to be rejected.", err)
+	}
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/util"
+)
+
+// FolderSource is the source of the proposals derived from the subfolders of a file.
+const FolderSource = "folder"
+
+// imageFolder returns the image folder of a file, which is the first element of its path.
+func imageFolder(file string) string {
+	return strings.SplitN(filepath.ToSlash(file), "/", 2)[0]
+}
+
+// isImageFile reports whether a file, relative to the images root, is a clean relative path inside the unprocessed
+// or the processed folder. Absolute paths and paths with ".." elements aren't.
+func isImageFile(file string) bool {
+	if filepath.IsAbs(file) || filepath.Clean(file) != file {
+		return false
+	}
+	for _, element := range strings.Split(filepath.ToSlash(file), "/") {
+		if element == ".." {
+			return false
+		}
+	}
+
+	folder := imageFolder(file)
+	return file != folder && (folder == config.Get().UnprocessedImagesFolder || folder == config.Get().ProcessedImagesFolder)
+}
+
+// isUnprocessed reports whether a file is inside the unprocessed folder or one of its subfolders.
+func isUnprocessed(file string) bool {
+	return imageFolder(file) == config.Get().UnprocessedImagesFolder
+}
+
+// subPath returns the path of a file relative to its image folder.
+func subPath(file string) string {
+	parts := strings.SplitN(filepath.ToSlash(file), "/", 2)
+	return filepath.FromSlash(parts[len(parts)-1])
+}
+
+// listFiles returns the files of an image folder relative to the images root.
+// Subfolders are only included in recursive mode. Hidden files and folders, and XMP sidecars are skipped.
+func listFiles(folder string) ([]string, error) {
+	files := []string{}
+	root := filepath.Join(config.Get().Images, folder)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if path == root && os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if path == root {
+			return nil
+		}
+
+		if info.IsDir() {
+			if !config.Get().Recursive || strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") || metadata.IsSidecar(info.Name()) {
+			return nil
+		}
+
+		file, err := filepath.Rel(config.Get().Images, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+
+	return files, err
+}
+
+// FolderCategories returns the categories derived from the subfolders of a file, e. g. "Vacation" and "2019"
+// for unprocessed/Vacation/2019/beach.jpg. The configured rules "pattern=category" map folder names that
+// match the pattern (see filepath.Match) to another category, or drop them if the category is empty.
+// The first matching rule applies, folders without a matching rule keep their name.
+func FolderCategories(file string) []string {
+	categories := []string{}
+
+	dir := filepath.Dir(subPath(file))
+	if dir == "." {
+		return categories
+	}
+
+	for _, name := range strings.Split(filepath.ToSlash(dir), "/") {
+		category := name
+		for _, rule := range config.Get().FolderCategoryRules {
+			parts := strings.SplitN(rule, "=", 2)
+			if len(parts) != 2 {
+				logger.Logger().Warnw("Ignoring an invalid folder category rule.", "rule", rule)
+				continue
+			}
+			if matched, _ := filepath.Match(strings.TrimSpace(parts[0]), name); matched {
+				category = strings.TrimSpace(parts[1])
+				break
+			}
+		}
+
+		if category != "" && !util.ContainsString(categories, category, false) {
+			categories = append(categories, category)
+		}
+	}
+
+	return categories
+}
+
+// applyFolderCategories proposes or assigns the folder categories of a file to its new image,
+// depending on the configured mode (none, propose or assign). Missing categories are created, folder names
+// that match the name or an alias of a category take its name.
+func (c *Controller) applyFolderCategories(image *model.Image) error {
+	mode := config.Get().FolderCategories
+	if mode != "propose" && mode != "assign" {
+		return nil
+	}
+
+	names, err := c.ensureCategories(FolderCategories(image.File))
+	if err != nil || len(names) == 0 {
+		return err
+	}
+
+	for _, name := range names {
+		if mode == "assign" {
+			if !util.ContainsString(image.AssignedCategories, name, false) {
+				image.AssignedCategories = append(image.AssignedCategories, name)
+			}
+		} else if !util.ContainsString(model.ProposalNames(image.ProposedCategories), name, false) {
+			image.ProposedCategories = append(image.ProposedCategories, model.Proposal{
+				Category: name,
+				Score:    1,
+				Source:   FolderSource,
+			})
+		}
+	}
+
+	return nil
+}
+
+// ensureCategories creates the categories that don't exist yet. It returns the names of the categories,
+// names that match the name or an alias of an existing category are replaced by its name.
+func (c *Controller) ensureCategories(names []string) ([]string, error) {
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := []string{}
+	for _, name := range names {
+		if existing := store.ResolveCategory(categories, name); existing != "" {
+			if !util.ContainsString(resolved, existing, false) {
+				resolved = append(resolved, existing)
+			}
+			continue
+		}
+
+		// The category may have been created concurrently, in another case or as alias.
+		_, err = c.store.Categories.UpsertCategory(model.Category{Name: name})
+		if errors.Is(err, store.ErrDuplicateName) {
+			if categories, err = c.store.Categories.QueryCategories(); err != nil {
+				return nil, err
+			}
+			existing := store.ResolveCategory(categories, name)
+			if existing == "" {
+				return nil, fmt.Errorf("%w: the category %q exists, but can't be found", store.ErrDuplicateName, name)
+			}
+			if !util.ContainsString(resolved, existing, false) {
+				resolved = append(resolved, existing)
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		categories = append(categories, model.Category{Name: name})
+		resolved = append(resolved, name)
+		logger.Logger().Infow("Missing category created.", "category", name)
+	}
+
+	return resolved, nil
+}
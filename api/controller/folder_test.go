@@ -0,0 +1,202 @@
+package controller_test
+
+import (
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+func TestFolderCategories(t *testing.T) {
+	configuration := config.Load()
+	configuration.FolderCategoryRules = []string{"Vacation=Holiday", "tmp*=", "invalid"}
+
+	tests := []struct {
+		file     string
+		expected []string
+	}{
+		{"unprocessed/beach.jpg", []string{}},
+		{"unprocessed/Trips/2019/beach.jpg", []string{"Trips", "2019"}},
+		{"processed/Vacation/2019/beach.jpg", []string{"Holiday", "2019"}},
+		{"unprocessed/tmp1/Holiday/beach.jpg", []string{"Holiday"}},
+	}
+
+	for _, test := range tests {
+		if categories := controller.FolderCategories(test.file); !reflect.DeepEqual(categories, test.expected) {
+			format, args := testutil.FormatTestError(
+				"Unexpected categories of the folders of a file.",
+				map[string]interface{}{
+					"file":     test.file,
+					"expected": test.expected,
+					"got":      categories,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestRecursiveImport(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Recursive = true
+	configuration.FolderCategories = "propose"
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"unprocessed/a.jpg":                  "a",
+		"unprocessed/Vacation/2019/b.jpg":    "b",
+		"unprocessed/.hidden/c.jpg":          "c",
+		"processed/Vacation/d.jpg":           "d",
+		"processed/Vacation/2019/.e.jpg":     "e",
+		"processed/Vacation/2019/more/f.jpg": "f",
+	})
+
+	images, err := ctrl.Rescan()
+	files := []string{}
+	for _, image := range images {
+		files = append(files, image.File)
+	}
+	expected := []string{
+		"unprocessed/Vacation/2019/b.jpg",
+		"unprocessed/a.jpg",
+		"processed/Vacation/2019/more/f.jpg",
+		"processed/Vacation/d.jpg",
+	}
+	if err != nil || !reflect.DeepEqual(files, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the files of the subfolders to be indexed.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      files,
+			})
+		t.Errorf(format, args...)
+	}
+
+	image, err := db.GetImage("unprocessed/Vacation/2019/b.jpg")
+	proposals := []model.Proposal{
+		{Category: "Vacation", Score: 1, Source: controller.FolderSource},
+		{Category: "2019", Score: 1, Source: controller.FolderSource},
+	}
+	if err != nil || !reflect.DeepEqual(image.ProposedCategories, proposals) {
+		format, args := testutil.FormatTestError(
+			"Expected the folders to be proposed as categories.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": proposals,
+				"got":      image,
+			})
+		t.Errorf(format, args...)
+	}
+	if categories, _ := db.QueryCategories(); len(categories) != 3 {
+		t.Errorf("Expected the categories of the folders to be created, got %v.", categories)
+	}
+
+	processed, err := ctrl.UpsertImage(model.Image{
+		File:               "unprocessed/Vacation/2019/b.jpg",
+		AssignedCategories: []string{"Vacation"},
+	})
+	if err != nil || processed.File != "processed/Vacation/2019/b.jpg" {
+		format, args := testutil.FormatTestError(
+			"Expected the processed file to keep its subfolders.",
+			map[string]interface{}{
+				"error": err,
+				"got":   processed,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestFolderCategoriesAssigned(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Recursive = true
+	configuration.FolderCategories = "assign"
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	db.UpsertCategory(model.Category{Name: "vacation"})
+
+	writeImageFiles(t, map[string]string{"unprocessed/Vacation/2019/b.jpg": "b"})
+
+	image, _, err := ctrl.IndexFile("unprocessed/Vacation/2019/b.jpg")
+	if err != nil || !reflect.DeepEqual(image.AssignedCategories, []string{"vacation", "2019"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the folders to be assigned as categories, with the names of existing categories.",
+			map[string]interface{}{
+				"error": err,
+				"got":   image,
+			})
+		t.Errorf(format, args...)
+	}
+	if categories, _ := db.QueryCategories(); len(categories) != 2 {
+		t.Errorf("Expected only the missing categories to be created, got %v.", categories)
+	}
+}
+
+func TestFolderCategoriesCaseVariant(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Recursive = true
+	configuration.FolderCategories = "assign"
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	db.UpsertCategory(model.Category{Name: "Vacation"})
+
+	writeImageFiles(t, map[string]string{"processed/vacation/b.jpg": "b"})
+	if _, _, err := ctrl.IndexFile("processed/vacation/b.jpg"); err != nil {
+		t.Fatal("Unable to index the image.", err)
+	}
+
+	images, err := db.GetImages(model.ImageOptions{}, &model.CategoryMap{Assigned: []string{"Vacation"}})
+	if err != nil || len(images) != 1 || !reflect.DeepEqual(images[0].AssignedCategories, []string{"Vacation"}) {
+		format, args := testutil.FormatTestError(
+			"Expected a folder that differs from a category in case only to be assigned with the name of the category.",
+			map[string]interface{}{
+				"error": err,
+				"got":   images,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+// racingCategoryStore creates a category right before another one is created, like a concurrent request.
+type racingCategoryStore struct {
+	*memory.Store
+	concurrent string
+}
+
+func (s racingCategoryStore) UpsertCategory(category model.Category) (*model.Category, error) {
+	s.Store.UpsertCategory(model.Category{Name: s.concurrent})
+	return s.Store.UpsertCategory(category)
+}
+
+func TestFolderCategoriesConcurrentCaseVariant(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Recursive = true
+	configuration.FolderCategories = "assign"
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: racingCategoryStore{db, "Vacation"}})
+
+	writeImageFiles(t, map[string]string{"processed/vacation/b.jpg": "b"})
+	image, _, err := ctrl.IndexFile("processed/vacation/b.jpg")
+	if err != nil || !reflect.DeepEqual(image.AssignedCategories, []string{"Vacation"}) {
+		format, args := testutil.FormatTestError(
+			"Expected a folder whose category was created concurrently in another case to get its name.",
+			map[string]interface{}{
+				"error": err,
+				"got":   image,
+			})
+		t.Errorf(format, args...)
+	}
+}
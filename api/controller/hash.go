@@ -0,0 +1,280 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/model"
+	"tagallery.com/api/phash"
+	"tagallery.com/api/store"
+)
+
+// HashFile computes the hex encoded SHA-256 hash of the content of a file.
+// The hash identifies an image independent of its name and location.
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// perceptualHash computes the perceptual hash of an image file.
+// Files that can't be decoded have no perceptual hash, thus an empty string is returned.
+func perceptualHash(path string) string {
+	hash, err := phash.File(path, config.Get().MaxImagePixels)
+	if err != nil {
+		logger.Logger().Debugw("Unable to compute the perceptual hash.", "path", path, "error", err)
+		return ""
+	}
+
+	return hash
+}
+
+// imageMetadata reads the metadata of an image file.
+// Files without (readable) metadata have none, thus nil is returned.
+func imageMetadata(path string) *model.Metadata {
+	m, err := metadata.File(path)
+	if err != nil && !errors.Is(err, metadata.ErrUnsupported) {
+		logger.Logger().Debugw("Unable to read the metadata.", "path", path, "error", err)
+	}
+
+	return m
+}
+
+// sameMetadata reports whether two metadata are equal. The times are compared in milliseconds, the precision
+// of MongoDB, so that metadata that went through a store equals the one read from the file again.
+func sameMetadata(a *model.Metadata, b *model.Metadata) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	takenA, takenB := a.TakenAt, b.TakenAt
+	if (takenA == nil) != (takenB == nil) ||
+		(takenA != nil && !takenA.Truncate(time.Millisecond).Equal(takenB.Truncate(time.Millisecond))) {
+		return false
+	}
+
+	x, y := *a, *b
+	x.TakenAt, y.TakenAt = nil, nil
+	return reflect.DeepEqual(x, y)
+}
+
+// Rescan walks the unprocessed and processed folder, and their subfolders in recursive mode,
+// and updates the images of all files. Files without an image are added, unless they were moved
+// or renamed, in which case their previous image is kept. The changed images are returned.
+// Files that can't be indexed are logged and skipped, files deleted during the scan are ignored.
+// Only a folder that can't be listed fails the scan.
+func (c *Controller) Rescan() ([]model.Image, error) {
+	changed := []model.Image{}
+
+	for _, folder := range []string{config.Get().UnprocessedImagesFolder, config.Get().ProcessedImagesFolder} {
+		files, err := listFiles(folder)
+		if err != nil {
+			return changed, err
+		}
+
+		for _, file := range files {
+			image, updated, err := c.IndexFile(file)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				logger.Logger().Warnw("Unable to index a file, skipping it.", "file", file, "error", err)
+				continue
+			}
+			if updated {
+				changed = append(changed, *image)
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+// IndexFile computes the hashes of a file and updates or creates its image.
+// New images get the categories of their folders, see applyFolderCategories(),
+// and new unprocessed images the ones of their keywords, see importKeywords().
+// The returned flag reports if the image was changed.
+func (c *Controller) IndexFile(file string) (*model.Image, bool, error) {
+	path := filepath.Join(config.Get().Images, file)
+
+	hash, err := HashFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	image, err := c.store.Images.GetImage(file)
+	if errors.Is(err, store.ErrNotFound) {
+		image = &model.Image{File: file, AssignedCategories: []string{}, ProposedCategories: []model.Proposal{}}
+		if err := c.applyFolderCategories(image); err != nil {
+			return nil, false, err
+		}
+		if err := c.importKeywords(image); err != nil {
+			return nil, false, err
+		}
+	} else if err != nil {
+		return nil, false, err
+	} else if image.Hash == hash && (image.ExtractionFailed || (image.PerceptualHash != "" && image.Metadata != nil)) {
+		return image, false, nil
+	}
+
+	// Images indexed before their metadata was read get it on the next scan.
+	perceptual := perceptualHash(path)
+	meta := imageMetadata(path)
+	failed := perceptual == "" || meta == nil
+	if image.Hash == hash && image.PerceptualHash == perceptual && sameMetadata(image.Metadata, meta) {
+		if failed {
+			if image, err = c.store.Images.UpdateImage(file, func(image *model.Image) error {
+				image.ExtractionFailed = true
+				return nil
+			}); err != nil {
+				return nil, false, err
+			}
+		}
+		return image, false, nil
+	}
+
+	image.Hash = hash
+	image.PerceptualHash = perceptual
+	image.Metadata = meta
+	image.ExtractionFailed = failed
+	image.Unprocessed = isUnprocessed(file)
+
+	if err := c.adoptPreviousImages(image); err != nil {
+		return nil, false, err
+	}
+	if err := c.store.Images.UpsertImage(*image); err != nil {
+		return nil, false, err
+	}
+
+	return image, true, nil
+}
+
+// Reindex reconciles the images with the files of the image folders. It rescans the folders
+// (see Rescan()) and removes the unprocessed images whose files don't exist anymore.
+// Processed images of missing files are kept, they hold the categories of the user.
+// It returns the changed images and the files of the removed ones.
+func (c *Controller) Reindex() ([]model.Image, []string, error) {
+	removed := []string{}
+
+	changed, err := c.Rescan()
+	if err != nil {
+		return changed, removed, err
+	}
+
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return changed, removed, err
+	}
+
+	for _, image := range images {
+		if !image.Unprocessed {
+			continue
+		}
+		if ok, err := c.RemoveFile(image.File); err != nil {
+			return changed, removed, err
+		} else if ok {
+			removed = append(removed, image.File)
+		}
+	}
+
+	return changed, removed, nil
+}
+
+// indexUnprocessed indexes the files of the unprocessed folder that have no image yet and removes the
+// unprocessed images whose files don't exist anymore. Files that can't be indexed are logged and skipped.
+func (c *Controller) indexUnprocessed() error {
+	files, err := listFiles(config.Get().UnprocessedImagesFolder)
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, file := range files {
+		listed[file] = true
+		if _, err := c.store.Images.GetImage(file); !errors.Is(err, store.ErrNotFound) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, _, err := c.IndexFile(file); err != nil && !os.IsNotExist(err) {
+			logger.Logger().Warnw("Unable to index a file, skipping it.", "file", file, "error", err)
+		}
+	}
+
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if image.Unprocessed && !listed[image.File] {
+			if _, err := c.RemoveFile(image.File); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RemoveFile deletes the image of a file that doesn't exist anymore.
+// Images of existing files are kept. It reports whether an image was deleted.
+func (c *Controller) RemoveFile(file string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(config.Get().Images, file)); !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if _, err := c.store.Images.GetImage(file); errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, c.store.Images.DeleteImage(file)
+}
+
+// GetDuplicates returns groups of images with byte-identical files.
+func (c *Controller) GetDuplicates() ([][]model.Image, error) {
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string][]model.Image{}
+	hashes := []string{}
+	for _, image := range images {
+		if image.Hash == "" {
+			continue
+		}
+		if _, ok := groups[image.Hash]; !ok {
+			hashes = append(hashes, image.Hash)
+		}
+		groups[image.Hash] = append(groups[image.Hash], image)
+	}
+
+	duplicates := [][]model.Image{}
+	for _, hash := range hashes {
+		if len(groups[hash]) > 1 {
+			duplicates = append(duplicates, groups[hash])
+		}
+	}
+
+	return duplicates, nil
+}
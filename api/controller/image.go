@@ -1,8 +1,9 @@
 package controller
 
 import (
+	"encoding/base64"
 	"errors"
-	"io"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,114 +11,378 @@ import (
 	"tagallery.com/api/config"
 	"tagallery.com/api/logger"
 	"tagallery.com/api/model"
-	"tagallery.com/api/mongodb"
+	"tagallery.com/api/store"
 	"tagallery.com/api/util"
 )
 
-// GetUnprocessedImages returns unprocessed images from a file directory.
-// Subdirectories are ignored.
-// Options can be passed to limit the number of images returned and
-// to start from a specific image, useful for pagination.
-func GetUnprocessedImages(opts model.ImageOptions) ([]model.Image, error) {
-	imageDir := filepath.Join(config.Get().Images, config.Get().UnprocessedImagesFolder)
-	images := []model.Image{}
-	selectImages := true
-	counter := 0
+// Controller implements the business logic of the API on top of a storage backend.
+type Controller struct {
+	store store.Store
+}
 
-	if opts.LastImage != nil {
-		selectImages = false
-	}
+// New creates a Controller that persists its data in the given store.
+func New(s store.Store) *Controller {
+	return &Controller{store: s}
+}
 
-	// Create the unprocessed image directory if it does not exist
-	_ = os.MkdirAll(imageDir, 0755)
+// GetUnprocessedImages returns the unprocessed images of the store, see ImageStore.GetUnprocessedImages().
+// The cursors are opaque to clients. A page after the last one is empty and has no cursor.
+// For compatibility, a lastImage without folder is taken as a file of the unprocessed folder.
+// Requests without cursor reconcile the index with the unprocessed folder first, see indexUnprocessed(),
+// so that files copied into it are listed without watcher.
+func (c *Controller) GetUnprocessedImages(opts model.ImageOptions) ([]model.Image, string, error) {
+	if opts.Cursor == nil {
+		if err := c.indexUnprocessed(); err != nil {
+			return nil, "", err
+		}
+	}
 
-	err := filepath.Walk(imageDir, func(path string, info os.FileInfo, err error) error {
+	if opts.Cursor != nil {
+		cursor, err := base64.RawURLEncoding.DecodeString(*opts.Cursor)
 		if err != nil {
-			logger.Logger().Warnw("A file processed during the dir walk of unprocessed images caused an error.", "error", err)
-			return nil
-		}
-
-		// Skip root dir and nested dirs
-		if path == imageDir {
-			return nil
-		} else if info.IsDir() {
-			return filepath.SkipDir
-		}
-
-		// If lastImage is specified wait till we find it and then get {count} files
-		if !selectImages {
-			if filepath.Base(path) == *opts.LastImage {
-				selectImages = true
-			}
-		} else if opts.Count == nil || counter < *opts.Count {
-			counter++
-			images = append(images, model.Image{
-				File:               filepath.Join(config.Get().UnprocessedImagesFolder, filepath.Base(path)),
-				AssignedCategories: []string{},
-				ProposedCategories: []string{},
-			})
-		} else {
-			return io.EOF
+			return nil, "", store.ErrInvalidCursor
 		}
-		return nil
-	})
+		opts.Cursor = util.StringPtr(string(cursor))
+	} else if opts.LastImage != nil && filepath.Dir(*opts.LastImage) == "." {
+		opts.LastImage = util.StringPtr(filepath.Join(config.Get().UnprocessedImagesFolder, *opts.LastImage))
+	}
 
-	if err != nil && err != io.EOF {
-		return nil, err
+	images, next, err := c.store.Images.GetUnprocessedImages(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if next != "" {
+		next = base64.RawURLEncoding.EncodeToString([]byte(next))
 	}
 
-	return images, nil
+	return images, next, nil
 }
 
 // GetImages returns a list of images filtered by
 // count, categories, status and lastImage for pagination.
-func GetImages(
+// Autocategorized images are sorted by the confidence of their proposals.
+// Unprocessed images are paginated with cursors, the cursor of the next page is returned.
+// Aliases of categories are resolved to their category. With opts.Descendants the categories also match
+// the images of their descendant categories.
+func (c *Controller) GetImages(
 	status string, opts model.ImageOptions, categories []string,
-) ([]model.Image, error) {
+) ([]model.Image, string, error) {
+	var images []model.Image
+	var descendants map[string][]string
+	var err error
+
+	if len(categories) > 0 {
+		if categories, err = c.resolveCategories(categories); err != nil {
+			return nil, "", err
+		}
+	}
+	if opts.Descendants && len(categories) > 0 {
+		if descendants, err = c.categoryDescendants(categories); err != nil {
+			return nil, "", err
+		}
+	}
 
 	switch status {
 	case "unprocessed":
-		return GetUnprocessedImages(opts)
+		return c.GetUnprocessedImages(opts)
 	case "uncategorized":
-		return mongodb.GetImages(opts, nil)
+		images, err = c.store.Images.GetImages(opts, nil)
 	case "autocategorized":
-		return mongodb.GetImages(opts, &model.CategoryMap{
-			Proposed: categories,
+		opts.SortByConfidence = true
+		images, err = c.store.Images.GetImages(opts, &model.CategoryMap{
+			Proposed:    categories,
+			Descendants: descendants,
 		})
 	case "categorized":
-		return mongodb.GetImages(opts, &model.CategoryMap{
-			Assigned: categories,
+		images, err = c.store.Images.GetImages(opts, &model.CategoryMap{
+			Assigned:    categories,
+			Descendants: descendants,
 		})
 	default:
-		return mongodb.GetImages(opts, &model.CategoryMap{})
+		images, err = c.store.Images.GetImages(opts, &model.CategoryMap{})
 	}
+
+	return images, "", err
 }
 
 // UpsertImage inserts or updates an existing image.
-func UpsertImage(image model.Image) (*model.Image, error) {
-	if strings.HasSuffix(filepath.Dir(image.File), config.Get().UnprocessedImagesFolder) {
-		imageDir := filepath.Join(config.Get().Images, config.Get().ProcessedImagesFolder)
+// Unprocessed images are moved into the processed folder, see processImage(). If a file with the same name
+// is already processed, then an identical file is treated as a duplicate and dropped,
+// while a different file is moved under a new name.
+// Images of files that were moved or renamed on disk keep the categories of their previous record.
+// The assigned categories are exported as keywords, if configured, see ExportKeywords().
+func (c *Controller) UpsertImage(image model.Image) (*model.Image, error) {
+	var result *model.Image
+	var err error
+
+	if isUnprocessed(image.File) {
+		result, err = c.processImage(image)
+	} else {
+		result, err = c.saveImage(image)
+	}
+
+	if err == nil {
+		c.autoExportKeywords(result)
+	}
+	return result, err
+}
 
-		// Create the processed image directory if it does not exist
-		_ = os.MkdirAll(imageDir, 0755)
+// saveImage computes the hashes of the file of an image and stores the image.
+// The rejected categories of the stored image are kept, see keepStoredRejections().
+func (c *Controller) saveImage(image model.Image) (*model.Image, error) {
+	if err := c.keepStoredRejections(&image, image.File); err != nil {
+		return nil, err
+	}
 
-		fileName := filepath.Base(image.File)
+	path := filepath.Join(config.Get().Images, image.File)
+	if hash, err := HashFile(path); err == nil {
+		image.Hash = hash
+		image.PerceptualHash = perceptualHash(path)
+		image.Metadata = imageMetadata(path)
+		image.ExtractionFailed = image.PerceptualHash == "" || image.Metadata == nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	image.Unprocessed = false
+
+	if err := c.adoptPreviousImages(&image); err != nil {
+		return nil, err
+	}
+
+	// Automatically add the starred category to the assigned categories.
+	if image.StarredCategory != nil && *image.StarredCategory != "" {
+		if exists := util.ContainsString(image.AssignedCategories, *image.StarredCategory, false); !exists {
+			image.AssignedCategories = append(image.AssignedCategories, *image.StarredCategory)
+		}
+	}
+
+	return &image, c.store.Images.UpsertImage(image)
+}
+
+// processImage moves the file of an unprocessed image into the processed folder and stores the image.
+// The processing is journaled: the intent is written before the file is moved and removed once the image
+// is stored. If the image can't be stored, then the file is moved back. Processing that was interrupted
+// is completed or rolled back by Recover(). The XMP sidecar of the file is moved along, see moveSidecar().
+// If the target name is claimed concurrently, then the next free name is taken, see claimFile().
+func (c *Controller) processImage(image model.Image) (*model.Image, error) {
+	if err := c.keepStoredRejections(&image, image.File); err != nil {
+		return nil, err
+	}
+
+	var entry journalEntry
+	var journal string
+	for {
+		target, duplicate, err := processedFile(image.File)
+		if err != nil {
+			return nil, err
+		}
+
+		entry = journalEntry{Source: image.File, Target: target, Duplicate: duplicate}
+		entry.Image = image
+		entry.Image.File = target
+
+		if journal, err = writeJournal(&entry); err != nil {
+			return nil, err
+		}
+
+		err = claimFile(entry.Source, entry.Moved)
+		if err == nil {
+			break
+		}
+		removeJournal(journal)
+		if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+
+	result, err := c.completeProcessing(entry)
+	if err != nil {
+		if rollbackErr := rollbackProcessing(entry); rollbackErr != nil {
+			logger.Logger().Errorw("Unable to roll back the processing of an image, it is recovered at the next start.",
+				"file", entry.Source, "journal", journal, "error", rollbackErr)
+			return nil, err
+		}
+		removeJournal(journal)
+		return nil, err
+	}
+
+	// The sidecar is moved before the journal is removed, so that Recover() moves it after a crash.
+	moveSidecar(entry.Source, entry.Target)
+	removeJournal(journal)
+	return result, nil
+}
+
+// claimFile moves a file to a target without replacing an existing one, the error satisfies os.IsExist() then.
+// Linking fails if the target exists, which makes claiming the name atomic. The source is removed afterwards,
+// Recover() removes the target again if that was interrupted.
+func claimFile(source string, target string) error {
+	from := filepath.Join(config.Get().Images, source)
+	to := filepath.Join(config.Get().Images, target)
+
+	if err := os.Link(from, to); err != nil {
+		return err
+	}
+	if err := os.Remove(from); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return nil
+}
+
+// completeProcessing stores the image of a journaled processing whose file was already moved.
+// The moved file of a duplicate is removed, the categories of the already processed duplicate are kept.
+func (c *Controller) completeProcessing(entry journalEntry) (*model.Image, error) {
+	image := entry.Image
+
+	if entry.Duplicate {
+		if existing, err := c.store.Images.GetImage(entry.Target); err == nil {
+			mergeCategories(&image, *existing)
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	result, err := c.saveImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Duplicate {
+		logger.Logger().Infow("Dropping a duplicate of an already processed image.", "file", entry.Source, "duplicate", entry.Target)
+		if err := os.Remove(filepath.Join(config.Get().Images, entry.Moved)); err != nil && !os.IsNotExist(err) {
+			logger.Logger().Warnw("Unable to remove a duplicate.", "file", entry.Moved, "error", err)
+		}
+	}
+
+	return result, nil
+}
+
+// rollbackProcessing moves the file of a journaled processing back into the unprocessed folder.
+func rollbackProcessing(entry journalEntry) error {
+	logger.Logger().Warnw("Rolling back the processing of an image.", "file", entry.Source)
+	return os.Rename(filepath.Join(config.Get().Images, entry.Moved), filepath.Join(config.Get().Images, entry.Source))
+}
+
+// processedFile returns the relative path of an unprocessed file in the processed folder,
+// files of subfolders keep their subfolders. If the file is a duplicate of an already processed file,
+// then the path of that file is returned and the flag is set.
+func processedFile(file string) (string, bool, error) {
+	folder := filepath.Join(config.Get().ProcessedImagesFolder, filepath.Dir(subPath(file)))
+	imageDir := filepath.Join(config.Get().Images, folder)
+	oldPath := filepath.Join(config.Get().Images, file)
+
+	// Create the processed image directory if it does not exist
+	_ = os.MkdirAll(imageDir, 0755)
+
+	ext := filepath.Ext(file)
+	base := strings.TrimSuffix(filepath.Base(file), ext)
+
+	for i := 0; ; i++ {
+		fileName := base + ext
+		if i > 0 {
+			fileName = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
 		newPath := filepath.Join(imageDir, fileName)
+		newFile := filepath.Join(folder, fileName)
+
+		// Lstat like the link of claimFile(), which fails for dangling symlinks as well.
+		if _, err := os.Lstat(newPath); os.IsNotExist(err) {
+			return newFile, false, nil
+		} else if err != nil {
+			return "", false, err
+		}
 
-		if _, err := os.Stat(newPath); err == nil {
-			return nil, errors.New("file already exists")
-		} else if os.IsNotExist(err) {
-			if err := os.Rename(filepath.Join(config.Get().Images, image.File), newPath); err != nil {
-				return nil, err
-			}
-			image.File = filepath.Join(config.Get().ProcessedImagesFolder, fileName)
+		if identical, err := sameContent(oldPath, newPath); err != nil {
+			return "", false, err
+		} else if identical {
+			return newFile, true, nil
 		}
 	}
+}
 
-	// Automatically add the starred category to the assigned categories.
-	if exists := util.ContainsString(image.AssignedCategories, *image.StarredCategory, false); !exists {
-		image.AssignedCategories = append(image.AssignedCategories, *image.StarredCategory)
+// sameContent checks if two files have the same content by comparing their hashes.
+func sameContent(a string, b string) (bool, error) {
+	hashA, err := HashFile(a)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := HashFile(b)
+	if err != nil {
+		return false, err
+	}
+
+	return hashA == hashB, nil
+}
+
+// adoptPreviousImages merges the categories of the images with the same hash whose files don't
+// exist anymore into the given image and deletes them, so that moved or renamed files keep their categories.
+func (c *Controller) adoptPreviousImages(image *model.Image) error {
+	if image.Hash == "" {
+		return nil
+	}
+
+	previous, err := c.store.Images.GetImagesByHash(image.Hash)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range previous {
+		if p.File == image.File {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(config.Get().Images, p.File)); !os.IsNotExist(err) {
+			continue
+		}
+
+		logger.Logger().Infow("Image was moved.", "from", p.File, "to", image.File)
+		mergeCategories(image, p)
+		if err := c.store.Images.DeleteImage(p.File); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepStoredRejections adds the rejected categories of the stored image of a file to an image that replaces it,
+// so that they aren't proposed again.
+func (c *Controller) keepStoredRejections(image *model.Image, file string) error {
+	stored, err := c.store.Images.GetImage(file)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	keepRejectedCategories(image, *stored)
+	return nil
+}
+
+// keepRejectedCategories adds the rejected categories of a previous version of an image to the image.
+func keepRejectedCategories(image *model.Image, previous model.Image) {
+	for _, category := range previous.RejectedCategories {
+		if !util.ContainsString(image.RejectedCategories, category, false) {
+			image.RejectedCategories = append(image.RejectedCategories, category)
+		}
 	}
+}
 
-	return &image, mongodb.UpsertImage(image)
+// mergeCategories adds the categories of a previous version of an image to the image.
+// The starred category of the image takes precedence over the previous one.
+func mergeCategories(image *model.Image, previous model.Image) {
+	for _, category := range previous.AssignedCategories {
+		if !util.ContainsString(image.AssignedCategories, category, false) {
+			image.AssignedCategories = append(image.AssignedCategories, category)
+		}
+	}
+	for _, proposal := range previous.ProposedCategories {
+		if !util.ContainsString(model.ProposalNames(image.ProposedCategories), proposal.Category, false) {
+			image.ProposedCategories = append(image.ProposedCategories, proposal)
+		}
+	}
+	keepRejectedCategories(image, previous)
+	if image.StarredCategory == nil || *image.StarredCategory == "" {
+		image.StarredCategory = previous.StarredCategory
+	}
 }
@@ -1,18 +1,20 @@
 package controller_test
 
 import (
-	"context"
-	"fmt"
+	"errors"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"tagallery.com/api/config"
 	"tagallery.com/api/controller"
 	"tagallery.com/api/logger"
+	"tagallery.com/api/memory"
 	"tagallery.com/api/model"
-	"tagallery.com/api/mongodb"
+	"tagallery.com/api/store"
 	"tagallery.com/api/testutil"
 	"tagallery.com/api/util"
 )
@@ -49,9 +51,6 @@ func createTestDirectory(directory string) error {
 
 func TestGetUnprocessedImages(t *testing.T) {
 	var dir = "testdata"
-	var images []model.Image
-	var err error
-	var expected []model.Image
 	var imageFixtures []model.Image
 
 	configuration := config.Load()
@@ -61,9 +60,12 @@ func TestGetUnprocessedImages(t *testing.T) {
 	for _, v := range fileFixtures {
 		imageFixtures = append(imageFixtures, model.Image{
 			File:               filepath.Join(configuration.UnprocessedImagesFolder, v),
-			ProposedCategories: []string{},
+			ProposedCategories: []model.Proposal{},
 			AssignedCategories: []string{},
 			StarredCategory:    nil,
+			Hash:               testutil.EmptyFileHash,
+			Unprocessed:        true,
+			ExtractionFailed:   true,
 		})
 	}
 
@@ -77,51 +79,112 @@ func TestGetUnprocessedImages(t *testing.T) {
 		t.Fatalf(format, args...)
 	}
 
-	expected = imageFixtures
-	images, err = controller.GetUnprocessedImages(model.ImageOptions{})
-	if !reflect.DeepEqual(images, expected) {
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	if _, _, err := ctrl.Reindex(); err != nil {
+		t.Fatal("Unable to index the file fixtures.", err)
+	}
+
+	tests := []struct {
+		desc     string
+		opts     model.ImageOptions
+		expected []model.Image
+		next     bool
+	}{
+		{
+			desc:     "all images",
+			opts:     model.ImageOptions{},
+			expected: imageFixtures,
+		},
+		{
+			desc:     "limited by count",
+			opts:     model.ImageOptions{Count: util.IntPtr(3)},
+			expected: imageFixtures[0:3],
+			next:     true,
+		},
+		{
+			desc:     "after lastImage",
+			opts:     model.ImageOptions{Count: util.IntPtr(5), LastImage: &fileFixtures[2]},
+			expected: imageFixtures[3:5],
+		},
+	}
+
+	for _, test := range tests {
+		images, next, err := ctrl.GetUnprocessedImages(test.opts)
+		if err != nil || !reflect.DeepEqual(images, test.expected) || (next != "") != test.next {
+			format, args := testutil.FormatTestError(
+				"Returned images do not match expectations.",
+				map[string]interface{}{
+					"filter":   test.desc,
+					"expected": test.expected,
+					"got":      images,
+					"next":     next,
+					"error":    err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	_, cursor, _ := ctrl.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(3)})
+
+	// The cursor stays valid when images are removed.
+	if err := os.Remove(filepath.Join(unprocessedImages, fileFixtures[3])); err != nil {
+		t.Fatal("Unable to delete a file fixture.", err)
+	}
+	if _, removed, err := ctrl.Reindex(); err != nil || !reflect.DeepEqual(removed, []string{imageFixtures[3].File}) {
 		format, args := testutil.FormatTestError(
-			"Returned images do not match expectations.",
+			"Expected the image of the deleted file to be removed.",
 			map[string]interface{}{
-				"expected": expected,
-				"got":      images,
-				"error":    err,
+				"removed": removed,
+				"error":   err,
 			})
 		t.Errorf(format, args...)
 	}
 
-	expected = []model.Image{
-		imageFixtures[0],
-		imageFixtures[1],
-		imageFixtures[2],
-	}
-	images, err = controller.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(3)})
-	if !reflect.DeepEqual(images, expected) {
+	images, next, err := ctrl.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(3), Cursor: &cursor})
+	if err != nil || next != "" || !reflect.DeepEqual(images, imageFixtures[4:5]) {
 		format, args := testutil.FormatTestError(
-			"Returned images do not match expectations.",
+			"Expected the page after the cursor.",
 			map[string]interface{}{
-				"expected": expected,
+				"expected": imageFixtures[4:5],
 				"got":      images,
+				"next":     next,
 				"error":    err,
 			})
 		t.Errorf(format, args...)
 	}
 
-	expected = []model.Image{
-		imageFixtures[3],
-		imageFixtures[4],
+	if _, _, err := ctrl.GetUnprocessedImages(model.ImageOptions{Cursor: util.StringPtr("!")}); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Error("Expected an invalid cursor to be refused.", err)
 	}
-	images, err = controller.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(5), LastImage: &fileFixtures[2]})
-	if !reflect.DeepEqual(images, expected) {
+}
+
+func TestGetUnprocessedImagesIndexesFolder(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Watch = false
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{"unprocessed/a.jpg": "a", "unprocessed/b.jpg": "b"})
+	images, _, err := ctrl.GetUnprocessedImages(model.ImageOptions{})
+	if err != nil || len(images) != 2 || images[0].File != "unprocessed/a.jpg" || images[0].Hash == "" {
 		format, args := testutil.FormatTestError(
-			"Returned images do not match expectations.",
+			"Expected the files of the unprocessed folder to be listed without reindex.",
 			map[string]interface{}{
-				"expected": expected,
-				"got":      images,
-				"error":    err,
+				"got":   images,
+				"error": err,
 			})
 		t.Errorf(format, args...)
 	}
+
+	if err := os.Remove(filepath.Join(configuration.Images, "unprocessed/a.jpg")); err != nil {
+		t.Fatal("Unable to delete the file.", err)
+	}
+	if images, _, err := ctrl.GetUnprocessedImages(model.ImageOptions{}); err != nil || len(images) != 1 || images[0].File != "unprocessed/b.jpg" {
+		t.Errorf("Expected the image of a deleted file not to be listed, got %v (%v).", images, err)
+	}
 }
 
 func TestUpsertImage(t *testing.T) {
@@ -132,8 +195,8 @@ func TestUpsertImage(t *testing.T) {
 	unprocessedImages := filepath.Join(dir, configuration.UnprocessedImagesFolder)
 
 	defer os.RemoveAll(dir)
-	mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, configuration.DatabaseHost))
-	defer testutil.CleanCollection(t, configuration.Database, "image")
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
 
 	if err := os.MkdirAll(unprocessedImages, 0755); err != nil {
 		t.Fatal("Unable to create the unprocessed image folder.", err)
@@ -147,14 +210,16 @@ func TestUpsertImage(t *testing.T) {
 
 	expected := model.Image{
 		File:               filepath.Join(configuration.ProcessedImagesFolder, "test.jpg"),
-		ProposedCategories: []string{},
+		ProposedCategories: []model.Proposal{},
 		AssignedCategories: []string{"Category 1"},
 		StarredCategory:    util.StringPtr("Category 1"),
+		Hash:               testutil.EmptyFileHash,
+		ExtractionFailed:   true,
 	}
-	image, err := controller.UpsertImage(model.Image{
+	image, err := ctrl.UpsertImage(model.Image{
 		File:               filepath.Join(configuration.UnprocessedImagesFolder, "test.jpg"),
 		AssignedCategories: []string{},
-		ProposedCategories: []string{},
+		ProposedCategories: []model.Proposal{},
 		StarredCategory:    util.StringPtr("Category 1"),
 	})
 
@@ -169,3 +234,267 @@ func TestUpsertImage(t *testing.T) {
 		t.Errorf(format, args...)
 	}
 }
+
+// writeImageFiles creates files with the given content in the images directory.
+func writeImageFiles(t *testing.T, files map[string]string) {
+	for file, content := range files {
+		path := filepath.Join(config.Get().Images, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal("Unable to create the image folder.", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal("Unable to create the test image.", err)
+		}
+	}
+}
+
+func TestUpsertDuplicateImage(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"processed/a.jpg":   "a",
+		"processed/b.jpg":   "b",
+		"unprocessed/a.jpg": "a",
+		"unprocessed/b.jpg": "other b",
+	})
+	db.UpsertImage(model.Image{File: "processed/a.jpg", AssignedCategories: []string{"Category 1"}})
+
+	image, err := ctrl.UpsertImage(model.Image{
+		File:               "unprocessed/a.jpg",
+		AssignedCategories: []string{"Category 2"},
+	})
+	if err != nil || image.File != "processed/a.jpg" ||
+		!reflect.DeepEqual(image.AssignedCategories, []string{"Category 2", "Category 1"}) {
+		format, args := testutil.FormatTestError(
+			"Expected an identical file to be merged with the processed one.",
+			map[string]interface{}{
+				"error": err,
+				"got":   image,
+			})
+		t.Errorf(format, args...)
+	}
+	if _, err := os.Stat(filepath.Join(configuration.Images, "unprocessed/a.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected the duplicate file to be removed.", err)
+	}
+
+	image, err = ctrl.UpsertImage(model.Image{File: "unprocessed/b.jpg"})
+	if err != nil || image.File != "processed/b-1.jpg" {
+		format, args := testutil.FormatTestError(
+			"Expected a different file with the same name to be renamed.",
+			map[string]interface{}{
+				"error": err,
+				"got":   image,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestUpsertImageKeepsRejections(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"processed/a.jpg":   "a",
+		"unprocessed/b.jpg": "b",
+	})
+	db.UpsertImage(model.Image{File: "processed/a.jpg", RejectedCategories: []string{"Category 1"}})
+	db.UpsertImage(model.Image{File: "unprocessed/b.jpg", RejectedCategories: []string{"Category 2"}, Unprocessed: true})
+
+	tests := []struct {
+		file     string
+		expected string
+		rejected []string
+	}{
+		{"processed/a.jpg", "processed/a.jpg", []string{"Category 3", "Category 1"}},
+		{"unprocessed/b.jpg", "processed/b.jpg", []string{"Category 3", "Category 2"}},
+	}
+
+	for _, test := range tests {
+		if _, err := ctrl.UpsertImage(model.Image{
+			File:               test.file,
+			AssignedCategories: []string{"Category 4"},
+			RejectedCategories: []string{"Category 3"},
+		}); err != nil {
+			t.Fatal("Unable to save the image.", err)
+		}
+
+		image, err := db.GetImage(test.expected)
+		if err != nil || !reflect.DeepEqual(image.RejectedCategories, test.rejected) {
+			format, args := testutil.FormatTestError(
+				"Expected a saved image to keep the rejected categories of the stored one.",
+				map[string]interface{}{
+					"file":     test.file,
+					"expected": test.rejected,
+					"got":      image,
+					"error":    err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestRescan(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"processed/a.jpg": "a",
+		"processed/b.jpg": "b",
+	})
+	if _, err := ctrl.Rescan(); err != nil {
+		t.Fatal("Unable to scan the processed images.", err)
+	}
+
+	image, _ := db.GetImage("processed/a.jpg")
+	image.AssignedCategories = []string{"Category 1"}
+	db.UpsertImage(*image)
+
+	if err := os.Rename(
+		filepath.Join(configuration.Images, "processed/a.jpg"),
+		filepath.Join(configuration.Images, "processed/renamed.jpg"),
+	); err != nil {
+		t.Fatal("Unable to rename the test image.", err)
+	}
+
+	changed, err := ctrl.Rescan()
+	if err != nil || len(changed) != 1 || changed[0].File != "processed/renamed.jpg" ||
+		!reflect.DeepEqual(changed[0].AssignedCategories, []string{"Category 1"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the renamed file to keep its categories.",
+			map[string]interface{}{
+				"error":   err,
+				"changed": changed,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, err := db.GetImage("processed/a.jpg"); !errors.Is(err, store.ErrNotFound) {
+		t.Error("Expected the image of the old file to be removed.", err)
+	}
+}
+
+// brokenReadStore fails to read the image of a single file.
+type brokenReadStore struct {
+	*memory.Store
+	file string
+}
+
+func (s brokenReadStore) GetImage(file string) (*model.Image, error) {
+	if file == s.file {
+		return nil, errStoreFailed
+	}
+	return s.Store.GetImage(file)
+}
+
+func TestRescanSkipsFailures(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: brokenReadStore{db, "processed/a.jpg"}, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"processed/a.jpg":   "a",
+		"processed/b.jpg":   "b",
+		"unprocessed/c.jpg": "c",
+	})
+
+	changed, err := ctrl.Rescan()
+	if err != nil || len(changed) != 2 {
+		format, args := testutil.FormatTestError(
+			"Expected a file that can't be indexed to be skipped.",
+			map[string]interface{}{
+				"error":   err,
+				"changed": changed,
+			})
+		t.Errorf(format, args...)
+	}
+	if _, err := db.GetImage("processed/b.jpg"); err != nil {
+		t.Error("Expected the files after the failed one to be indexed.", err)
+	}
+}
+
+func TestIndexFileUnchanged(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	// A PNG file without image data, whose XMP packet has a time in microseconds.
+	xmp := `XML:com.adobe.xmp` + "\x00\x00\x00\x00\x00" + `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description rdf:about="" xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:DateTimeOriginal="2020-01-02T03:04:05.123456+01:00"/></rdf:RDF></x:xmpmeta>`
+	chunk := string([]byte{0, 0, byte(len(xmp) >> 8), byte(len(xmp))}) + "iTXt" + xmp + "\x00\x00\x00\x00"
+	writeImageFiles(t, map[string]string{
+		"processed/a.png": "\x89PNG\r\n\x1a\n" + chunk + "\x00\x00\x00\x00IEND\x00\x00\x00\x00",
+	})
+	hash, err := controller.HashFile(filepath.Join(configuration.Images, "processed/a.png"))
+	if err != nil {
+		t.Fatal("Unable to hash the image.", err)
+	}
+
+	// The image was indexed before failed extractions were recorded and its time lost precision in the store.
+	taken := time.Date(2020, 1, 2, 2, 4, 5, 123000000, time.UTC)
+	db.UpsertImage(model.Image{
+		File:               "processed/a.png",
+		AssignedCategories: []string{},
+		ProposedCategories: []model.Proposal{},
+		Hash:               hash,
+		Metadata:           &model.Metadata{TakenAt: &taken},
+	})
+
+	for k := 0; k < 2; k++ {
+		image, updated, err := ctrl.IndexFile("processed/a.png")
+		if err != nil || updated || image.Metadata == nil || !image.Metadata.TakenAt.Equal(taken) || !image.ExtractionFailed {
+			format, args := testutil.FormatTestError(
+				"Expected an unchanged file with the time in milliseconds not to be updated.",
+				map[string]interface{}{
+					"scan":    k,
+					"updated": updated,
+					"got":     image,
+					"error":   err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestGetDuplicates(t *testing.T) {
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	for _, image := range []model.Image{
+		{File: "processed/a.jpg", Hash: "1"},
+		{File: "processed/b.jpg", Hash: "2"},
+		{File: "processed/c.jpg", Hash: "1"},
+		{File: "processed/d.jpg"},
+		{File: "processed/e.jpg"},
+	} {
+		db.UpsertImage(image)
+	}
+
+	expected := [][]model.Image{{
+		{File: "processed/a.jpg", Hash: "1"},
+		{File: "processed/c.jpg", Hash: "1"},
+	}}
+	duplicates, err := ctrl.GetDuplicates()
+	if err != nil || !reflect.DeepEqual(duplicates, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected images with the same hash to be grouped.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      duplicates,
+			})
+		t.Errorf(format, args...)
+	}
+}
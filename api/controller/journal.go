@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+)
+
+// journalEntry records the processing of an unprocessed file, see processImage().
+// The file is moved from Source to Moved, which is the Target in the processed folder, or a file
+// in the journal folder for duplicates, which are only removed once the image is stored.
+// Image is the image to store for the Target.
+type journalEntry struct {
+	Source    string      `json:"source"`
+	Target    string      `json:"target"`
+	Moved     string      `json:"moved"`
+	Duplicate bool        `json:"duplicate"`
+	Image     model.Image `json:"image"`
+}
+
+// writeJournal writes a journal entry to the journal folder and returns the path of the entry.
+// The entry is synced to disk before the file is moved, so that every moved file has a complete entry.
+func writeJournal(entry *journalEntry) (string, error) {
+	dir := filepath.Join(config.Get().Images, config.Get().JournalFolder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile(dir, "*.json")
+	if err != nil {
+		return "", err
+	}
+
+	entry.Moved = entry.Target
+	if entry.Duplicate {
+		entry.Moved = filepath.Join(config.Get().JournalFolder,
+			strings.TrimSuffix(filepath.Base(file.Name()), ".json")+filepath.Ext(entry.Source))
+	}
+
+	err = json.NewEncoder(file).Encode(entry)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// removeJournal removes a journal entry once its processing is committed or rolled back.
+func removeJournal(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logger.Logger().Warnw("Unable to remove a journal entry.", "journal", path, "error", err)
+	}
+}
+
+// Recover completes or rolls back the processing of images that was interrupted, e.g. by a crash.
+// Entries whose file wasn't moved yet are discarded. The processing of moved files is completed,
+// or rolled back if the image can't be stored. Entries that can't be resolved are kept for the next start.
+// It has to run before the image folders are indexed. The recovered images are returned.
+func (c *Controller) Recover() ([]model.Image, error) {
+	recovered := []model.Image{}
+	root := config.Get().Images
+
+	dir := filepath.Join(root, config.Get().JournalFolder)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return recovered, nil
+	} else if err != nil {
+		return recovered, err
+	}
+
+	for _, info := range files {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+		journal := filepath.Join(dir, info.Name())
+
+		// Entries are complete before a file is moved, an incomplete entry was never acted on.
+		var entry journalEntry
+		if data, err := ioutil.ReadFile(journal); err != nil {
+			return recovered, err
+		} else if err := json.Unmarshal(data, &entry); err != nil || entry.Source == "" || entry.Moved == "" {
+			logger.Logger().Warnw("Discarding an incomplete journal entry.", "journal", journal, "error", err)
+			removeJournal(journal)
+			continue
+		}
+
+		if source, err := os.Stat(filepath.Join(root, entry.Source)); err == nil {
+			// An interrupted claimFile() leaves a link of the source behind.
+			if moved, err := os.Stat(filepath.Join(root, entry.Moved)); err == nil && os.SameFile(source, moved) {
+				if err := os.Remove(filepath.Join(root, entry.Moved)); err != nil {
+					return recovered, err
+				}
+			}
+			logger.Logger().Infow("Discarding the processing of an image whose file wasn't moved.", "file", entry.Source)
+			removeJournal(journal)
+			continue
+		} else if !os.IsNotExist(err) {
+			return recovered, err
+		}
+
+		if _, err := os.Stat(filepath.Join(root, entry.Moved)); os.IsNotExist(err) {
+			// Duplicates are only removed once their image is stored, just their sidecar may be left.
+			if entry.Duplicate {
+				logger.Logger().Infow("Completed the processing of a dropped duplicate.", "file", entry.Source, "target", entry.Target)
+				moveSidecar(entry.Source, entry.Target)
+			} else {
+				logger.Logger().Warnw("Discarding the processing of an image whose file doesn't exist anymore.", "file", entry.Source)
+			}
+			removeJournal(journal)
+			continue
+		} else if err != nil {
+			return recovered, err
+		}
+
+		if image, err := c.completeProcessing(entry); err == nil {
+			logger.Logger().Infow("Completed the processing of an image.", "file", entry.Source, "target", entry.Target)
+			moveSidecar(entry.Source, entry.Target)
+			recovered = append(recovered, *image)
+		} else if rollbackErr := rollbackProcessing(entry); rollbackErr != nil {
+			logger.Logger().Errorw("Unable to recover the processing of an image.",
+				"file", entry.Source, "journal", journal, "error", err, "rollback", rollbackErr)
+			continue
+		}
+		removeJournal(journal)
+	}
+
+	return recovered, nil
+}
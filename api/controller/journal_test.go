@@ -0,0 +1,168 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+var errStoreFailed = errors.New("store failed")
+
+// failingStore is an image store whose writes fail, or panic to simulate a crash.
+type failingStore struct {
+	*memory.Store
+	crash bool
+}
+
+func (s *failingStore) UpsertImage(image model.Image) error {
+	if s.crash {
+		panic(errStoreFailed)
+	}
+	return errStoreFailed
+}
+
+// journalEntries returns the number of entries in the journal folder.
+func journalEntries(t *testing.T) int {
+	files, err := ioutil.ReadDir(filepath.Join(config.Get().Images, config.Get().JournalFolder))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal("Unable to read the journal folder.", err)
+	}
+	return len(files)
+}
+
+func TestProcessingRollback(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := &failingStore{Store: memory.NewStore()}
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"processed/a.jpg":   "a",
+		"unprocessed/a.jpg": "a",
+		"unprocessed/b.jpg": "b",
+	})
+
+	for _, file := range []string{"unprocessed/a.jpg", "unprocessed/b.jpg"} {
+		if _, err := ctrl.UpsertImage(model.Image{File: file}); !errors.Is(err, errStoreFailed) {
+			t.Errorf("Expected the store error for %s, got %v.", file, err)
+		}
+		if _, err := os.Stat(filepath.Join(configuration.Images, file)); err != nil {
+			t.Errorf("Expected %s to be moved back into the unprocessed folder: %v", file, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(configuration.Images, "processed/b.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected the processed file to be rolled back.", err)
+	}
+	if entries := journalEntries(t); entries != 0 {
+		t.Errorf("Expected the journal to be empty, got %d entries.", entries)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	writeImageFiles(t, map[string]string{
+		"processed/a.jpg":       "a",
+		"unprocessed/a.jpg":     "a",
+		"unprocessed/b.jpg":     "b",
+		"unprocessed/b.jpg.xmp": "sidecar",
+	})
+
+	// The store crashes after the files were moved.
+	crashing := &failingStore{Store: memory.NewStore(), crash: true}
+	for _, file := range []string{"unprocessed/a.jpg", "unprocessed/b.jpg"} {
+		func() {
+			defer func() { recover() }()
+			controller.New(store.Store{Images: crashing, Categories: crashing}).UpsertImage(model.Image{
+				File:               file,
+				AssignedCategories: []string{"Category 1"},
+			})
+		}()
+	}
+	if entries := journalEntries(t); entries != 3 {
+		t.Fatalf("Expected two journal entries and the moved duplicate, got %d files.", entries)
+	}
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	images, err := ctrl.Recover()
+	if err != nil || len(images) != 2 {
+		format, args := testutil.FormatTestError(
+			"Expected the processing of both images to be completed.",
+			map[string]interface{}{
+				"error": err,
+				"got":   images,
+			})
+		t.Errorf(format, args...)
+	}
+
+	for _, file := range []string{"processed/a.jpg", "processed/b.jpg"} {
+		if image, err := db.GetImage(file); err != nil || !reflect.DeepEqual(image.AssignedCategories, []string{"Category 1"}) {
+			format, args := testutil.FormatTestError(
+				"Expected the image to be stored with its categories.",
+				map[string]interface{}{
+					"file":  file,
+					"error": err,
+					"got":   image,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	for _, file := range []string{"unprocessed/a.jpg", "unprocessed/b.jpg", "unprocessed/b.jpg.xmp"} {
+		if _, err := os.Stat(filepath.Join(configuration.Images, file)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be processed: %v", file, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(configuration.Images, "processed/b.jpg.xmp")); err != nil {
+		t.Errorf("Expected the sidecar to be moved along: %v", err)
+	}
+	if entries := journalEntries(t); entries != 0 {
+		t.Errorf("Expected the journal to be empty, got %d entries.", entries)
+	}
+}
+
+func TestRecoverInterruptedClaim(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	// The file was linked to its target, but the process crashed before the source was removed.
+	writeImageFiles(t, map[string]string{
+		"unprocessed/a.jpg": "a",
+		filepath.Join(configuration.JournalFolder, "entry.json"): `{"source": "unprocessed/a.jpg", "target": "processed/a.jpg", "moved": "processed/a.jpg", "image": {"file": "processed/a.jpg"}}`,
+	})
+	if err := os.MkdirAll(filepath.Join(configuration.Images, "processed"), 0755); err != nil {
+		t.Fatal("Unable to create the processed image folder.", err)
+	}
+	if err := os.Link(filepath.Join(configuration.Images, "unprocessed/a.jpg"), filepath.Join(configuration.Images, "processed/a.jpg")); err != nil {
+		t.Fatal("Unable to link the image.", err)
+	}
+
+	db := memory.NewStore()
+	if images, err := controller.New(store.Store{Images: db, Categories: db}).Recover(); err != nil || len(images) != 0 {
+		t.Errorf("Expected the processing to be discarded, got %v (%v).", images, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(configuration.Images, "unprocessed/a.jpg")); err != nil {
+		t.Errorf("Expected the unprocessed file to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(configuration.Images, "processed/a.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the link of the processed file to be removed: %v", err)
+	}
+	if entries := journalEntries(t); entries != 0 {
+		t.Errorf("Expected the journal to be empty, got %d entries.", entries)
+	}
+}
@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/util"
+)
+
+// Keyword export modes, see ExportKeywords().
+const (
+	KeywordsNone    = "none"
+	KeywordsSidecar = "sidecar"
+	KeywordsIPTC    = "iptc"
+)
+
+// Sources of the proposals of imported keywords, see importKeywords().
+const (
+	KeywordSourceIPTC      = "iptc"
+	KeywordSourceXMP       = "xmp"
+	KeywordSourceLightroom = "lightroom"
+)
+
+// starredRating is the rating that is written together with the starred category.
+const starredRating = 5
+
+// ErrInvalidKeywordExport indicates an export mode other than "sidecar" or "iptc".
+var ErrInvalidKeywordExport = errors.New(`the export mode has to be either "sidecar" or "iptc"`)
+
+// ErrNotProcessed indicates an unprocessed image, whose categories can't be exported.
+var ErrNotProcessed = errors.New("the image is not processed")
+
+// ExportKeywords writes the assigned categories of the images with the given files, or of all processed images
+// if no files are given, back into the files, see exportKeywords(). Without mode the configured sidecar export
+// is used, embedding IPTC keywords has to be requested explicitly.
+// Every image is exported on its own, a failed export doesn't affect the others.
+func (c *Controller) ExportKeywords(mode string, files []string) ([]model.KeywordExportResult, error) {
+	if mode == "" && config.Get().KeywordExport == KeywordsSidecar {
+		mode = KeywordsSidecar
+	}
+	if mode != KeywordsSidecar && mode != KeywordsIPTC {
+		return nil, ErrInvalidKeywordExport
+	}
+
+	images := []model.Image{}
+	if len(files) == 0 {
+		all, err := c.store.Images.AllImages()
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range all {
+			if !image.Unprocessed {
+				images = append(images, image)
+			}
+		}
+	}
+
+	results := []model.KeywordExportResult{}
+	for _, file := range files {
+		if image, err := c.store.Images.GetImage(file); err != nil {
+			results = append(results, model.KeywordExportResult{File: file, Error: err.Error()})
+		} else {
+			images = append(images, *image)
+		}
+	}
+
+	for _, image := range images {
+		result := model.KeywordExportResult{File: image.File, Mode: mode}
+		if fallback, err := c.exportKeywords(&image, mode); err != nil {
+			logger.Logger().Warnw("Unable to export the categories of an image.", "file", image.File, "error", err)
+			result.Mode, result.Error = "", err.Error()
+		} else if fallback != nil {
+			result.Mode, result.Fallback = KeywordsSidecar, fallback.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// autoExportKeywords exports the categories of an image into its sidecar after it was changed, if configured.
+// IPTC keywords are never embedded automatically: rewriting the file changes its hash, which identifies the image.
+// A failed export is logged, the change itself succeeded anyway.
+func (c *Controller) autoExportKeywords(image *model.Image) {
+	if config.Get().KeywordExport == KeywordsSidecar {
+		if _, err := c.exportKeywords(image, KeywordsSidecar); err != nil && !errors.Is(err, ErrNotProcessed) {
+			logger.Logger().Warnw("Unable to export the categories of an image.", "file", image.File, "error", err)
+		}
+	}
+}
+
+// exportKeywords writes the assigned categories of a processed image as keywords (dc:subject) into the XMP sidecar
+// of its file, or embeds them as IPTC keywords. Only JPEG files support IPTC keywords, other files get a sidecar.
+// If configured, the starred category is written into the sidecar as label (xmp:Label) with a rating of 5.
+// Embedding the keywords changes the file, so the image is reindexed for its new hash.
+// If IPTC keywords are requested, but not supported by the file, then the returned fallback is the reason
+// why a sidecar was written instead.
+func (c *Controller) exportKeywords(image *model.Image, mode string) (fallback error, err error) {
+	if image.Unprocessed || isUnprocessed(image.File) {
+		return nil, fmt.Errorf("%w: %s", ErrNotProcessed, image.File)
+	}
+
+	path := filepath.Join(config.Get().Images, image.File)
+
+	if mode == KeywordsIPTC {
+		err := metadata.WriteIPTCKeywords(path, image.AssignedCategories)
+		if err == nil {
+			indexed, _, err := c.IndexFile(image.File)
+			if err != nil {
+				return nil, err
+			}
+			*image = *indexed
+			return nil, nil
+		} else if !errors.Is(err, metadata.ErrUnsupported) {
+			return nil, err
+		}
+		fallback = err
+		logger.Logger().Infow("Writing a sidecar instead of IPTC keywords.", "file", image.File, "reason", err)
+	}
+
+	sidecar := metadata.Sidecar{Keywords: image.AssignedCategories}
+	if config.Get().KeywordExportStarred {
+		sidecar.Label, sidecar.Rating = util.StringPtr(""), starredRating
+		if image.StarredCategory != nil {
+			sidecar.Label = image.StarredCategory
+		}
+	}
+
+	return fallback, metadata.WriteSidecar(metadata.SidecarPath(path), sidecar)
+}
+
+// importKeywords proposes or assigns the keywords that a new unprocessed image already carries (see
+// metadata.FileKeywords()), depending on the configured policy of their source (none, propose or assign).
+// Keywords are matched against the names and aliases of the existing categories case insensitively and take
+// the name of the category. Keywords without category are dropped, unless missing categories are created.
+// Assigned keywords aren't proposed as well.
+func (c *Controller) importKeywords(image *model.Image) error {
+	if !isUnprocessed(image.File) {
+		return nil
+	}
+
+	policies := map[string]string{
+		KeywordSourceIPTC:      config.Get().KeywordImportIPTC,
+		KeywordSourceXMP:       config.Get().KeywordImportXMP,
+		KeywordSourceLightroom: config.Get().KeywordImportLightroom,
+	}
+	enabled := false
+	for _, policy := range policies {
+		enabled = enabled || policy == "propose" || policy == "assign"
+	}
+	if !enabled {
+		return nil
+	}
+
+	path := filepath.Join(config.Get().Images, image.File)
+	keywords, err := metadata.FileKeywords(path)
+	if err != nil {
+		logger.Logger().Debugw("Unable to read the keywords.", "path", path, "error", err)
+		return nil
+	}
+
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return err
+	}
+
+	sources := []struct {
+		source   string
+		keywords []string
+	}{
+		{KeywordSourceIPTC, keywords.IPTC},
+		{KeywordSourceXMP, keywords.XMP},
+		{KeywordSourceLightroom, keywords.Lightroom},
+	}
+
+	missing := []string{}
+	// Assignments take precedence over proposals of other sources.
+	for _, policy := range []string{"assign", "propose"} {
+		for _, source := range sources {
+			if policies[source.source] != policy {
+				continue
+			}
+
+			for _, keyword := range source.keywords {
+				name := store.ResolveCategory(categories, keyword)
+				if name == "" {
+					if !config.Get().KeywordImportCreate {
+						continue
+					}
+					name = keyword
+					categories = append(categories, model.Category{Name: name})
+					missing = append(missing, name)
+				}
+
+				if policy == "assign" {
+					if !util.ContainsString(image.AssignedCategories, name, false) {
+						image.AssignedCategories = append(image.AssignedCategories, name)
+					}
+				} else if !util.ContainsString(image.AssignedCategories, name, false) &&
+					!util.ContainsString(model.ProposalNames(image.ProposedCategories), name, false) {
+					image.ProposedCategories = append(image.ProposedCategories, model.Proposal{
+						Category: name,
+						Score:    1,
+						Source:   source.source,
+					})
+				}
+			}
+		}
+	}
+
+	// A category created concurrently in another case or as alias replaces the keyword.
+	for _, name := range missing {
+		names, err := c.ensureCategories([]string{name})
+		if err != nil {
+			return err
+		}
+		if names[0] != name {
+			replaceImageCategory(image, name, names[0])
+		}
+	}
+	image.ProposedCategories = withoutProposals(image.ProposedCategories, image.AssignedCategories)
+
+	return nil
+}
+
+// moveSidecar moves the XMP sidecar of a file along with the file, unless the new file has a sidecar already,
+// e. g. if the file was a duplicate. A sidecar that can't be moved is left behind.
+func moveSidecar(source string, target string) {
+	from := metadata.SidecarPath(filepath.Join(config.Get().Images, source))
+	to := metadata.SidecarPath(filepath.Join(config.Get().Images, target))
+
+	if _, err := os.Stat(from); err != nil {
+		return
+	}
+	if _, err := os.Stat(to); !os.IsNotExist(err) {
+		logger.Logger().Infow("Leaving a sidecar behind, the target has one already.", "file", source, "target", target)
+		return
+	}
+	if err := os.Rename(from, to); err != nil {
+		logger.Logger().Warnw("Unable to move a sidecar.", "file", source, "target", target, "error", err)
+	}
+}
@@ -0,0 +1,341 @@
+package controller_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/util"
+)
+
+// readSidecar returns the content of the sidecar of a file, or an empty string if it has none.
+func readSidecar(t *testing.T, file string) string {
+	data, err := ioutil.ReadFile(filepath.Join(config.Get().Images, file+".xmp"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal("Unable to read the sidecar.", err)
+	}
+	return string(data)
+}
+
+func TestExportKeywordsOnUpsert(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.KeywordExport = controller.KeywordsSidecar
+	configuration.KeywordExportStarred = true
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"unprocessed/beach.jpg":     "beach",
+		"unprocessed/beach.jpg.xmp": `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description rdf:about="" xmlns:digiKam="http://www.digikam.org/ns/1.0/" digiKam:ColorLabel="3"/></rdf:RDF></x:xmpmeta>`,
+		"processed/sea.jpg":         "sea",
+	})
+
+	if _, err := ctrl.UpsertImage(model.Image{
+		File:               "unprocessed/beach.jpg",
+		AssignedCategories: []string{"Beach"},
+		StarredCategory:    util.StringPtr("Sun"),
+	}); err != nil {
+		t.Fatal("Unable to upsert the image.", err)
+	}
+
+	sidecar := readSidecar(t, "processed/beach.jpg")
+	for _, expected := range []string{`digiKam:ColorLabel="3"`, "<rdf:li>Beach</rdf:li>", "<rdf:li>Sun</rdf:li>", "<xmp:Label>Sun</xmp:Label>", "<xmp:Rating>5</xmp:Rating>"} {
+		if !strings.Contains(sidecar, expected) {
+			format, args := testutil.FormatTestError(
+				"Expected the sidecar to be moved along with the processed image and to hold the categories.",
+				map[string]interface{}{
+					"expected": expected,
+					"got":      sidecar,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	if _, _, err := ctrl.IndexFile("processed/sea.jpg"); err != nil {
+		t.Fatal("Unable to index the image.", err)
+	}
+	if _, err := db.UpdateImage("processed/sea.jpg", func(image *model.Image) error {
+		image.ProposedCategories = []model.Proposal{{Category: "Sea", Score: 0.8}}
+		return nil
+	}); err != nil {
+		t.Fatal("Unable to update the image.", err)
+	}
+	if _, err := ctrl.AcceptProposals("processed/sea.jpg", nil); err != nil {
+		t.Fatal("Unable to accept the proposals.", err)
+	}
+	if sidecar := readSidecar(t, "processed/sea.jpg"); !strings.Contains(sidecar, "<rdf:li>Sea</rdf:li>") {
+		t.Errorf("Expected accepted proposals to be exported, got %s.", sidecar)
+	}
+
+	// Sidecars are not images.
+	if images, err := ctrl.Rescan(); err != nil || len(images) != 0 {
+		t.Errorf("Expected the sidecars not to be indexed, got %v (%v).", images, err)
+	}
+	if report, err := ctrl.CheckConsistency(); err != nil || len(report.OrphanedFiles) != 0 {
+		t.Errorf("Expected the sidecars not to be orphaned, got %v (%v).", report, err)
+	}
+
+	configuration.KeywordExport = controller.KeywordsNone
+	if _, err := ctrl.UpsertImage(model.Image{File: "processed/sea.jpg", AssignedCategories: []string{"Ocean"}}); err != nil {
+		t.Fatal("Unable to upsert the image.", err)
+	}
+	if sidecar := readSidecar(t, "processed/sea.jpg"); strings.Contains(sidecar, "Ocean") {
+		t.Errorf("Expected no export if it is disabled, got %s.", sidecar)
+	}
+}
+
+func TestExportKeywordsIPTCOnDemand(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.KeywordExport = controller.KeywordsIPTC
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	content := "\xFF\xD8\xFF\xDA\x00\x02\x12\x34\xFF\xD9"
+	writeImageFiles(t, map[string]string{"processed/beach.jpg": content})
+	path := filepath.Join(config.Get().Images, "processed/beach.jpg")
+	hash, err := controller.HashFile(path)
+	if err != nil {
+		t.Fatal("Unable to hash the image.", err)
+	}
+
+	image, err := ctrl.UpsertImage(model.Image{File: "processed/beach.jpg", AssignedCategories: []string{"Beach"}})
+	if err != nil {
+		t.Fatal("Unable to upsert the image.", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != content || image.Hash != hash {
+		format, args := testutil.FormatTestError(
+			"Expected a saved image not to be rewritten with IPTC keywords.",
+			map[string]interface{}{
+				"image": image,
+				"hash":  hash,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, err := ctrl.ExportKeywords("", nil); !errors.Is(err, controller.ErrInvalidKeywordExport) {
+		t.Errorf("Expected IPTC keywords not to be embedded without being requested, got %v.", err)
+	}
+}
+
+func TestExportKeywords(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	writeImageFiles(t, map[string]string{
+		"unprocessed/new.jpg": "new",
+		"processed/beach.jpg": "\xFF\xD8\xFF\xDA\x00\x02\x12\x34\xFF\xD9",
+		"processed/sea.png":   "sea",
+	})
+	for file, categories := range map[string][]string{
+		"unprocessed/new.jpg": {},
+		"processed/beach.jpg": {"Beach"},
+		"processed/sea.png":   {"Sea"},
+	} {
+		if _, _, err := ctrl.IndexFile(file); err != nil {
+			t.Fatal("Unable to index the image.", err)
+		}
+		if _, err := db.UpdateImage(file, func(image *model.Image) error {
+			image.AssignedCategories = categories
+			return nil
+		}); err != nil {
+			t.Fatal("Unable to update the image.", err)
+		}
+	}
+	before, err := db.GetImage("processed/beach.jpg")
+	if err != nil {
+		t.Fatal("Unable to get the image.", err)
+	}
+
+	if _, err := ctrl.ExportKeywords("", nil); !errors.Is(err, controller.ErrInvalidKeywordExport) {
+		t.Errorf("Expected an error without export mode, got %v.", err)
+	}
+
+	results, err := ctrl.ExportKeywords(controller.KeywordsIPTC, nil)
+	if err != nil || len(results) != 2 || results[0].Error != "" || results[1].Error != "" {
+		t.Errorf("Expected the processed images to be exported, got %v (%v).", results, err)
+	}
+	for _, result := range results {
+		if result.File == "processed/beach.jpg" && (result.Mode != controller.KeywordsIPTC || result.Fallback != "") ||
+			result.File == "processed/sea.png" && (result.Mode != controller.KeywordsSidecar || result.Fallback == "") {
+			t.Errorf("Expected the result to report a sidecar written instead of IPTC keywords, got %+v.", result)
+		}
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(config.Get().Images, "processed/beach.jpg")); !strings.Contains(string(data), "\x1C\x02\x19\x00\x05Beach") {
+		t.Errorf("Expected the keywords to be embedded into the JPEG file, got %q.", data)
+	}
+	if after, err := db.GetImage("processed/beach.jpg"); err != nil || after.Hash == before.Hash ||
+		!util.ContainsString(after.AssignedCategories, "Beach", false) {
+		t.Errorf("Expected the image to be reindexed with its categories, got %v (%v).", after, err)
+	}
+	if sidecar := readSidecar(t, "processed/beach.jpg"); sidecar != "" {
+		t.Errorf("Expected no sidecar for a JPEG file, got %s.", sidecar)
+	}
+	if sidecar := readSidecar(t, "processed/sea.png"); !strings.Contains(sidecar, "<rdf:li>Sea</rdf:li>") {
+		t.Errorf("Expected a sidecar for other files, got %s.", sidecar)
+	}
+
+	results, err = ctrl.ExportKeywords(controller.KeywordsSidecar, []string{"unprocessed/new.jpg", "processed/missing.jpg"})
+	if err != nil || len(results) != 2 || !strings.HasPrefix(results[1].Error, controller.ErrNotProcessed.Error()) ||
+		results[0].Error == "" {
+		t.Errorf("Expected errors for unprocessed and missing images, got %v (%v).", results, err)
+	}
+}
+
+// iptcJPEG returns a JPEG file with the IPTC keywords.
+func iptcJPEG(keywords ...string) string {
+	iptc := []byte{}
+	for _, keyword := range keywords {
+		iptc = append(iptc, 0x1C, 2, 25, 0, byte(len(keyword)))
+		iptc = append(iptc, keyword...)
+	}
+	if len(iptc)%2 == 1 {
+		iptc = append(iptc, 0)
+	}
+
+	resource := append([]byte("Photoshop 3.0\x008BIM\x04\x04\x00\x00\x00\x00"), byte(len(iptc)>>8), byte(len(iptc)))
+	payload := append(resource, iptc...)
+	segment := append([]byte{0xFF, 0xED, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	return "\xFF\xD8" + string(segment) + "\xFF\xDA\x00\x02\x12\x34\xFF\xD9"
+}
+
+func TestImportKeywords(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.KeywordImportIPTC = "assign"
+	configuration.KeywordImportXMP = "propose"
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	for _, name := range []string{"Beach", "cat"} {
+		if _, err := db.UpsertCategory(model.Category{Name: name}); err != nil {
+			t.Fatal("Unable to create the category.", err)
+		}
+	}
+
+	writeImageFiles(t, map[string]string{
+		"unprocessed/a.jpg": iptcJPEG("beach", "Unknown"),
+		"unprocessed/a.jpg.xmp": `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+			`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:subject><rdf:Bag>` +
+			`<rdf:li>Cat</rdf:li><rdf:li>Beach</rdf:li><rdf:li>Garden</rdf:li>` +
+			`</rdf:Bag></dc:subject></rdf:Description></rdf:RDF></x:xmpmeta>`,
+		"unprocessed/b.jpg": iptcJPEG("Unknown"),
+		"processed/c.jpg":   iptcJPEG("Beach"),
+	})
+
+	tests := []struct {
+		file     string
+		create   bool
+		assigned []string
+		proposed []model.Proposal
+	}{
+		{
+			file:     "unprocessed/a.jpg",
+			assigned: []string{"Beach"},
+			proposed: []model.Proposal{{Category: "cat", Score: 1, Source: controller.KeywordSourceXMP}},
+		},
+		{
+			file:     "unprocessed/b.jpg",
+			create:   true,
+			assigned: []string{"Unknown"},
+			proposed: []model.Proposal{},
+		},
+		{
+			file:     "processed/c.jpg",
+			assigned: []string{},
+			proposed: []model.Proposal{},
+		},
+	}
+
+	for _, test := range tests {
+		configuration.KeywordImportCreate = test.create
+
+		image, _, err := ctrl.IndexFile(test.file)
+		if err != nil {
+			t.Fatal("Unable to index the image.", err)
+		}
+
+		if !reflect.DeepEqual(image.AssignedCategories, test.assigned) || !reflect.DeepEqual(image.ProposedCategories, test.proposed) {
+			format, args := testutil.FormatTestError(
+				"Expected the keywords to be imported by the policy of their source.",
+				map[string]interface{}{
+					"file":             test.file,
+					"expectedAssigned": test.assigned,
+					"expectedProposed": test.proposed,
+					"gotAssigned":      image.AssignedCategories,
+					"gotProposed":      image.ProposedCategories,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	categories, err := db.QueryCategories()
+	if err != nil {
+		t.Fatal("Unable to query the categories.", err)
+	}
+	if len(categories) != 3 {
+		t.Errorf("Expected only the missing category of the second image to be created, got %v.", categories)
+	}
+}
+
+func TestImportKeywordsConcurrentCaseVariant(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.KeywordImportIPTC = "assign"
+	configuration.KeywordImportXMP = "propose"
+	configuration.KeywordImportCreate = true
+
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: racingCategoryStore{db, "Beach"}})
+
+	writeImageFiles(t, map[string]string{
+		"unprocessed/a.jpg": iptcJPEG("beach"),
+		"unprocessed/b.jpg": iptcJPEG(),
+		"unprocessed/b.jpg.xmp": `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+			`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:subject><rdf:Bag>` +
+			`<rdf:li>garden</rdf:li></rdf:Bag></dc:subject></rdf:Description></rdf:RDF></x:xmpmeta>`,
+	})
+
+	image, _, err := ctrl.IndexFile("unprocessed/a.jpg")
+	if err != nil || !reflect.DeepEqual(image.AssignedCategories, []string{"Beach"}) {
+		format, args := testutil.FormatTestError(
+			"Expected a keyword whose category was created concurrently in another case to be assigned by its name.",
+			map[string]interface{}{
+				"error": err,
+				"got":   image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	ctrl = controller.New(store.Store{Images: db, Categories: racingCategoryStore{db, "Garden"}})
+	image, _, err = ctrl.IndexFile("unprocessed/b.jpg")
+	expected := []model.Proposal{{Category: "Garden", Score: 1, Source: controller.KeywordSourceXMP}}
+	if err != nil || !reflect.DeepEqual(image.ProposedCategories, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected a keyword whose category was created concurrently in another case to be proposed by its name.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      image,
+			})
+		t.Errorf(format, args...)
+	}
+}
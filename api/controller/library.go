@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/util"
+)
+
+// Formats of a library, see WriteLibrary().
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Modes of an import, see ImportLibrary().
+const (
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+)
+
+// ErrInvalidFormat indicates a library format other than "json" or "ndjson".
+var ErrInvalidFormat = errors.New(`the format has to be either "json" or "ndjson"`)
+
+// ErrInvalidImportMode indicates an import mode other than "merge" or "replace".
+var ErrInvalidImportMode = errors.New(`the import mode has to be either "merge" or "replace"`)
+
+// ErrUnsupportedVersion indicates a library of a newer or unknown version.
+var ErrUnsupportedVersion = errors.New("the version of the library is not supported")
+
+// ErrInvalidLibrary indicates a library that can't be parsed or has invalid entries.
+var ErrInvalidLibrary = errors.New("invalid library")
+
+// ExportLibrary returns all categories (with their ids) and all images, including the unprocessed ones.
+func (c *Controller) ExportLibrary() (*model.Library, error) {
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Library{
+		Version:    model.LibraryVersion,
+		ExportedAt: time.Now().UTC(),
+		Categories: categories,
+		Images:     images,
+	}, nil
+}
+
+// WriteLibrary writes a library as a single JSON document or as NDJSON, see model.LibraryRecord.
+func WriteLibrary(w io.Writer, library *model.Library, format string) error {
+	encoder := json.NewEncoder(w)
+
+	switch format {
+	case FormatJSON:
+		return encoder.Encode(library)
+	case FormatNDJSON:
+		if err := encoder.Encode(model.LibraryRecord{Version: library.Version, ExportedAt: &library.ExportedAt}); err != nil {
+			return err
+		}
+		for k := range library.Categories {
+			if err := encoder.Encode(model.LibraryRecord{Category: &library.Categories[k]}); err != nil {
+				return err
+			}
+		}
+		for k := range library.Images {
+			if err := encoder.Encode(model.LibraryRecord{Image: &library.Images[k]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return ErrInvalidFormat
+	}
+}
+
+// ReadLibrary reads a library written by WriteLibrary() and validates it.
+func ReadLibrary(r io.Reader, format string) (*model.Library, error) {
+	library := &model.Library{Categories: []model.Category{}, Images: []model.Image{}}
+	decoder := json.NewDecoder(r)
+
+	switch format {
+	case FormatJSON:
+		if err := decoder.Decode(library); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLibrary, err)
+		}
+	case FormatNDJSON:
+		for line := 1; ; line++ {
+			var record model.LibraryRecord
+			if err := decoder.Decode(&record); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidLibrary, line, err)
+			}
+
+			switch {
+			case line == 1:
+				library.Version = record.Version
+				if record.ExportedAt != nil {
+					library.ExportedAt = *record.ExportedAt
+				}
+			case record.Category != nil:
+				library.Categories = append(library.Categories, *record.Category)
+			case record.Image != nil:
+				library.Images = append(library.Images, *record.Image)
+			default:
+				return nil, fmt.Errorf("%w: line %d: neither a category nor an image", ErrInvalidLibrary, line)
+			}
+		}
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	return library, validateLibrary(library)
+}
+
+// validateLibrary checks the version of a library and that its categories have names and its images files
+// inside the image folders, see isImageFile().
+func validateLibrary(library *model.Library) error {
+	if library.Version < 1 || library.Version > model.LibraryVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, library.Version)
+	}
+
+	for _, category := range library.Categories {
+		if category.Name == "" {
+			return fmt.Errorf("%w: a category has no name", ErrInvalidLibrary)
+		}
+	}
+	for _, image := range library.Images {
+		if image.File == "" {
+			return fmt.Errorf("%w: an image has no file", ErrInvalidLibrary)
+		}
+		if !isImageFile(image.File) {
+			return fmt.Errorf("%w: the file %q is not inside the unprocessed or processed folder", ErrInvalidLibrary, image.File)
+		}
+	}
+
+	return nil
+}
+
+// ImportLibrary imports the categories and images of a library. The replace mode deletes all categories
+// and images beforehand. The stores have no transactions, so a failed replace restores the previous library
+// from a snapshot, see restoreLibrary(). The merge mode keeps them: imported categories whose name exists
+// already as name or alias are merged into the existing category, compared case insensitive like the unique
+// index of the names, and only fill its description if it has none and add their free aliases. Imported
+// images of existing files add their categories to the existing ones, while the hashes and the metadata of
+// the existing files are kept.
+// The categories of the images are renamed to the categories they were merged into. Categories keep their
+// imported ids unless the ids are invalid or taken, their parents are set once all categories exist.
+func (c *Controller) ImportLibrary(library *model.Library, mode string) (*model.ImportReport, error) {
+	if mode == "" {
+		mode = ImportMerge
+	}
+	if mode != ImportMerge && mode != ImportReplace {
+		return nil, ErrInvalidImportMode
+	}
+	if err := validateLibrary(library); err != nil {
+		return nil, err
+	}
+
+	if mode == ImportMerge {
+		return c.mergeLibrary(library)
+	}
+
+	snapshot, err := c.ExportLibrary()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.clearLibrary(); err != nil {
+		c.restoreLibrary(snapshot, err)
+		return nil, err
+	}
+	report, err := c.mergeLibrary(library)
+	if err != nil {
+		c.restoreLibrary(snapshot, err)
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// mergeLibrary merges the categories and images of a library into the existing ones, see ImportLibrary().
+func (c *Controller) mergeLibrary(library *model.Library) (*model.ImportReport, error) {
+	report := &model.ImportReport{Renamed: map[string]string{}}
+
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, category := range categories {
+		ids[*category.ID] = true
+	}
+
+	// Maps the imported ids to the ids of the categories they were merged into or created with.
+	importedIDs := map[string]string{}
+
+	for _, category := range library.Categories {
+		if name := store.ResolveCategory(categories, category.Name); name != "" {
+			report.CategoriesMerged++
+			if name != category.Name {
+				report.Renamed[category.Name] = name
+			}
+			if err := c.fillCategory(categories, name, category); err != nil {
+				return nil, err
+			}
+			for _, existing := range categories {
+				if existing.Name == name && category.ID != nil {
+					importedIDs[*category.ID] = *existing.ID
+				}
+			}
+			continue
+		}
+
+		imported := model.Category{
+			Name:        category.Name,
+			Description: category.Description,
+			Aliases:     freeAliases(categories, category.Aliases),
+		}
+		if category.ID != nil && store.ValidID(*category.ID) && !ids[*category.ID] {
+			imported.ID = category.ID
+		}
+
+		created, err := c.store.Categories.UpsertCategory(imported)
+		if err != nil {
+			return nil, err
+		}
+		if created.ID != nil {
+			imported.ID = created.ID
+			ids[*created.ID] = true
+		}
+		if category.ID != nil && imported.ID != nil {
+			importedIDs[*category.ID] = *imported.ID
+		}
+		categories = append(categories, imported)
+		report.CategoriesCreated++
+	}
+
+	if err := c.importParents(library.Categories, categories, importedIDs); err != nil {
+		return nil, err
+	}
+
+	for _, image := range library.Images {
+		renameCategories(&image, categories)
+
+		if existing, err := c.store.Images.GetImage(image.File); err == nil {
+			mergeCategories(&image, *existing)
+			image.Hash, image.PerceptualHash = existing.Hash, existing.PerceptualHash
+			image.Metadata, image.Unprocessed = existing.Metadata, existing.Unprocessed
+			image.ExtractionFailed = existing.ExtractionFailed
+			report.ImagesMerged++
+		} else if errors.Is(err, store.ErrNotFound) {
+			report.ImagesCreated++
+		} else {
+			return nil, err
+		}
+
+		if err := c.store.Images.UpsertImage(image); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// importParents sets the parents of the imported categories, once all of them exist. Categories that have
+// a parent already keep it, parents that would create a cycle are skipped.
+func (c *Controller) importParents(library []model.Category, categories []model.Category, importedIDs map[string]string) error {
+	for _, category := range library {
+		if category.ID == nil || category.ParentID == nil {
+			continue
+		}
+		parentID, ok := importedIDs[*category.ParentID]
+		if !ok {
+			continue
+		}
+
+		for _, target := range categories {
+			if *target.ID != importedIDs[*category.ID] || target.ParentID != nil {
+				continue
+			}
+
+			target.ParentID = &parentID
+			if _, err := c.store.Categories.UpsertCategory(target); errors.Is(err, store.ErrInvalidParent) {
+				logger.Logger().Warnw("Skipping the invalid parent of an imported category.", "category", target.Name, "error", err)
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// restoreLibrary replaces the library by a snapshot taken before a replace import failed with {cause}.
+func (c *Controller) restoreLibrary(snapshot *model.Library, cause error) {
+	logger.Logger().Errorw("Unable to replace the library, restoring the previous one.", "error", cause)
+
+	err := c.clearLibrary()
+	if err == nil {
+		_, err = c.mergeLibrary(snapshot)
+	}
+	if err != nil {
+		logger.Logger().Errorw("Unable to restore the previous library, it may be incomplete. "+
+			"Import a backup to recover it.", "error", err)
+	} else {
+		logger.Logger().Infow("Previous library restored.", "categories", len(snapshot.Categories), "images", len(snapshot.Images))
+	}
+}
+
+// clearLibrary deletes all images and categories.
+func (c *Controller) clearLibrary() error {
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if err := c.store.Images.DeleteImage(image.File); err != nil {
+			return err
+		}
+	}
+
+	categories, err := c.store.Categories.QueryCategories()
+	if err != nil {
+		return err
+	}
+	for _, category := range categories {
+		if err := c.store.Categories.DeleteCategory(*category.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fillCategory sets the description of the category with the given name to the one of the imported category,
+// if it has none, and adds the aliases of the imported category that no category has yet.
+func (c *Controller) fillCategory(categories []model.Category, name string, imported model.Category) error {
+	for k, category := range categories {
+		if category.Name != name {
+			continue
+		}
+
+		aliases := freeAliases(categories, imported.Aliases)
+		if (category.Description != "" || imported.Description == "") && len(aliases) == 0 {
+			return nil
+		}
+
+		if category.Description == "" {
+			categories[k].Description = imported.Description
+		}
+		categories[k].Aliases = append(categories[k].Aliases, aliases...)
+		_, err := c.store.Categories.UpsertCategory(categories[k])
+		return err
+	}
+	return nil
+}
+
+// freeAliases returns the aliases that match neither the name nor an alias of a category, or nil if there are none.
+func freeAliases(categories []model.Category, aliases []string) []string {
+	var free []string
+	for _, alias := range aliases {
+		if store.ResolveCategory(categories, alias) == "" && !util.ContainsString(free, alias, false) {
+			free = append(free, alias)
+		}
+	}
+	return free
+}
+
+// renameCategories replaces the categories of an image by the names of the matching categories (by name or alias,
+// compared case insensitive). Categories that became duplicates are dropped. Empty lists are initialized.
+func renameCategories(image *model.Image, categories []model.Category) {
+	rename := func(category string) string {
+		if name := store.ResolveCategory(categories, category); name != "" {
+			return name
+		}
+		return category
+	}
+	renameAll := func(categories []string) []string {
+		renamed := []string{}
+		for _, category := range categories {
+			if category = rename(category); !util.ContainsString(renamed, category, false) {
+				renamed = append(renamed, category)
+			}
+		}
+		return renamed
+	}
+
+	image.AssignedCategories = renameAll(image.AssignedCategories)
+	if len(image.RejectedCategories) > 0 {
+		image.RejectedCategories = renameAll(image.RejectedCategories)
+	}
+
+	proposals := []model.Proposal{}
+	for _, proposal := range image.ProposedCategories {
+		proposal.Category = rename(proposal.Category)
+		if !util.ContainsString(model.ProposalNames(proposals), proposal.Category, false) {
+			proposals = append(proposals, proposal)
+		}
+	}
+	image.ProposedCategories = proposals
+
+	if image.StarredCategory != nil && *image.StarredCategory != "" {
+		image.StarredCategory = util.StringPtr(rename(*image.StarredCategory))
+	}
+}
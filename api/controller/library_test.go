@@ -0,0 +1,338 @@
+package controller_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/util"
+)
+
+// libraryStore creates a store with the given categories and images.
+func libraryStore(t *testing.T, categories []model.Category, images []model.Image) *memory.Store {
+	db := memory.NewStore()
+	for _, category := range categories {
+		if _, err := db.UpsertCategory(category); err != nil {
+			t.Fatal("Unable to create the category.", err)
+		}
+	}
+	for _, image := range images {
+		if err := db.UpsertImage(image); err != nil {
+			t.Fatal("Unable to store the image.", err)
+		}
+	}
+	return db
+}
+
+func TestLibraryRoundTrip(t *testing.T) {
+	config.Load()
+
+	categories := []model.Category{
+		{ID: util.StringPtr("5f1e9b3c0000000000000001"), Name: "Cat", Description: "Cats"},
+		{ID: util.StringPtr("5f1e9b3c0000000000000002"), Name: "Dog", ParentID: util.StringPtr("5f1e9b3c0000000000000001")},
+	}
+	images := []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"Cat"},
+			ProposedCategories: []model.Proposal{{Category: "Dog", Score: 0.7, Source: "knn"}},
+			StarredCategory:    util.StringPtr("Cat"),
+			RejectedCategories: []string{"Bird"},
+			Hash:               "a",
+			Metadata:           &model.Metadata{Make: "Canon", Width: 10, Height: 20},
+		},
+		{File: "unprocessed/b.jpg", AssignedCategories: []string{}, ProposedCategories: []model.Proposal{}, Unprocessed: true},
+	}
+
+	for _, format := range []string{controller.FormatJSON, controller.FormatNDJSON} {
+		source := libraryStore(t, categories, images)
+		library, err := controller.New(store.Store{Images: source, Categories: source}).ExportLibrary()
+		if err != nil {
+			t.Fatal("Unable to export the library.", err)
+		}
+
+		var buf bytes.Buffer
+		if err := controller.WriteLibrary(&buf, library, format); err != nil {
+			t.Fatal("Unable to write the library.", err)
+		}
+		if format == controller.FormatNDJSON && strings.Count(buf.String(), "\n") != 5 {
+			t.Errorf("Expected a line for the header, each category and each image, got %s.", buf.String())
+		}
+
+		read, err := controller.ReadLibrary(&buf, format)
+		if err != nil {
+			t.Fatal("Unable to read the library.", err)
+		}
+
+		target := memory.NewStore()
+		report, err := controller.New(store.Store{Images: target, Categories: target}).ImportLibrary(read, controller.ImportReplace)
+		if err != nil {
+			t.Fatal("Unable to import the library.", err)
+		}
+
+		gotCategories, _ := target.QueryCategories()
+		gotImages, _ := target.AllImages()
+		if report.CategoriesCreated != 2 || report.ImagesCreated != 2 ||
+			!reflect.DeepEqual(gotCategories, categories) || !reflect.DeepEqual(gotImages, images) {
+			format, args := testutil.FormatTestError(
+				"Expected the library to be restored with the ids of the categories.",
+				map[string]interface{}{
+					"format":             format,
+					"report":             report,
+					"expectedCategories": categories,
+					"gotCategories":      gotCategories,
+					"expectedImages":     images,
+					"gotImages":          gotImages,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestImportLibraryMerge(t *testing.T) {
+	config.Load()
+
+	db := libraryStore(t,
+		[]model.Category{
+			{ID: util.StringPtr("5f1e9b3c0000000000000001"), Name: "cat"},
+			{ID: util.StringPtr("5f1e9b3c0000000000000002"), Name: "Tree", Description: "Trees"},
+		},
+		[]model.Image{{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"Tree"},
+			ProposedCategories: []model.Proposal{},
+			RejectedCategories: []string{"Dog"},
+			Hash:               "local",
+			ExtractionFailed:   true,
+		}},
+	)
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	library := &model.Library{
+		Version: model.LibraryVersion,
+		Categories: []model.Category{
+			{ID: util.StringPtr("5f1e9b3c0000000000000009"), Name: "Cat", Description: "Cats"},
+			{ID: util.StringPtr("5f1e9b3c0000000000000002"), Name: "Dog"},
+			{ID: util.StringPtr("invalid"), Name: "DOG"},
+			{Name: "tree", Description: "Other trees"},
+		},
+		Images: []model.Image{
+			{
+				File:               "processed/a.jpg",
+				AssignedCategories: []string{"CAT", "Cat"},
+				ProposedCategories: []model.Proposal{{Category: "dog", Score: 0.5}},
+				StarredCategory:    util.StringPtr("CAT"),
+				Hash:               "remote",
+			},
+			{File: "processed/b.jpg", AssignedCategories: []string{"TREE"}},
+		},
+	}
+
+	report, err := ctrl.ImportLibrary(library, controller.ImportMerge)
+	if err != nil {
+		t.Fatal("Unable to import the library.", err)
+	}
+
+	expectedReport := &model.ImportReport{
+		CategoriesCreated: 1,
+		CategoriesMerged:  3,
+		ImagesCreated:     1,
+		ImagesMerged:      1,
+		Renamed:           map[string]string{"Cat": "cat", "DOG": "Dog", "tree": "Tree"},
+	}
+	if !reflect.DeepEqual(report, expectedReport) {
+		format, args := testutil.FormatTestError(
+			"Expected the categories to be merged case insensitively.",
+			map[string]interface{}{
+				"expected": expectedReport,
+				"got":      report,
+			})
+		t.Errorf(format, args...)
+	}
+
+	categories, _ := db.QueryCategories()
+	expectedCategories := []model.Category{
+		{ID: util.StringPtr("5f1e9b3c0000000000000001"), Name: "cat", Description: "Cats"},
+		{ID: util.StringPtr("5f1e9b3c0000000000000002"), Name: "Tree", Description: "Trees"},
+		{ID: categories[len(categories)-1].ID, Name: "Dog"},
+	}
+	if !reflect.DeepEqual(categories, expectedCategories) || *categories[2].ID == "5f1e9b3c0000000000000002" {
+		format, args := testutil.FormatTestError(
+			"Expected the existing categories to be kept and taken ids to be replaced.",
+			map[string]interface{}{
+				"expected": expectedCategories,
+				"got":      categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	expectedImages := []model.Image{
+		{
+			File:               "processed/a.jpg",
+			AssignedCategories: []string{"cat", "Tree"},
+			ProposedCategories: []model.Proposal{{Category: "Dog", Score: 0.5}},
+			StarredCategory:    util.StringPtr("cat"),
+			RejectedCategories: []string{"Dog"},
+			Hash:               "local",
+			ExtractionFailed:   true,
+		},
+		{File: "processed/b.jpg", AssignedCategories: []string{"Tree"}, ProposedCategories: []model.Proposal{}},
+	}
+	if images, _ := db.AllImages(); !reflect.DeepEqual(images, expectedImages) {
+		format, args := testutil.FormatTestError(
+			"Expected the images to be merged with the renamed categories.",
+			map[string]interface{}{
+				"expected": expectedImages,
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+// brokenFileStore fails to store the image of a single file.
+type brokenFileStore struct {
+	*memory.Store
+	file string
+}
+
+func (s brokenFileStore) UpsertImage(image model.Image) error {
+	if image.File == s.file {
+		return errStoreFailed
+	}
+	return s.Store.UpsertImage(image)
+}
+
+func TestImportLibraryReplaceFailure(t *testing.T) {
+	config.Load()
+
+	categories := []model.Category{{ID: util.StringPtr("5f1e9b3c0000000000000001"), Name: "Cat"}}
+	images := []model.Image{{
+		File:               "processed/a.jpg",
+		AssignedCategories: []string{"Cat"},
+		ProposedCategories: []model.Proposal{},
+		Hash:               "a",
+	}}
+	db := libraryStore(t, categories, images)
+	ctrl := controller.New(store.Store{Images: brokenFileStore{db, "processed/broken.jpg"}, Categories: db})
+
+	library := &model.Library{
+		Version:    model.LibraryVersion,
+		Categories: []model.Category{{Name: "Dog"}},
+		Images:     []model.Image{{File: "processed/b.jpg"}, {File: "processed/broken.jpg"}},
+	}
+	if _, err := ctrl.ImportLibrary(library, controller.ImportReplace); err == nil {
+		t.Fatal("Expected the import to fail.")
+	}
+
+	gotCategories, _ := db.QueryCategories()
+	gotImages, _ := db.AllImages()
+	if !reflect.DeepEqual(gotCategories, categories) || !reflect.DeepEqual(gotImages, images) {
+		format, args := testutil.FormatTestError(
+			"Expected a failed replace to restore the previous library.",
+			map[string]interface{}{
+				"categories": gotCategories,
+				"images":     gotImages,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestReadLibraryInvalid(t *testing.T) {
+	config.Load()
+
+	tests := []struct {
+		format   string
+		data     string
+		expected error
+	}{
+		{"xml", `{}`, controller.ErrInvalidFormat},
+		{controller.FormatJSON, `{"version": 2, "categories": [], "images": []}`, controller.ErrUnsupportedVersion},
+		{controller.FormatJSON, `{"version": 1`, controller.ErrInvalidLibrary},
+		{controller.FormatJSON, `{"version": 1, "images": [{"assignedCategories": []}]}`, controller.ErrInvalidLibrary},
+		{controller.FormatJSON, `{"version": 1, "images": [{"file": "processed/../../x.jpg"}]}`, controller.ErrInvalidLibrary},
+		{controller.FormatJSON, `{"version": 1, "images": [{"file": "/etc/passwd"}]}`, controller.ErrInvalidLibrary},
+		{controller.FormatJSON, `{"version": 1, "images": [{"file": "thumbnails/x.jpg"}]}`, controller.ErrInvalidLibrary},
+		{controller.FormatJSON, `{"version": 1, "images": [{"file": "processed"}]}`, controller.ErrInvalidLibrary},
+		{controller.FormatNDJSON, "{\"version\": 1}\n{\"category\": {\"name\": \"\"}}\n", controller.ErrInvalidLibrary},
+		{controller.FormatNDJSON, "{\"version\": 1}\n{}\n", controller.ErrInvalidLibrary},
+		{controller.FormatNDJSON, "{\"category\": {\"name\": \"Cat\"}}\n", controller.ErrUnsupportedVersion},
+	}
+
+	for _, test := range tests {
+		if _, err := controller.ReadLibrary(strings.NewReader(test.data), test.format); !errors.Is(err, test.expected) {
+			format, args := testutil.FormatTestError(
+				"Expected an invalid library to be rejected.",
+				map[string]interface{}{
+					"data":     test.data,
+					"expected": test.expected,
+					"got":      err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	db := memory.NewStore()
+	if _, err := controller.New(store.Store{Images: db, Categories: db}).ImportLibrary(
+		&model.Library{Version: model.LibraryVersion}, "append",
+	); !errors.Is(err, controller.ErrInvalidImportMode) {
+		t.Errorf("Expected an invalid import mode to be rejected, got %v.", err)
+	}
+}
+
+func TestImportLibraryAliases(t *testing.T) {
+	config.Load()
+
+	db := libraryStore(t, []model.Category{{Name: "Cats", Aliases: []string{"Kitty"}}}, nil)
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+
+	library := &model.Library{
+		Version: model.LibraryVersion,
+		Categories: []model.Category{
+			{Name: "kitty", Aliases: []string{"Cat", "Cats"}},
+			{Name: "Dogs", Aliases: []string{"Dog", "KITTY"}},
+		},
+		Images: []model.Image{{File: "processed/a.jpg", AssignedCategories: []string{"Kitty", "Dog", "cat"}}},
+	}
+
+	report, err := ctrl.ImportLibrary(library, controller.ImportMerge)
+	if err != nil {
+		t.Fatal("Unable to import the library.", err)
+	}
+	if !reflect.DeepEqual(report.Renamed, map[string]string{"kitty": "Cats"}) || report.CategoriesCreated != 1 {
+		t.Errorf("Expected the category to be merged by its alias, got %+v.", report)
+	}
+
+	categories, _ := db.QueryCategories()
+	expectedCategories := []model.Category{
+		{ID: categories[0].ID, Name: "Cats", Aliases: []string{"Kitty", "Cat"}},
+		{ID: categories[len(categories)-1].ID, Name: "Dogs", Aliases: []string{"Dog"}},
+	}
+	if !reflect.DeepEqual(categories, expectedCategories) {
+		format, args := testutil.FormatTestError(
+			"Expected only the aliases that no category has yet to be imported.",
+			map[string]interface{}{
+				"expected": expectedCategories,
+				"got":      categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	expected := []string{"Cats", "Dogs"}
+	if image, _ := db.GetImage("processed/a.jpg"); !reflect.DeepEqual(image.AssignedCategories, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the categories of the image to be resolved by their aliases.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      image.AssignedCategories,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/util"
+)
+
+// ErrNotProposed indicates that a category to accept is not proposed for the image.
+var ErrNotProposed = errors.New("the category is not proposed for the image")
+
+// ErrInvalidAction indicates a review action other than "accept" or "reject".
+var ErrInvalidAction = errors.New(`the action has to be either "accept" or "reject"`)
+
+// AcceptProposals moves proposed categories of an image into its assigned categories.
+// Without categories all proposals are accepted. Categories that are not proposed result in ErrNotProposed.
+// Accepted categories are no longer rejected.
+// The assigned categories are exported as keywords, if configured, see ExportKeywords().
+func (c *Controller) AcceptProposals(file string, categories []string) (*model.Image, error) {
+	image, err := c.store.Images.UpdateImage(file, func(image *model.Image) error {
+		// The update may be retried with the current image, so the proposals are read on every attempt.
+		accepted := categories
+		if len(accepted) == 0 {
+			accepted = model.ProposalNames(image.ProposedCategories)
+		}
+
+		for _, category := range accepted {
+			if !util.ContainsString(model.ProposalNames(image.ProposedCategories), category, false) {
+				return fmt.Errorf("%w: %s", ErrNotProposed, category)
+			}
+		}
+
+		for _, proposal := range image.ProposedCategories {
+			if util.ContainsString(accepted, proposal.Category, false) &&
+				!util.ContainsString(image.AssignedCategories, proposal.Category, false) {
+				image.AssignedCategories = append(image.AssignedCategories, proposal.Category)
+			}
+		}
+		image.ProposedCategories = withoutProposals(image.ProposedCategories, accepted)
+		image.RejectedCategories = withoutCategories(image.RejectedCategories, accepted)
+
+		return nil
+	})
+
+	if err == nil {
+		c.autoExportKeywords(image)
+	}
+	return image, err
+}
+
+// RejectProposals removes proposed categories of an image and records them as rejected,
+// so that they are never proposed again. Without categories all proposals are rejected.
+// Categories can be rejected before they are proposed.
+func (c *Controller) RejectProposals(file string, categories []string) (*model.Image, error) {
+	return c.store.Images.UpdateImage(file, func(image *model.Image) error {
+		rejected := categories
+		if len(rejected) == 0 {
+			rejected = model.ProposalNames(image.ProposedCategories)
+		}
+
+		for _, category := range rejected {
+			if !util.ContainsString(image.RejectedCategories, category, false) {
+				image.RejectedCategories = append(image.RejectedCategories, category)
+			}
+		}
+		image.ProposedCategories = withoutProposals(image.ProposedCategories, rejected)
+
+		return nil
+	})
+}
+
+// ReviewProposals accepts or rejects the proposals of multiple images.
+// Every image is updated on its own, a failed review doesn't affect the others.
+func (c *Controller) ReviewProposals(reviews []model.ProposalReview) []model.ProposalReviewResult {
+	results := make([]model.ProposalReviewResult, len(reviews))
+
+	for k, review := range reviews {
+		var image *model.Image
+		var err error
+
+		switch strings.ToLower(review.Action) {
+		case "accept":
+			image, err = c.AcceptProposals(review.File, review.Categories)
+		case "reject":
+			image, err = c.RejectProposals(review.File, review.Categories)
+		default:
+			err = ErrInvalidAction
+		}
+
+		results[k] = model.ProposalReviewResult{File: review.File, Image: image}
+		if err != nil {
+			results[k].Error = err.Error()
+		}
+	}
+
+	return results
+}
+
+// withoutProposals returns the proposals that are not of the given categories.
+func withoutProposals(proposals []model.Proposal, categories []string) []model.Proposal {
+	remaining := []model.Proposal{}
+	for _, proposal := range proposals {
+		if !util.ContainsString(categories, proposal.Category, false) {
+			remaining = append(remaining, proposal)
+		}
+	}
+
+	return remaining
+}
+
+// withoutCategories returns the categories that are not of the removed ones, compared case insensitive.
+func withoutCategories(categories []string, removed []string) []string {
+	if categories == nil {
+		return nil
+	}
+
+	remaining := []string{}
+	for _, category := range categories {
+		if !util.ContainsString(removed, category, false) {
+			remaining = append(remaining, category)
+		}
+	}
+
+	return remaining
+}
@@ -0,0 +1,176 @@
+package controller_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+// createReviewStore creates a store with an image that has three proposals.
+func createReviewStore(t *testing.T) *controller.Controller {
+	db := memory.NewStore()
+	err := db.UpsertImage(model.Image{
+		File:               "processed/test.jpg",
+		AssignedCategories: []string{"Category 1"},
+		ProposedCategories: []model.Proposal{
+			{Category: "Category 2", Score: 0.9},
+			{Category: "Category 3", Score: 0.8},
+			{Category: "Category 4", Score: 0.7},
+		},
+	})
+	if err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+
+	return controller.New(store.Store{Images: db, Categories: db})
+}
+
+func TestAcceptProposals(t *testing.T) {
+	ctrl := createReviewStore(t)
+
+	image, err := ctrl.AcceptProposals("processed/test.jpg", []string{"category 3"})
+	if err != nil ||
+		!reflect.DeepEqual(image.AssignedCategories, []string{"Category 1", "Category 3"}) ||
+		!reflect.DeepEqual(model.ProposalNames(image.ProposedCategories), []string{"Category 2", "Category 4"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the accepted proposal to be assigned.",
+			map[string]interface{}{
+				"error": err,
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, err := ctrl.AcceptProposals("processed/test.jpg", []string{"Category 2", "Category 5"}); !errors.Is(err, controller.ErrNotProposed) {
+		format, args := testutil.FormatTestError(
+			"Expected categories that are not proposed to be refused.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	image, err = ctrl.AcceptProposals("processed/test.jpg", nil)
+	if err != nil || len(image.ProposedCategories) != 0 ||
+		!reflect.DeepEqual(image.AssignedCategories, []string{"Category 1", "Category 3", "Category 2", "Category 4"}) {
+		format, args := testutil.FormatTestError(
+			"Expected all proposals to be accepted without categories.",
+			map[string]interface{}{
+				"error": err,
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, err := ctrl.AcceptProposals("processed/unknown.jpg", nil); !errors.Is(err, store.ErrNotFound) {
+		t.Error("Expected unknown images to not be found.", err)
+	}
+}
+
+// retryingStore applies every update to an outdated copy of the image first, like a store whose
+// compare-and-swap failed because of a concurrent update.
+type retryingStore struct {
+	*memory.Store
+}
+
+func (s retryingStore) UpdateImage(file string, update func(image *model.Image) error) (*model.Image, error) {
+	if image, err := s.GetImage(file); err == nil {
+		image.ProposedCategories = append(image.ProposedCategories, model.Proposal{Category: "Outdated", Score: 0.5})
+		update(image)
+	}
+	return s.Store.UpdateImage(file, update)
+}
+
+func TestAcceptProposalsRetry(t *testing.T) {
+	db := memory.NewStore()
+	err := db.UpsertImage(model.Image{
+		File:               "processed/test.jpg",
+		AssignedCategories: []string{},
+		ProposedCategories: []model.Proposal{{Category: "Category 2", Score: 0.9}},
+		RejectedCategories: []string{"category 2", "Category 3"},
+	})
+	if err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+	ctrl := controller.New(store.Store{Images: retryingStore{db}, Categories: db})
+
+	image, err := ctrl.AcceptProposals("processed/test.jpg", nil)
+	if err != nil || !reflect.DeepEqual(image.AssignedCategories, []string{"Category 2"}) ||
+		!reflect.DeepEqual(image.RejectedCategories, []string{"Category 3"}) {
+		format, args := testutil.FormatTestError(
+			"Expected a retried update to accept the current proposals and to remove them from the rejected categories.",
+			map[string]interface{}{
+				"error": err,
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	image, err = ctrl.RejectProposals("processed/test.jpg", nil)
+	if err != nil || !reflect.DeepEqual(image.RejectedCategories, []string{"Category 3"}) {
+		format, args := testutil.FormatTestError(
+			"Expected a retried update to reject the current proposals only.",
+			map[string]interface{}{
+				"error": err,
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestRejectProposals(t *testing.T) {
+	ctrl := createReviewStore(t)
+
+	image, err := ctrl.RejectProposals("processed/test.jpg", []string{"Category 2", "Category 5"})
+	if err != nil ||
+		!reflect.DeepEqual(image.RejectedCategories, []string{"Category 2", "Category 5"}) ||
+		!reflect.DeepEqual(model.ProposalNames(image.ProposedCategories), []string{"Category 3", "Category 4"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the rejected categories to be recorded and removed from the proposals.",
+			map[string]interface{}{
+				"error": err,
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	image, err = ctrl.RejectProposals("processed/test.jpg", nil)
+	if err != nil || len(image.ProposedCategories) != 0 ||
+		!reflect.DeepEqual(image.RejectedCategories, []string{"Category 2", "Category 5", "Category 3", "Category 4"}) {
+		format, args := testutil.FormatTestError(
+			"Expected all proposals to be rejected without categories.",
+			map[string]interface{}{
+				"error": err,
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestReviewProposals(t *testing.T) {
+	ctrl := createReviewStore(t)
+
+	results := ctrl.ReviewProposals([]model.ProposalReview{
+		{File: "processed/test.jpg", Action: "accept", Categories: []string{"Category 2"}},
+		{File: "processed/test.jpg", Action: "reject", Categories: []string{"Category 3"}},
+		{File: "processed/test.jpg", Action: "ignore"},
+		{File: "processed/unknown.jpg", Action: "accept"},
+	})
+
+	if len(results) != 4 || results[0].Error != "" || results[1].Error != "" ||
+		results[2].Error != controller.ErrInvalidAction.Error() || results[3].Error != store.ErrNotFound.Error() ||
+		!reflect.DeepEqual(model.ProposalNames(results[1].Image.ProposedCategories), []string{"Category 4"}) {
+		format, args := testutil.FormatTestError(
+			"Expected every review to be applied on its own.",
+			map[string]interface{}{
+				"results": results,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"errors"
+	"sort"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/phash"
+	"tagallery.com/api/store"
+)
+
+// ErrNoPerceptualHash indicates that no perceptual hash could be computed for an image.
+var ErrNoPerceptualHash = errors.New("the image has no perceptual hash")
+
+// GetSimilarImages returns the images whose perceptual hash differs in at most {maxDistance} bits
+// from the one of the given file, sorted by their distance. The image of the file itself is excluded.
+func (c *Controller) GetSimilarImages(file string, maxDistance int) ([]model.SimilarImage, error) {
+	hash, err := c.filePerceptualHash(file)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	similar := []model.SimilarImage{}
+	for _, image := range images {
+		if image.File == file || image.PerceptualHash == "" {
+			continue
+		}
+
+		other, err := phash.Parse(image.PerceptualHash)
+		if err != nil {
+			continue
+		}
+
+		if distance := phash.Distance(hash, other); distance <= maxDistance {
+			similar = append(similar, model.SimilarImage{Image: image, Distance: distance})
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+
+	return similar, nil
+}
+
+// GetSimilarClusters groups images whose perceptual hashes differ in at most {maxDistance} bits.
+// Images are clustered transitively: two images share a group if a chain of similar images connects them.
+// Only groups of at least two images are returned.
+func (c *Controller) GetSimilarClusters(maxDistance int) ([][]model.Image, error) {
+	all, err := c.store.Images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	images := []model.Image{}
+	hashes := []uint64{}
+	for _, image := range all {
+		if hash, err := phash.Parse(image.PerceptualHash); err == nil {
+			images = append(images, image)
+			hashes = append(hashes, hash)
+		}
+	}
+
+	// Union-find over all pairs of similar images.
+	parents := make([]int, len(images))
+	for k := range parents {
+		parents[k] = k
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if phash.Distance(hashes[i], hashes[j]) <= maxDistance {
+				parents[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := map[int][]model.Image{}
+	roots := []int{}
+	for k, image := range images {
+		root := find(k)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], image)
+	}
+
+	clusters := [][]model.Image{}
+	for _, root := range roots {
+		if len(groups[root]) > 1 {
+			clusters = append(clusters, groups[root])
+		}
+	}
+
+	return clusters, nil
+}
+
+// filePerceptualHash returns the perceptual hash of the image of a file.
+// If the file is not indexed yet, then the hash is computed from the file itself.
+func (c *Controller) filePerceptualHash(file string) (uint64, error) {
+	image, err := c.store.Images.GetImage(file)
+	if err == nil && image.PerceptualHash != "" {
+		return phash.Parse(image.PerceptualHash)
+	} else if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return 0, err
+	}
+
+	path, err := ImagePath(file)
+	if err != nil {
+		return 0, err
+	}
+
+	hash := perceptualHash(path)
+	if hash == "" {
+		return 0, ErrNoPerceptualHash
+	}
+
+	return phash.Parse(hash)
+}
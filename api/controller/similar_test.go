@@ -0,0 +1,71 @@
+package controller_test
+
+import (
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+var similarImageFixtures = []model.Image{
+	{File: "processed/a.jpg", PerceptualHash: "ffffffff00000000"},
+	{File: "processed/b.jpg", PerceptualHash: "ffffffff00000001"},
+	{File: "processed/c.jpg", PerceptualHash: "0000000000000000"},
+	{File: "unprocessed/d.jpg", PerceptualHash: "ffffffff00000003", Unprocessed: true},
+	{File: "processed/e.jpg"},
+	{File: "processed/f.jpg", PerceptualHash: "0000000000000003"},
+}
+
+// createSimilarImageFixtures creates a controller whose store contains the similar image fixtures.
+func createSimilarImageFixtures() *controller.Controller {
+	db := memory.NewStore()
+	for _, image := range similarImageFixtures {
+		db.UpsertImage(image)
+	}
+
+	return controller.New(store.Store{Images: db, Categories: db})
+}
+
+func TestGetSimilarImages(t *testing.T) {
+	ctrl := createSimilarImageFixtures()
+
+	expected := []model.SimilarImage{
+		{Image: similarImageFixtures[1], Distance: 1},
+		{Image: similarImageFixtures[3], Distance: 2},
+	}
+	images, err := ctrl.GetSimilarImages("processed/a.jpg", 5)
+	if err != nil || !reflect.DeepEqual(images, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected similar images sorted by their distance.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestGetSimilarClusters(t *testing.T) {
+	ctrl := createSimilarImageFixtures()
+
+	expected := [][]model.Image{
+		{similarImageFixtures[0], similarImageFixtures[1], similarImageFixtures[3]},
+		{similarImageFixtures[2], similarImageFixtures[5]},
+	}
+	clusters, err := ctrl.GetSimilarClusters(2)
+	if err != nil || !reflect.DeepEqual(clusters, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected similar images to be clustered.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      clusters,
+			})
+		t.Errorf(format, args...)
+	}
+}
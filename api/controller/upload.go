@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+)
+
+// ErrUnsupportedMediaType indicates that an uploaded file is not an image.
+var ErrUnsupportedMediaType = errors.New("the file is not a supported image")
+
+// imageTypes maps the supported content types to their default file extension.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// DetectImageType sniffs the content type of a file by its magic bytes.
+// ErrUnsupportedMediaType is returned if it is not one of the supported image types.
+func DetectImageType(r io.Reader) (string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	contentType := http.DetectContentType(header[:n])
+	if _, ok := imageTypes[contentType]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedMediaType, contentType)
+	}
+
+	return contentType, nil
+}
+
+// UploadImages saves uploaded files into the unprocessed folder, indexes them and returns their images.
+// All files are validated before the first one is written, and if a file can't be saved or indexed, then the
+// files saved so far are removed again (see removeUploads()), so that either all or no files are saved.
+// Files are written to a temporary file first and then linked to their final name, thus a
+// file in the unprocessed folder is never incomplete. Names that are taken in the unprocessed or
+// processed folder get a numeric suffix.
+func (c *Controller) UploadImages(files []*multipart.FileHeader) ([]model.Image, error) {
+	contentTypes := make([]string, len(files))
+
+	for k, header := range files {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		contentTypes[k], err = DetectImageType(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", header.Filename, err)
+		}
+	}
+
+	images := []model.Image{}
+	saved := []string{}
+
+	for k, header := range files {
+		file, err := header.Open()
+		if err != nil {
+			c.removeUploads(saved)
+			return nil, err
+		}
+		name, err := saveUpload(uploadName(header.Filename, contentTypes[k]), file)
+		file.Close()
+		if err != nil {
+			c.removeUploads(saved)
+			return nil, err
+		}
+		saved = append(saved, filepath.Join(config.Get().UnprocessedImagesFolder, name))
+
+		image, _, err := c.IndexFile(saved[len(saved)-1])
+		if err != nil {
+			c.removeUploads(saved)
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+
+	return images, nil
+}
+
+// removeUploads removes the saved files of a failed upload and their images.
+// Files that can't be removed are logged, they are indexed as unprocessed images.
+func (c *Controller) removeUploads(files []string) {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(config.Get().Images, file)); err != nil && !os.IsNotExist(err) {
+			logger.Logger().Warnw("Unable to remove the file of a failed upload.", "file", file, "error", err)
+			continue
+		}
+		if _, err := c.RemoveFile(file); err != nil {
+			logger.Logger().Warnw("Unable to remove the image of a failed upload.", "file", file, "error", err)
+		}
+	}
+}
+
+// uploadName sanitizes the name of an uploaded file and makes sure it has an image extension.
+func uploadName(filename string, contentType string) string {
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(filename, "\\", "/")))
+	name = strings.TrimLeft(name, ".")
+	if name == "" || name == "/" {
+		name = "upload"
+	}
+
+	if filepath.Ext(name) == "" {
+		name += imageTypes[contentType]
+	}
+
+	return name
+}
+
+// saveUpload writes the content atomically into the unprocessed folder and returns the final file name.
+func saveUpload(name string, content io.Reader) (string, error) {
+	root := config.Get().Images
+	imageDir := filepath.Join(root, config.Get().UnprocessedImagesFolder)
+
+	// Create the unprocessed image directory if it does not exist
+	if err := os.MkdirAll(imageDir, 0755); err != nil {
+		return "", err
+	}
+
+	// The temporary file is created outside of the unprocessed folder to hide it from the folder walk.
+	tmp, err := ioutil.TempFile(root, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, content)
+	if syncErr := tmp.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+
+		if _, err := os.Stat(filepath.Join(root, config.Get().ProcessedImagesFolder, candidate)); err == nil {
+			continue
+		}
+
+		// Link fails if the target exists, which makes claiming the name atomic.
+		if err := os.Link(tmp.Name(), filepath.Join(imageDir, candidate)); err == nil {
+			return candidate, nil
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
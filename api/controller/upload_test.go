@@ -0,0 +1,163 @@
+package controller_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+// createMultipartFiles encodes the given files as a multipart form and parses them again.
+func createMultipartFiles(t *testing.T, files map[string][]byte) []*multipart.FileHeader {
+	var body bytes.Buffer
+
+	writer := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal("Unable to create the multipart file.", err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal("Unable to parse the multipart form.", err)
+	}
+
+	return form.File["files"]
+}
+
+func TestUploadImages(t *testing.T) {
+	var pngImage bytes.Buffer
+
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	db := memory.NewStore()
+	ctrl := controller.New(store.Store{Images: db, Categories: db})
+	unprocessedImages := filepath.Join(configuration.Images, configuration.UnprocessedImagesFolder)
+
+	if err := png.Encode(&pngImage, image.NewNRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+	if err := os.MkdirAll(unprocessedImages, 0755); err != nil {
+		t.Fatal("Unable to create the unprocessed image folder.", err)
+	}
+	if err := testutil.TouchFile(filepath.Join(unprocessedImages, "test.png")); err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+
+	images, err := ctrl.UploadImages(createMultipartFiles(t, map[string][]byte{
+		"../../test.png": pngImage.Bytes(),
+	}))
+	expected := []model.Image{{
+		File:               filepath.Join(configuration.UnprocessedImagesFolder, "test-1.png"),
+		AssignedCategories: []string{},
+		ProposedCategories: []model.Proposal{},
+		Hash:               fmt.Sprintf("%x", sha256.Sum256(pngImage.Bytes())),
+		PerceptualHash:     "0000000000000000",
+		Unprocessed:        true,
+		Metadata:           &model.Metadata{Width: 10, Height: 10},
+	}}
+
+	if err != nil || !reflect.DeepEqual(images, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the image to be saved under a free name.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      images,
+			})
+		t.Fatalf(format, args...)
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(configuration.Images, images[0].File)); err != nil ||
+		!bytes.Equal(content, pngImage.Bytes()) {
+		t.Error("Expected the uploaded content to be written.", err)
+	}
+
+	if image, err := db.GetImage(expected[0].File); err != nil || !reflect.DeepEqual(*image, expected[0]) {
+		t.Error("Expected the uploaded image to be indexed.", err)
+	}
+
+	files, _ := ioutil.ReadDir(configuration.Images)
+	if len(files) != 1 {
+		t.Error("Expected temporary files to be removed.", files)
+	}
+
+	_, err = ctrl.UploadImages(createMultipartFiles(t, map[string][]byte{
+		"script.png": []byte("#!/bin/sh\necho no image"),
+	}))
+	if !errors.Is(err, controller.ErrUnsupportedMediaType) {
+		format, args := testutil.FormatTestError(
+			"Expected files that aren't images to be rejected.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+// limitedStore stores a limited number of images, further writes fail.
+type limitedStore struct {
+	*memory.Store
+	remaining *int
+}
+
+func (s limitedStore) UpsertImage(image model.Image) error {
+	if *s.remaining == 0 {
+		return errStoreFailed
+	}
+	*s.remaining--
+	return s.Store.UpsertImage(image)
+}
+
+func TestUploadImagesFailure(t *testing.T) {
+	var pngImage bytes.Buffer
+
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	if err := png.Encode(&pngImage, image.NewNRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+
+	db := memory.NewStore()
+	remaining := 1
+	ctrl := controller.New(store.Store{Images: limitedStore{db, &remaining}, Categories: db})
+
+	images, err := ctrl.UploadImages(createMultipartFiles(t, map[string][]byte{
+		"a.png": pngImage.Bytes(),
+		"b.png": pngImage.Bytes(),
+	}))
+	if !errors.Is(err, errStoreFailed) || images != nil {
+		t.Errorf("Expected the upload to fail, got %v (%v).", images, err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(configuration.Images, configuration.UnprocessedImagesFolder))
+	stored, _ := db.AllImages()
+	if len(files) != 0 || len(stored) != 0 {
+		format, args := testutil.FormatTestError(
+			"Expected the files saved before the failure to be removed with their images.",
+			map[string]interface{}{
+				"files":  files,
+				"images": stored,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -3,8 +3,10 @@ module tagallery.com/api
 go 1.15
 
 require (
-	github.com/gin-gonic/gin v1.6.3
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/gin-gonic/gin v1.8.1
 	github.com/pkg/errors v0.9.1
+	go.etcd.io/bbolt v1.3.5
 	go.mongodb.org/mongo-driver v1.4.4
 	go.uber.org/zap v1.16.0
 )
@@ -19,22 +19,22 @@ import (
 var processedImageFixtures = []model.Image{
 	{File: "kQqIEGzwy6.jpg"},
 	{File: "QLRLhnOrzA.jpg", AssignedCategories: []string{"Category 1", "Category 2"}},
-	{File: "P0JDfeSr1v.jpg", ProposedCategories: []string{"Category 2"}},
+	{File: "P0JDfeSr1v.jpg", ProposedCategories: []model.Proposal{{Category: "Category 2"}}},
 	{File: "gH3sY4B2Wj.png", StarredCategory: util.StringPtr("Category 1")},
 	{File: "kl7RdVTAhp.jpg"},
-	{File: "SRymDEkNbW.jpg", AssignedCategories: []string{"Category 2"}, ProposedCategories: []string{"Category 3"}},
-	{File: "HnIPvWuPsI.jpg", ProposedCategories: []string{"Category 1", "Category 3"}},
+	{File: "SRymDEkNbW.jpg", AssignedCategories: []string{"Category 2"}, ProposedCategories: []model.Proposal{{Category: "Category 3"}}},
+	{File: "HnIPvWuPsI.jpg", ProposedCategories: []model.Proposal{{Category: "Category 1"}, {Category: "Category 3"}}},
 	{File: "f.jpg"},
 	{File: "obDrDA17Q9.jpg"},
 	{File: "DiKkLH5kmf.jpg", AssignedCategories: []string{"Category 3"}},
-	{File: "Je1p4msN6N.jpg", ProposedCategories: []string{"Category 2"}},
+	{File: "Je1p4msN6N.jpg", ProposedCategories: []model.Proposal{{Category: "Category 2"}}},
 	{File: "8fNNuCssYi.png", StarredCategory: util.StringPtr("Category 1")},
 	{File: "sHJODFlvHI.jpg", AssignedCategories: []string{"Category 1"}},
-	{File: "MF3onraKKV.png", AssignedCategories: []string{"Category 2"}, ProposedCategories: []string{"Category 1", "Category 3"}},
-	{File: "O2hCNHYgYp.jpg", ProposedCategories: []string{"Category 1"}, StarredCategory: util.StringPtr("Category 2")},
+	{File: "MF3onraKKV.png", AssignedCategories: []string{"Category 2"}, ProposedCategories: []model.Proposal{{Category: "Category 1"}, {Category: "Category 3"}}},
+	{File: "O2hCNHYgYp.jpg", ProposedCategories: []model.Proposal{{Category: "Category 1"}}, StarredCategory: util.StringPtr("Category 2")},
 	{File: "N3Ynmj4GWt.jpg"},
 	{File: "k0sCGdUC1F.jpg", StarredCategory: util.StringPtr("Category 2")},
-	{File: "GB3kVzAvfq", AssignedCategories: []string{"Category 2"}, ProposedCategories: []string{"Category 1", "Category 2"}},
+	{File: "GB3kVzAvfq", AssignedCategories: []string{"Category 2"}, ProposedCategories: []model.Proposal{{Category: "Category 1"}, {Category: "Category 2"}}},
 	{File: "cUanJL8LAs.jpg", StarredCategory: util.StringPtr("Category 2")},
 	{File: "4jZUXo1cuG.jpg"},
 }
@@ -346,7 +346,10 @@ func GetImages(t *testing.T) {
 		expected = append(expected, model.Image{
 			File:               unprocessedImages[i],
 			AssignedCategories: []string{},
-			ProposedCategories: []string{},
+			ProposedCategories: []model.Proposal{},
+			Hash:               testutil.EmptyFileHash,
+			Unprocessed:        true,
+			ExtractionFailed:   true,
 		})
 	}
 	if err := GetRequest(apiURL("/image?status=unprocessed"), &images); err != nil {
@@ -372,7 +375,10 @@ func GetImages(t *testing.T) {
 		expected = append(expected, model.Image{
 			File:               unprocessedImages[i],
 			AssignedCategories: []string{},
-			ProposedCategories: []string{},
+			ProposedCategories: []model.Proposal{},
+			Hash:               testutil.EmptyFileHash,
+			Unprocessed:        true,
+			ExtractionFailed:   true,
 		})
 	}
 	if err := GetRequest(apiURL(fmt.Sprintf(
@@ -401,7 +407,10 @@ func GetImages(t *testing.T) {
 		expected = append(expected, model.Image{
 			File:               unprocessedImages[i],
 			AssignedCategories: []string{},
-			ProposedCategories: []string{},
+			ProposedCategories: []model.Proposal{},
+			Hash:               testutil.EmptyFileHash,
+			Unprocessed:        true,
+			ExtractionFailed:   true,
 		})
 	}
 	if err := GetRequest(apiURL(fmt.Sprintf(
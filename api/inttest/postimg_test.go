@@ -38,14 +38,15 @@ func PostImage(t *testing.T) {
 	var image = model.Image{
 		File:               filepath.Join(config.Get().UnprocessedImagesFolder, "test.jpg"),
 		AssignedCategories: []string{},
-		ProposedCategories: []string{"Category 1"},
+		ProposedCategories: []model.Proposal{{Category: "Category 1"}},
 		StarredCategory:    util.StringPtr("Category 2"),
 	}
 	var updatedImage = model.Image{
 		File:               filepath.Join(config.Get().ProcessedImagesFolder, "test.jpg"),
 		AssignedCategories: []string{"Category 2"},
-		ProposedCategories: []string{"Category 1"},
+		ProposedCategories: []model.Proposal{{Category: "Category 1"}},
 		StarredCategory:    util.StringPtr("Category 2"),
+		Hash:               testutil.EmptyFileHash,
 	}
 	var response model.Image
 
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"fmt"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+)
+
+// QueryCategories returns all categories.
+func (s *Store) QueryCategories() ([]model.Category, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	categories := make([]model.Category, len(s.categories))
+	for k, v := range s.categories {
+		categories[k] = copyCategory(v)
+	}
+
+	return categories, nil
+}
+
+// UpsertCategory inserts a category or updates it.
+// If the provided category has a valid id, then the entire category is updated.
+// If the id is missing, then the name is taken as an identifier and everything else is updated.
+// You can also provide a valid id {category.id} for a new category.
+// The names and aliases are compared case insensitive and must be unique across all categories.
+// The parent must exist and must not create a cycle.
+func (s *Store) UpsertCategory(category model.Category) (*model.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := -1
+
+	if category.ID != nil && len(*category.ID) > 0 {
+		if !store.ValidID(*category.ID) {
+			return nil, fmt.Errorf("%w: %s", store.ErrInvalidID, *category.ID)
+		}
+		for k, v := range s.categories {
+			if *v.ID == *category.ID {
+				index = k
+				break
+			}
+		}
+	} else {
+		// Like a MongoDB filter without collation, the name lookup is case sensitive.
+		for k, v := range s.categories {
+			if v.Name == category.Name {
+				index = k
+				break
+			}
+		}
+	}
+
+	id := ""
+	if index >= 0 {
+		id = *s.categories[index].ID
+	} else if category.ID != nil {
+		id = *category.ID
+	}
+
+	store.NormalizeAliases(&category)
+	if err := store.CheckNames(s.categories, id, category); err != nil {
+		return nil, err
+	}
+	if category.ParentID != nil && *category.ParentID == "" {
+		category.ParentID = nil
+	}
+	if err := store.CheckParent(s.categories, id, category.ParentID); err != nil {
+		return nil, err
+	}
+
+	if index >= 0 {
+		category.ID = s.categories[index].ID
+		s.categories[index] = copyCategory(category)
+		category.ID = nil
+		return &category, nil
+	}
+
+	if category.ID == nil || len(*category.ID) == 0 {
+		id := store.NewID()
+		category.ID = &id
+	}
+	s.categories = append(s.categories, copyCategory(category))
+
+	return &category, nil
+}
+
+// DeleteCategory deletes a category.
+func (s *Store) DeleteCategory(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !store.ValidID(id) {
+		return fmt.Errorf("%w: %s", store.ErrInvalidID, id)
+	}
+
+	for k, v := range s.categories {
+		if *v.ID == id {
+			s.categories = append(s.categories[:k], s.categories[k+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// copyCategory returns a copy of a category so that callers can't modify the stored one.
+func copyCategory(category model.Category) model.Category {
+	if category.ID != nil {
+		id := *category.ID
+		category.ID = &id
+	}
+	if category.ParentID != nil {
+		parentID := *category.ParentID
+		category.ParentID = &parentID
+	}
+	if category.Aliases != nil {
+		category.Aliases = append([]string{}, category.Aliases...)
+	}
+
+	return category
+}
@@ -0,0 +1,164 @@
+package memory
+
+import (
+	"strconv"
+
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+)
+
+// GetImages queries the store for images. See store.ImageStore for the filter semantics.
+func (s *Store) GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	images := store.FilterImages(s.images, opts, categories)
+	for k, image := range images {
+		images[k] = copyImage(image)
+	}
+
+	return images, nil
+}
+
+// UpsertImage inserts or updates an existing image.
+// Updated images keep their position in the store, inserted ones are appended.
+func (s *Store) UpsertImage(image model.Image) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.images {
+		if v.File == image.File {
+			s.images[k] = copyImage(image)
+			return nil
+		}
+	}
+
+	s.seq++
+	s.images = append(s.images, copyImage(image))
+	s.ids = append(s.ids, s.seq)
+
+	return nil
+}
+
+// UpdateImage atomically applies {update} to the image of a file.
+func (s *Store) UpdateImage(file string, update func(image *model.Image) error) (*model.Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.images {
+		if v.File == file {
+			image := copyImage(v)
+			if err := update(&image); err != nil {
+				return nil, err
+			}
+			image.File = file
+			s.images[k] = copyImage(image)
+			return &image, nil
+		}
+	}
+
+	return nil, store.ErrNotFound
+}
+
+// GetUnprocessedImages returns the unprocessed images after a cursor or the last image.
+func (s *Store) GetUnprocessedImages(opts model.ImageOptions) ([]model.Image, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var after uint64
+	if opts.Cursor != nil {
+		id, err := strconv.ParseUint(*opts.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		after = id
+	} else if opts.LastImage != nil {
+		found := false
+		for k, image := range s.images {
+			if image.File == *opts.LastImage {
+				after = s.ids[k]
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			logger.Logger().Warnw("Unable to find lastImage in the store.", "lastImage", *opts.LastImage)
+		}
+	}
+
+	images := []model.Image{}
+	cursor := ""
+	for k, image := range s.images {
+		if !image.Unprocessed || s.ids[k] <= after || !store.MatchMetadata(image, opts) {
+			continue
+		}
+		if opts.Count != nil && *opts.Count > 0 && len(images) >= *opts.Count {
+			return images, cursor, nil
+		}
+		images = append(images, copyImage(image))
+		cursor = strconv.FormatUint(s.ids[k], 10)
+	}
+
+	return images, "", nil
+}
+
+// AllImages returns all images, including the unprocessed ones.
+func (s *Store) AllImages() ([]model.Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	images := make([]model.Image, len(s.images))
+	for k, image := range s.images {
+		images[k] = copyImage(image)
+	}
+
+	return images, nil
+}
+
+// GetImage returns the image of a file.
+func (s *Store) GetImage(file string) (*model.Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, image := range s.images {
+		if image.File == file {
+			image = copyImage(image)
+			return &image, nil
+		}
+	}
+
+	return nil, store.ErrNotFound
+}
+
+// GetImagesByHash returns all images with the given content hash.
+func (s *Store) GetImagesByHash(hash string) ([]model.Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	images := []model.Image{}
+	for _, image := range s.images {
+		if image.Hash == hash {
+			images = append(images, copyImage(image))
+		}
+	}
+
+	return images, nil
+}
+
+// DeleteImage deletes the image of a file.
+func (s *Store) DeleteImage(file string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, image := range s.images {
+		if image.File == file {
+			s.images = append(s.images[:k], s.images[k+1:]...)
+			s.ids = append(s.ids[:k], s.ids[k+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package memory implements the image and category store in memory.
+// Nothing is persisted, which makes the store useful for tests and demos.
+package memory
+
+import (
+	"sync"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+)
+
+// Store holds images and categories in memory.
+// It mimics the behaviour of the MongoDB store, including the case insensitive unique category names.
+// Every image has a sequence number in {ids}, which serves as the cursor of its position.
+type Store struct {
+	mu         sync.RWMutex
+	images     []model.Image
+	ids        []uint64
+	seq        uint64
+	categories []model.Category
+}
+
+var _ store.ImageStore = (*Store)(nil)
+var _ store.CategoryStore = (*Store)(nil)
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{}
+}
+
+// copyImage returns a deep copy of an image so that callers can't modify the stored one.
+func copyImage(image model.Image) model.Image {
+	if image.AssignedCategories != nil {
+		image.AssignedCategories = append([]string{}, image.AssignedCategories...)
+	}
+	if image.ProposedCategories != nil {
+		image.ProposedCategories = append([]model.Proposal{}, image.ProposedCategories...)
+	}
+	if image.RejectedCategories != nil {
+		image.RejectedCategories = append([]string{}, image.RejectedCategories...)
+	}
+	if image.StarredCategory != nil {
+		starred := *image.StarredCategory
+		image.StarredCategory = &starred
+	}
+	if image.Metadata != nil {
+		metadata := *image.Metadata
+		if metadata.GPS != nil {
+			gps := *metadata.GPS
+			metadata.GPS = &gps
+		}
+		image.Metadata = &metadata
+	}
+
+	return image
+}
@@ -0,0 +1,21 @@
+package memory_test
+
+import (
+	"testing"
+
+	"tagallery.com/api/logger"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+func init() {
+	logger.Setup(true)
+}
+
+func TestStore(t *testing.T) {
+	testutil.RunStoreTests(t, func(t *testing.T) store.Store {
+		db := memory.NewStore()
+		return store.Store{Images: db, Categories: db}
+	})
+}
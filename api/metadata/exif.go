@@ -0,0 +1,331 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"tagallery.com/api/model"
+)
+
+// errInvalidTIFF indicates TIFF data, e. g. of an EXIF segment, that can't be parsed.
+var errInvalidTIFF = errors.New("invalid TIFF data")
+
+// maxEntries limits the number of entries of an IFD, which guards against corrupt files.
+const maxEntries = 1000
+
+// TIFF tags of the IFD0, the EXIF IFD and the GPS IFD.
+const (
+	tagImageWidth         = 0x0100
+	tagImageLength        = 0x0101
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagXMP                = 0x02BC
+	tagIPTC               = 0x83BB
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagPixelXDimension    = 0xA002
+	tagPixelYDimension    = 0xA003
+	tagLensModel          = 0xA434
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+	tagGPSAltitudeRef     = 0x0005
+	tagGPSAltitude        = 0x0006
+)
+
+// TIFF field types.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+// typeSizes are the sizes in bytes of a single value of the TIFF field types.
+var typeSizes = map[uint16]int64{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSLong:     4,
+	typeSRational: 8,
+}
+
+// tiff reads the IFDs of TIFF data, which is the format of EXIF segments as well.
+type tiff struct {
+	r     io.ReaderAt
+	size  int64
+	order binary.ByteOrder
+}
+
+// entry is a field of an IFD with its raw value.
+type entry struct {
+	typ   uint16
+	count int64
+	value []byte
+}
+
+// newTIFF reads the header of TIFF data and returns the offset of the first IFD.
+func newTIFF(r io.ReaderAt, size int64) (*tiff, int64, error) {
+	header := make([]byte, 8)
+	if size < 8 {
+		return nil, 0, errInvalidTIFF
+	}
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, 0, err
+	}
+
+	t := &tiff{r: r, size: size}
+	switch {
+	case bytes.Equal(header[:4], []byte("II*\x00")):
+		t.order = binary.LittleEndian
+	case bytes.Equal(header[:4], []byte("MM\x00*")):
+		t.order = binary.BigEndian
+	default:
+		return nil, 0, errInvalidTIFF
+	}
+
+	return t, int64(t.order.Uint32(header[4:])), nil
+}
+
+// read reads {n} bytes at {offset}, which have to be inside the TIFF data.
+func (t *tiff) read(offset int64, n int64) ([]byte, error) {
+	if offset < 0 || n < 0 || n > maxSegment || offset+n > t.size {
+		return nil, errInvalidTIFF
+	}
+
+	data := make([]byte, n)
+	if _, err := t.r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ifd reads the entries of the IFD at {offset}. Entries of unknown types are skipped.
+func (t *tiff) ifd(offset int64) (map[uint16]entry, error) {
+	header, err := t.read(offset, 2)
+	if err != nil {
+		return nil, err
+	}
+	count := int64(t.order.Uint16(header))
+	if count > maxEntries {
+		return nil, errInvalidTIFF
+	}
+
+	data, err := t.read(offset+2, count*12)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[uint16]entry{}
+	for k := int64(0); k < count; k++ {
+		field := data[k*12 : (k+1)*12]
+		typ := t.order.Uint16(field[2:])
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+
+		e := entry{typ: typ, count: int64(t.order.Uint32(field[4:]))}
+		if length := size * e.count; length <= 4 {
+			e.value = field[8 : 8+length]
+		} else if e.value, err = t.read(int64(t.order.Uint32(field[8:])), length); err != nil {
+			// A corrupt field doesn't make the other ones unreadable.
+			continue
+		}
+		entries[t.order.Uint16(field)] = e
+	}
+
+	return entries, nil
+}
+
+// string returns the value of an ASCII field without its trailing NUL and whitespace.
+func (e entry) string() string {
+	if e.typ != typeASCII && e.typ != typeUndefined {
+		return ""
+	}
+	if i := bytes.IndexByte(e.value, 0); i >= 0 {
+		return strings.TrimSpace(string(e.value[:i]))
+	}
+	return strings.TrimSpace(string(e.value))
+}
+
+// uint returns the first value of a BYTE, SHORT or LONG field.
+func (e entry) uint(order binary.ByteOrder) (int64, bool) {
+	switch {
+	case e.count < 1:
+		return 0, false
+	case e.typ == typeByte:
+		return int64(e.value[0]), true
+	case e.typ == typeShort:
+		return int64(order.Uint16(e.value)), true
+	case e.typ == typeLong:
+		return int64(order.Uint32(e.value)), true
+	default:
+		return 0, false
+	}
+}
+
+// rationals returns the values of a RATIONAL or SRATIONAL field. Values with a zero denominator are 0.
+func (e entry) rationals(order binary.ByteOrder) []float64 {
+	if e.typ != typeRational && e.typ != typeSRational {
+		return nil
+	}
+
+	values := make([]float64, e.count)
+	for k := range values {
+		numerator, denominator := order.Uint32(e.value[k*8:]), order.Uint32(e.value[k*8+4:])
+		if denominator == 0 {
+			continue
+		}
+		if e.typ == typeSRational {
+			values[k] = float64(int32(numerator)) / float64(int32(denominator))
+		} else {
+			values[k] = float64(numerator) / float64(denominator)
+		}
+	}
+	return values
+}
+
+// exifData are the fields of the EXIF data that make up the metadata,
+// and the XMP packet and the IPTC data embedded into TIFF files.
+type exifData struct {
+	metadata model.Metadata
+	xmp      []byte
+	iptc     []byte
+}
+
+// parseEXIF reads the metadata of TIFF data, e. g. an EXIF segment.
+func parseEXIF(r io.ReaderAt, size int64) (*exifData, error) {
+	t, offset, err := newTIFF(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	ifd0, err := t.ifd(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &exifData{}
+	m := &data.metadata
+	m.Make = ifd0[tagMake].string()
+	m.Model = ifd0[tagModel].string()
+	if orientation, ok := ifd0[tagOrientation].uint(t.order); ok && orientation >= 1 && orientation <= 8 {
+		m.Orientation = int(orientation)
+	}
+	if width, ok := ifd0[tagImageWidth].uint(t.order); ok {
+		m.Width = int(width)
+	}
+	if height, ok := ifd0[tagImageLength].uint(t.order); ok {
+		m.Height = int(height)
+	}
+	if xmp, ok := ifd0[tagXMP]; ok {
+		data.xmp = xmp.value
+	}
+	if iptc, ok := ifd0[tagIPTC]; ok {
+		data.iptc = iptc.value
+	}
+	m.TakenAt = parseEXIFTime(ifd0[tagDateTime].string(), "")
+
+	if offset, ok := ifd0[tagExifIFD].uint(t.order); ok {
+		if exif, err := t.ifd(offset); err == nil {
+			if taken := parseEXIFTime(exif[tagDateTimeOriginal].string(), exif[tagOffsetTimeOriginal].string()); taken != nil {
+				m.TakenAt = taken
+			}
+			if width, ok := exif[tagPixelXDimension].uint(t.order); ok && width > 0 {
+				m.Width = int(width)
+			}
+			if height, ok := exif[tagPixelYDimension].uint(t.order); ok && height > 0 {
+				m.Height = int(height)
+			}
+			m.Lens = exif[tagLensModel].string()
+		}
+	}
+
+	if offset, ok := ifd0[tagGPSIFD].uint(t.order); ok {
+		if gps, err := t.ifd(offset); err == nil {
+			m.GPS = parseGPS(gps, t.order)
+		}
+	}
+
+	return data, nil
+}
+
+// parseEXIFTime parses an EXIF date and time with an optional offset like "+02:00".
+// Times without an offset are taken as UTC. Invalid times, e. g. "0000:00:00 00:00:00", are nil.
+func parseEXIFTime(value string, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	location := time.UTC
+	if zone, err := time.Parse("-07:00", offset); err == nil {
+		location = zone.Location()
+	}
+
+	taken, err := time.ParseInLocation("2006:01:02 15:04:05", value, location)
+	if err != nil {
+		return nil
+	}
+
+	taken = taken.UTC()
+	return &taken
+}
+
+// parseGPS reads the location of the GPS IFD. It returns nil if the latitude or longitude is missing.
+func parseGPS(gps map[uint16]entry, order binary.ByteOrder) *model.GPS {
+	latitude := degrees(gps[tagGPSLatitude].rationals(order))
+	longitude := degrees(gps[tagGPSLongitude].rationals(order))
+	if latitude == nil || longitude == nil {
+		return nil
+	}
+
+	location := &model.GPS{Latitude: *latitude, Longitude: *longitude}
+	if gps[tagGPSLatitudeRef].string() == "S" {
+		location.Latitude = -location.Latitude
+	}
+	if gps[tagGPSLongitudeRef].string() == "W" {
+		location.Longitude = -location.Longitude
+	}
+
+	if altitude := gps[tagGPSAltitude].rationals(order); len(altitude) > 0 {
+		// The reference 1 means below sea level.
+		if ref, ok := gps[tagGPSAltitudeRef].uint(order); ok && ref == 1 {
+			altitude[0] = -altitude[0]
+		}
+		location.Altitude = &altitude[0]
+	}
+
+	return location
+}
+
+// degrees converts degrees, minutes and seconds to decimal degrees.
+func degrees(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+
+	result := 0.0
+	for k, divisor := range []float64{1, 60, 3600} {
+		if k < len(values) {
+			result += values[k] / divisor
+		}
+	}
+	return &result
+}
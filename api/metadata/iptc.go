@@ -0,0 +1,359 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+// errInvalidIPTC indicates a Photoshop segment or IPTC data that can't be parsed.
+var errInvalidIPTC = errors.New("invalid IPTC data")
+
+// errDatasetTooLarge indicates an IPTC dataset that needs an extended size, which isn't supported.
+var errDatasetTooLarge = errors.New("the IPTC dataset is too large")
+
+// errSegmentTooLarge indicates IPTC data that doesn't fit into a single JPEG segment.
+var errSegmentTooLarge = errors.New("the IPTC data is too large for a JPEG segment")
+
+var (
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+	utf8Charset     = []byte("\x1b%G")
+)
+
+// resourceIPTC is the ID of the Photoshop image resource that holds the IPTC data.
+const resourceIPTC = 0x0404
+
+// IPTC records and datasets.
+const (
+	recordEnvelope       = 1
+	recordApplication    = 2
+	datasetCharset       = 90
+	datasetRecordVersion = 0
+	datasetKeywords      = 25
+	maxKeywordLength     = 64
+)
+
+// resource is a Photoshop image resource of an APP13 segment.
+// The name is kept raw, as a padded Pascal string.
+type resource struct {
+	signature []byte
+	id        uint16
+	name      []byte
+	data      []byte
+}
+
+// dataset is an IPTC dataset, e. g. a keyword (2:25).
+type dataset struct {
+	record byte
+	number byte
+	value  []byte
+}
+
+// WriteIPTCKeywords replaces the IPTC keywords of a JPEG file, the other IPTC datasets and Photoshop resources
+// are kept. The keywords are written in UTF-8, other text datasets of IPTC data without declared character set
+// are transcoded from ISO 8859-1 if they aren't UTF-8. The file isn't touched if its keywords don't change.
+// Formats other than JPEG and IPTC data that declares another character set result in ErrUnsupported, the
+// other datasets would be decoded in the wrong character set otherwise, as do keywords longer than 64 bytes.
+func WriteIPTCKeywords(path string, keywords []string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return ErrUnsupported
+	}
+
+	start, end, insert, err := findPhotoshopSegment(data)
+	if err != nil {
+		return err
+	}
+
+	var resources []resource
+	var datasets []dataset
+	if start >= 0 {
+		if resources, err = parseResources(data[start+4+len(photoshopHeader) : end]); err != nil {
+			return err
+		}
+		for _, r := range resources {
+			if r.id == resourceIPTC {
+				if datasets, err = parseIPTC(r.data); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	} else if len(keywords) == 0 {
+		return nil
+	}
+
+	if equalKeywords(iptcKeywords(datasets), keywords) {
+		return nil
+	}
+	if charset := iptcCharset(datasets); charset != nil && !bytes.Equal(charset, utf8Charset) {
+		return fmt.Errorf("%w: the IPTC data declares the character set %q instead of UTF-8", ErrUnsupported, charset)
+	}
+	for _, keyword := range keywords {
+		if len(keyword) > maxKeywordLength {
+			return fmt.Errorf("%w: the keyword %q is longer than the %d bytes of an IPTC keyword", ErrUnsupported, keyword, maxKeywordLength)
+		}
+	}
+
+	encoded, err := encodeIPTC(withKeywords(datasets, keywords))
+	if err != nil {
+		return err
+	}
+	iptc := resource{signature: []byte("8BIM"), id: resourceIPTC, name: []byte{0, 0}, data: encoded}
+	replaced := false
+	for k, r := range resources {
+		if r.id == resourceIPTC {
+			resources[k].data, replaced = iptc.data, true
+			break
+		}
+	}
+	if !replaced {
+		resources = append(resources, iptc)
+	}
+
+	payload := append(append([]byte{}, photoshopHeader...), encodeResources(resources)...)
+	if len(payload)+2 > 0xFFFF {
+		return errSegmentTooLarge
+	}
+	segment := []byte{0xFF, 0xED, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	var result []byte
+	if start >= 0 {
+		result = append(append(append(result, data[:start]...), segment...), data[end:]...)
+	} else {
+		result = append(append(append(result, data[:insert]...), segment...), data[insert:]...)
+	}
+
+	return writeFile(path, result)
+}
+
+// findPhotoshopSegment returns the start and end of the first Photoshop APP13 segment of a JPEG file,
+// or -1 if there is none. A new segment is inserted after the leading APPn segments, e. g. JFIF and EXIF.
+func findPhotoshopSegment(data []byte) (int, int, int, error) {
+	insert, leading := 2, true
+
+	for offset := 2; offset+4 <= len(data); {
+		if data[offset] != 0xFF {
+			return -1, -1, 0, errInvalidIPTC
+		}
+
+		switch code := data[offset+1]; {
+		case code == 0xFF:
+			offset++
+			continue
+		case code == 0x01 || code == 0xD8 || (code >= 0xD0 && code <= 0xD7):
+			offset += 2
+			continue
+		case code == 0xDA || code == 0xD9:
+			return -1, -1, insert, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return -1, -1, 0, errInvalidIPTC
+		}
+
+		code := data[offset+1]
+		if code == 0xED && bytes.HasPrefix(data[offset+4:end], photoshopHeader) {
+			return offset, end, insert, nil
+		}
+		if leading = leading && code >= 0xE0 && code <= 0xEF; leading {
+			insert = end
+		}
+
+		offset = end
+	}
+
+	return -1, -1, insert, nil
+}
+
+// parseResources parses the Photoshop image resources of an APP13 segment.
+func parseResources(data []byte) ([]resource, error) {
+	resources := []resource{}
+
+	for offset := 0; offset < len(data); {
+		if offset+7 > len(data) {
+			return nil, errInvalidIPTC
+		}
+
+		r := resource{signature: data[offset : offset+4], id: binary.BigEndian.Uint16(data[offset+4:])}
+		// The name is a Pascal string padded to an even size.
+		nameSize := 1 + int(data[offset+6])
+		nameSize += nameSize % 2
+		if offset+6+nameSize+4 > len(data) {
+			return nil, errInvalidIPTC
+		}
+		r.name = data[offset+6 : offset+6+nameSize]
+		offset += 6 + nameSize
+
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += 4
+		if size < 0 || offset+size > len(data) {
+			return nil, errInvalidIPTC
+		}
+		r.data = data[offset : offset+size]
+		offset += size + size%2
+
+		resources = append(resources, r)
+	}
+
+	return resources, nil
+}
+
+// encodeResources encodes Photoshop image resources, the reverse of parseResources().
+func encodeResources(resources []resource) []byte {
+	var b bytes.Buffer
+
+	for _, r := range resources {
+		b.Write(r.signature)
+		_ = binary.Write(&b, binary.BigEndian, r.id)
+		b.Write(r.name)
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(r.data)))
+		b.Write(r.data)
+		if len(r.data)%2 == 1 {
+			b.WriteByte(0)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// parseIPTC parses the datasets of IPTC data. Datasets with an extended size aren't supported.
+func parseIPTC(data []byte) ([]dataset, error) {
+	datasets := []dataset{}
+
+	for offset := 0; offset < len(data); {
+		if data[offset] != 0x1C {
+			// Photoshop pads the IPTC data.
+			if bytes.Count(data[offset:], []byte{0}) == len(data)-offset {
+				break
+			}
+			return nil, errInvalidIPTC
+		}
+		if offset+5 > len(data) {
+			return nil, errInvalidIPTC
+		}
+
+		size := int(binary.BigEndian.Uint16(data[offset+3:]))
+		if size&0x8000 != 0 || offset+5+size > len(data) {
+			return nil, errInvalidIPTC
+		}
+		datasets = append(datasets, dataset{record: data[offset+1], number: data[offset+2], value: data[offset+5 : offset+5+size]})
+		offset += 5 + size
+	}
+
+	return datasets, nil
+}
+
+// encodeIPTC encodes IPTC datasets, the reverse of parseIPTC(). Values that need an extended size result
+// in errDatasetTooLarge.
+func encodeIPTC(datasets []dataset) ([]byte, error) {
+	var b bytes.Buffer
+
+	for _, d := range datasets {
+		if len(d.value) > 0x7FFF {
+			return nil, fmt.Errorf("%w: %d:%d has %d bytes", errDatasetTooLarge, d.record, d.number, len(d.value))
+		}
+		b.Write([]byte{0x1C, d.record, d.number})
+		_ = binary.Write(&b, binary.BigEndian, uint16(len(d.value)))
+		b.Write(d.value)
+	}
+
+	return b.Bytes(), nil
+}
+
+// iptcKeywords returns the keywords of IPTC datasets.
+func iptcKeywords(datasets []dataset) []string {
+	keywords := []string{}
+	for _, d := range datasets {
+		if d.record == recordApplication && d.number == datasetKeywords {
+			keywords = append(keywords, string(d.value))
+		}
+	}
+	return keywords
+}
+
+// iptcCharset returns the declared character set of IPTC datasets, or nil if none is declared.
+func iptcCharset(datasets []dataset) []byte {
+	for _, d := range datasets {
+		if d.record == recordEnvelope && d.number == datasetCharset {
+			return d.value
+		}
+	}
+	return nil
+}
+
+// withKeywords returns the datasets with their keywords replaced. The records stay in order, the
+// application record starts with its version and the UTF-8 character set is declared if no character set is.
+// The text datasets are transcoded from ISO 8859-1 to UTF-8 then if they aren't valid UTF-8, as decodeIPTC()
+// reads them, see textDataset(). Datasets of another declared character set are refused
+// by WriteIPTCKeywords() beforehand.
+func withKeywords(datasets []dataset, keywords []string) []dataset {
+	var envelope, application, others []dataset
+	version := dataset{record: recordApplication, number: datasetRecordVersion, value: []byte{0, 4}}
+	charset := iptcCharset(datasets) != nil
+
+	for _, d := range datasets {
+		if !charset && textDataset(d) && !utf8.Valid(d.value) {
+			d.value = []byte(latin1ToUTF8(d.value))
+		}
+
+		switch {
+		case d.record < recordApplication:
+			envelope = append(envelope, d)
+		case d.record > recordApplication:
+			others = append(others, d)
+		case d.number == datasetRecordVersion:
+			version = d
+		case d.number != datasetKeywords:
+			application = append(application, d)
+		}
+	}
+
+	if !charset {
+		envelope = append(envelope, dataset{record: recordEnvelope, number: datasetCharset, value: utf8Charset})
+	}
+
+	result := append(envelope, version)
+	result = append(result, application...)
+	for _, keyword := range keywords {
+		result = append(result, dataset{record: recordApplication, number: datasetKeywords, value: []byte(keyword)})
+	}
+	return append(result, others...)
+}
+
+// binaryDatasets are the datasets of the envelope and application record whose values aren't text, e. g. the
+// file format (1:20) or the preview data (2:202).
+var binaryDatasets = map[[2]byte]bool{
+	{recordEnvelope, 0}: true, {recordEnvelope, 20}: true, {recordEnvelope, 22}: true, {recordEnvelope, 120}: true,
+	{recordEnvelope, 122}: true, {recordApplication, datasetRecordVersion}: true, {recordApplication, 125}: true,
+	{recordApplication, 200}: true, {recordApplication, 201}: true, {recordApplication, 202}: true,
+}
+
+// textDataset reports whether the value of a dataset is text in the declared character set. Only the envelope
+// and application record have text datasets, the other records hold binary data.
+func textDataset(d dataset) bool {
+	return (d.record == recordEnvelope || d.record == recordApplication) && !binaryDatasets[[2]byte{d.record, d.number}]
+}
+
+// equalKeywords reports whether two lists of keywords are the same.
+func equalKeywords(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package metadata_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/testutil"
+)
+
+// iptcDataset creates an IPTC dataset.
+func iptcDataset(record byte, number byte, value string) []byte {
+	data := []byte{0x1C, record, number, 0, 0}
+	binary.BigEndian.PutUint16(data[3:], uint16(len(value)))
+	return append(data, value...)
+}
+
+// photoshopSegment creates an APP13 segment with a thumbnail resource and the IPTC resource.
+func photoshopSegment(iptc []byte) []byte {
+	resource := func(id uint16, data []byte) []byte {
+		header := []byte("8BIM\x00\x00\x00\x00\x00\x00\x00\x00")
+		binary.BigEndian.PutUint16(header[4:], id)
+		binary.BigEndian.PutUint32(header[8:], uint32(len(data)))
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+		return append(header, data...)
+	}
+
+	payload := append([]byte("Photoshop 3.0\x00"), resource(0x040C, []byte("thumb"))...)
+	return segment(0xED, append(payload, resource(0x0404, iptc)...))
+}
+
+// jpegFile creates a JPEG file with the given segments and fake image data.
+func jpegFile(segments ...[]byte) []byte {
+	data := append([]byte{0xFF, 0xD8}, bytes.Join(segments, nil)...)
+	return append(append(data, segment(0xDA, []byte{0, 0, 0})...), 0x12, 0x34, 0xFF, 0xD9)
+}
+
+func TestWriteIPTCKeywords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "beach.jpg")
+	jfif := segment(0xE0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))
+	exif := segment(0xE1, append([]byte("Exif\x00\x00"), cameraTIFF(binary.BigEndian)...))
+	caption := iptcDataset(2, 120, "A day at the beach")
+
+	tests := []struct {
+		desc      string
+		existing  []byte
+		keywords  []string
+		contains  [][]byte
+		excludes  [][]byte
+		unchanged bool
+	}{
+		{
+			desc:      "Expected a file without keywords to be kept.",
+			existing:  jpegFile(jfif, exif),
+			unchanged: true,
+		},
+		{
+			desc:     "Expected the keywords to be inserted after the leading segments.",
+			existing: jpegFile(jfif, exif),
+			keywords: []string{"Beach", "Sea"},
+			contains: [][]byte{
+				append(exif, 0xFF, 0xED),
+				iptcDataset(1, 90, "\x1b%G"),
+				bytes.Join([][]byte{iptcDataset(2, 0, "\x00\x04"), iptcDataset(2, 25, "Beach"), iptcDataset(2, 25, "Sea")}, nil),
+			},
+		},
+		{
+			desc:     "Expected the keywords to be replaced and the other datasets and resources to be kept.",
+			existing: jpegFile(jfif, exif, photoshopSegment(append(append(iptcDataset(2, 0, "\x00\x02"), iptcDataset(2, 25, "Old")...), caption...))),
+			keywords: []string{"Strand"},
+			contains: [][]byte{
+				[]byte("8BIM\x04\x0C"),
+				bytes.Join([][]byte{iptcDataset(2, 0, "\x00\x02"), caption, iptcDataset(2, 25, "Strand")}, nil),
+			},
+			excludes: [][]byte{[]byte("Old")},
+		},
+		{
+			desc:      "Expected a file with the same keywords to be kept.",
+			existing:  jpegFile(photoshopSegment(append(iptcDataset(2, 0, "\x00\x04"), iptcDataset(2, 25, "Sea")...))),
+			keywords:  []string{"Sea"},
+			unchanged: true,
+		},
+		{
+			desc:     "Expected undeclared ISO 8859-1 datasets to be transcoded to UTF-8 along with the declaration.",
+			existing: jpegFile(photoshopSegment(append(iptcDataset(2, 120, "Caf\xe9 am Strand"), iptcDataset(2, 25, "Old")...))),
+			keywords: []string{"Café"},
+			contains: [][]byte{
+				iptcDataset(1, 90, "\x1b%G"),
+				bytes.Join([][]byte{iptcDataset(2, 120, "Café am Strand"), iptcDataset(2, 25, "Café")}, nil),
+			},
+			excludes: [][]byte{[]byte("Caf\xe9")},
+		},
+		{
+			desc:     "Expected binary datasets to be kept when the other datasets are transcoded.",
+			existing: jpegFile(photoshopSegment(bytes.Join([][]byte{iptcDataset(1, 20, "\x00\xff"), iptcDataset(2, 200, "\x00\xc8"), iptcDataset(2, 120, "Caf\xe9")}, nil))),
+			keywords: []string{"Beach"},
+			contains: [][]byte{
+				iptcDataset(1, 20, "\x00\xff"),
+				iptcDataset(2, 200, "\x00\xc8"),
+				iptcDataset(2, 120, "Café"),
+			},
+		},
+		{
+			desc:     "Expected all keywords to be removed.",
+			existing: jpegFile(photoshopSegment(append(iptcDataset(2, 25, "Sea"), caption...))),
+			contains: [][]byte{caption},
+			excludes: [][]byte{[]byte("Sea")},
+		},
+	}
+
+	for _, test := range tests {
+		if err := ioutil.WriteFile(path, test.existing, 0644); err != nil {
+			t.Fatal("Unable to create the image.", err)
+		}
+
+		if err := metadata.WriteIPTCKeywords(path, test.keywords); err != nil {
+			t.Errorf("%s Got error %v.", test.desc, err)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal("Unable to read the image.", err)
+		}
+
+		failed := test.unchanged && !bytes.Equal(data, test.existing)
+		for _, expected := range test.contains {
+			failed = failed || !bytes.Contains(data, expected)
+		}
+		for _, unexpected := range test.excludes {
+			failed = failed || bytes.Contains(data, unexpected)
+		}
+		if !bytes.HasSuffix(data, []byte{0x12, 0x34, 0xFF, 0xD9}) {
+			failed = true
+		}
+
+		if failed {
+			format, args := testutil.FormatTestError(
+				test.desc,
+				map[string]interface{}{
+					"existing": test.existing,
+					"got":      data,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	// The EXIF data stays readable.
+	if err := ioutil.WriteFile(path, jpegFile(jfif, exif), 0644); err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+	if err := metadata.WriteIPTCKeywords(path, []string{"Beach"}); err != nil {
+		t.Fatal("Unable to write the keywords.", err)
+	}
+	if m, err := metadata.File(path); err != nil || !equalMetadata(m, cameraMetadata()) {
+		format, args := testutil.FormatTestError(
+			"Expected the metadata to be kept.",
+			map[string]interface{}{
+				"expected": cameraMetadata(),
+				"got":      m,
+				"error":    err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	latin1 := jpegFile(photoshopSegment(append(iptcDataset(1, 90, "\x1b.A"), caption...)))
+	if err := ioutil.WriteFile(path, latin1, 0644); err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+	err := metadata.WriteIPTCKeywords(path, []string{"Beach"})
+	if data, _ := ioutil.ReadFile(path); !errors.Is(err, metadata.ErrUnsupported) || !bytes.Equal(data, latin1) {
+		t.Errorf("Expected IPTC data of another character set than UTF-8 to be kept, got %v.", err)
+	}
+
+	long := jpegFile(jfif)
+	if err := ioutil.WriteFile(path, long, 0644); err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+	err = metadata.WriteIPTCKeywords(path, []string{strings.Repeat("a", 65)})
+	if data, _ := ioutil.ReadFile(path); !errors.Is(err, metadata.ErrUnsupported) || !bytes.Equal(data, long) {
+		t.Errorf("Expected keywords longer than 64 bytes to be unsupported, got %v.", err)
+	}
+
+	// The transcoded caption needs an extended size.
+	large := jpegFile(photoshopSegment(iptcDataset(2, 120, strings.Repeat("\xe9", 0x4100))))
+	if err := ioutil.WriteFile(path, large, 0644); err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+	err = metadata.WriteIPTCKeywords(path, []string{"Beach"})
+	if data, _ := ioutil.ReadFile(path); err == nil || !bytes.Equal(data, large) {
+		t.Errorf("Expected a dataset that needs an extended size to be refused, got %v.", err)
+	}
+
+	if err := ioutil.WriteFile(path, pngImage(t), 0644); err != nil {
+		t.Fatal("Unable to create the image.", err)
+	}
+	if err := metadata.WriteIPTCKeywords(path, []string{"Beach"}); !errors.Is(err, metadata.ErrUnsupported) {
+		t.Errorf("Expected PNG images to be unsupported, got %v.", err)
+	}
+}
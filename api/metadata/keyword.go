@@ -0,0 +1,139 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"tagallery.com/api/util"
+)
+
+// nsLightroom is the namespace of the Lightroom properties, which include the hierarchical keywords.
+const nsLightroom = "http://ns.adobe.com/lightroom/1.0/"
+
+// Keywords are the keywords of an image by their source. IPTC are the IPTC keywords (2:25), XMP the keywords
+// of the XMP packet (dc:subject) and Lightroom the last level of the hierarchical keywords of the XMP packet
+// (lr:hierarchicalSubject), e. g. "Cat" for "Animals|Cat". Duplicates are removed case insensitively.
+type Keywords struct {
+	IPTC      []string
+	XMP       []string
+	Lightroom []string
+}
+
+// FileKeywords reads the keywords of an image file and of its XMP sidecar, see SidecarPath().
+// The keywords of the sidecar are added to the ones of the XMP packet embedded into the file.
+// Files of formats without support for metadata only have the keywords of their sidecar.
+func FileKeywords(path string) (*Keywords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	keywords, err := ReadKeywords(file, info.Size())
+	if errors.Is(err, ErrUnsupported) {
+		keywords = &Keywords{IPTC: []string{}, XMP: []string{}, Lightroom: []string{}}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if data, err := ioutil.ReadFile(SidecarPath(path)); err == nil {
+		keywords.addXMP(data)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return keywords, nil
+}
+
+// ReadKeywords reads the keywords embedded into an image of the given size.
+func ReadKeywords(r io.ReaderAt, size int64) (*Keywords, error) {
+	s, err := extract(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.exif != nil && (s.xmp == nil || s.iptc == nil) {
+		if data, err := parseEXIF(s.exif, s.size); err == nil {
+			if s.xmp == nil {
+				s.xmp = data.xmp
+			}
+			if s.iptc == nil {
+				s.iptc = data.iptc
+			}
+		}
+	}
+
+	keywords := &Keywords{IPTC: []string{}, XMP: []string{}, Lightroom: []string{}}
+	if s.iptc != nil {
+		// Corrupt IPTC data doesn't prevent reading the XMP packet.
+		datasets, _ := parseIPTC(s.iptc)
+		keywords.IPTC = addKeywords(keywords.IPTC, decodeIPTC(datasets)...)
+	}
+	if s.xmp != nil {
+		keywords.addXMP(s.xmp)
+	}
+
+	return keywords, nil
+}
+
+// addXMP adds the keywords of an XMP packet.
+func (k *Keywords) addXMP(data []byte) {
+	// The properties read up to a syntax error are used anyway.
+	values, _ := parseXMP(data)
+
+	k.XMP = addKeywords(k.XMP, values[property(nsDC, "subject")]...)
+	for _, hierarchy := range values[property(nsLightroom, "hierarchicalSubject")] {
+		levels := strings.Split(hierarchy, "|")
+		k.Lightroom = addKeywords(k.Lightroom, levels[len(levels)-1])
+	}
+}
+
+// addKeywords adds the keywords that are not empty and not in the list yet.
+func addKeywords(list []string, keywords ...string) []string {
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" && !util.ContainsString(list, keyword, false) {
+			list = append(list, keyword)
+		}
+	}
+	return list
+}
+
+// decodeIPTC returns the keywords of IPTC datasets as UTF-8. Keywords are taken as UTF-8 if the UTF-8
+// character set is declared or if they are valid UTF-8, and as ISO 8859-1 otherwise.
+func decodeIPTC(datasets []dataset) []string {
+	declared := false
+	for _, d := range datasets {
+		if d.record == recordEnvelope && d.number == datasetCharset && bytes.Equal(d.value, utf8Charset) {
+			declared = true
+		}
+	}
+
+	keywords := iptcKeywords(datasets)
+	for k, keyword := range keywords {
+		if declared || utf8.ValidString(keyword) {
+			continue
+		}
+
+		keywords[k] = latin1ToUTF8([]byte(keyword))
+	}
+	return keywords
+}
+
+// latin1ToUTF8 decodes ISO 8859-1 text, whose bytes are the code points.
+func latin1ToUTF8(text []byte) string {
+	runes := make([]rune, len(text))
+	for k, b := range text {
+		runes[k] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,131 @@
+package metadata_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/testutil"
+)
+
+const keywordPacket = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:lr="http://ns.adobe.com/lightroom/1.0/">
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>Cat</rdf:li>
+     <rdf:li>garden</rdf:li>
+     <rdf:li>Garden</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <lr:hierarchicalSubject>
+    <rdf:Bag>
+     <rdf:li>Animals|Cat</rdf:li>
+     <rdf:li>Places|Home|Garden</rdf:li>
+    </rdf:Bag>
+   </lr:hierarchicalSubject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestReadKeywords(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     []byte
+		expected metadata.Keywords
+	}{
+		{
+			desc: "Expected the IPTC keywords and the XMP keywords of a JPEG image.",
+			data: jpegFile(
+				segment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), keywordPacket...)),
+				photoshopSegment(bytes.Join([][]byte{
+					iptcDataset(1, 90, "\x1b%G"),
+					iptcDataset(2, 25, "Katze"),
+					iptcDataset(2, 25, "Caf\xc3\xa9"),
+					iptcDataset(2, 25, " "),
+				}, nil)),
+			),
+			expected: metadata.Keywords{
+				IPTC:      []string{"Katze", "Café"},
+				XMP:       []string{"Cat", "garden"},
+				Lightroom: []string{"Cat", "Garden"},
+			},
+		},
+		{
+			desc: "Expected IPTC keywords without character set to be decoded as ISO 8859-1.",
+			data: jpegFile(photoshopSegment(iptcDataset(2, 25, "Caf\xe9"))),
+			expected: metadata.Keywords{
+				IPTC:      []string{"Café"},
+				XMP:       []string{},
+				Lightroom: []string{},
+			},
+		},
+		{
+			desc: "Expected the XMP keywords of a PNG image.",
+			data: withChunks(pngImage(t), chunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), keywordPacket...))),
+			expected: metadata.Keywords{
+				IPTC:      []string{},
+				XMP:       []string{"Cat", "garden"},
+				Lightroom: []string{"Cat", "Garden"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if keywords, err := metadata.ReadKeywords(bytes.NewReader(test.data), int64(len(test.data))); err != nil ||
+			!reflect.DeepEqual(*keywords, test.expected) {
+			format, args := testutil.FormatTestError(
+				test.desc,
+				map[string]interface{}{
+					"expected": test.expected,
+					"got":      keywords,
+					"error":    err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestFileKeywords(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"beach.jpg":     jpegFile(photoshopSegment(iptcDataset(2, 25, "Beach"))),
+		"beach.jpg.xmp": []byte(keywordPacket),
+		"notes.txt":     []byte("no image"),
+		"notes.txt.xmp": []byte(keywordPacket),
+		"sea.jpg":       jpegFile(),
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), data, 0644); err != nil {
+			t.Fatal("Unable to create the file.", err)
+		}
+	}
+
+	tests := []struct {
+		file     string
+		expected metadata.Keywords
+	}{
+		{"beach.jpg", metadata.Keywords{IPTC: []string{"Beach"}, XMP: []string{"Cat", "garden"}, Lightroom: []string{"Cat", "Garden"}}},
+		{"notes.txt", metadata.Keywords{IPTC: []string{}, XMP: []string{"Cat", "garden"}, Lightroom: []string{"Cat", "Garden"}}},
+		{"sea.jpg", metadata.Keywords{IPTC: []string{}, XMP: []string{}, Lightroom: []string{}}},
+	}
+
+	for _, test := range tests {
+		if keywords, err := metadata.FileKeywords(filepath.Join(dir, test.file)); err != nil || !reflect.DeepEqual(*keywords, test.expected) {
+			format, args := testutil.FormatTestError(
+				"Expected the keywords of the file and its sidecar.",
+				map[string]interface{}{
+					"file":     test.file,
+					"expected": test.expected,
+					"got":      keywords,
+					"error":    err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
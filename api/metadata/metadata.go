@@ -0,0 +1,497 @@
+// Package metadata reads the metadata of image files, like the capture time, the camera and the location.
+// It parses the EXIF data and the XMP packets embedded into JPEG, TIFF, PNG and HEIC files.
+// Keywords are read from the IPTC data, the XMP packets and the XMP sidecars of files,
+// and written back into XMP sidecars and into the IPTC data of JPEG files.
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"os"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/util"
+
+	// Register the decoders of the image formats whose dimensions can be read.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ErrUnsupported indicates a file format without support for metadata.
+var ErrUnsupported = errors.New("unsupported file format")
+
+// maxSegment limits the size of the segments that are read into memory, which guards against corrupt files.
+const maxSegment = 16 << 20
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	xmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpKeyword   = []byte("XML:com.adobe.xmp\x00")
+)
+
+// segments are the EXIF data (in TIFF format), the XMP packet and the IPTC data of a file.
+type segments struct {
+	exif io.ReaderAt
+	size int64
+	xmp  []byte
+	iptc []byte
+}
+
+// File reads the metadata of an image file. It returns nil if the file has no metadata.
+func File(path string) (*model.Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return Read(file, info.Size())
+}
+
+// Read reads the metadata of an image of the given size. EXIF data takes precedence over XMP properties.
+// Images without dimensions in their metadata are decoded for them, if the format is known to package image.
+// It returns nil if the image has no metadata.
+func Read(r io.ReaderAt, size int64) (*model.Metadata, error) {
+	s, err := extract(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &model.Metadata{}
+	if s.exif != nil {
+		// Corrupt EXIF data doesn't prevent reading the other metadata.
+		if data, err := parseEXIF(s.exif, s.size); err == nil {
+			*m = data.metadata
+			if s.xmp == nil {
+				s.xmp = data.xmp
+			}
+		}
+	}
+	if s.xmp != nil {
+		values, _ := parseXMP(s.xmp)
+		applyXMP(m, values)
+	}
+
+	if m.Width == 0 || m.Height == 0 {
+		if config, _, err := image.DecodeConfig(io.NewSectionReader(r, 0, size)); err == nil {
+			m.Width, m.Height = config.Width, config.Height
+		}
+	}
+
+	if *m == (model.Metadata{}) {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// extract finds the EXIF data and the XMP packet of a file by its format.
+func extract(r io.ReaderAt, size int64) (*segments, error) {
+	header := make([]byte, 12)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}):
+		return extractJPEG(r, size)
+	case bytes.HasPrefix(header, pngSignature):
+		return extractPNG(r, size)
+	case bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")):
+		return &segments{exif: r, size: size}, nil
+	case len(header) == 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return extractHEIF(r, size)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// readAt reads {n} bytes at {offset} of a file of the given size.
+func readAt(r io.ReaderAt, size int64, offset int64, n int64) ([]byte, error) {
+	if offset < 0 || n < 0 || n > maxSegment || n > size-offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, n)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// extractJPEG reads the APP1 segments of a JPEG file, which hold the EXIF data and the XMP packet,
+// and the APP13 segment, which holds the IPTC data.
+func extractJPEG(r io.ReaderAt, size int64) (*segments, error) {
+	s := &segments{}
+
+	for offset := int64(2); offset+4 <= size; {
+		marker, err := readAt(r, size, offset, 4)
+		if err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			break
+		}
+
+		switch code := marker[1]; {
+		case code == 0xFF:
+			// Fill bytes may precede a marker.
+			offset++
+			continue
+		case code == 0x01 || code == 0xD8 || (code >= 0xD0 && code <= 0xD7):
+			// Markers without a segment.
+			offset += 2
+			continue
+		case code == 0xDA || code == 0xD9:
+			// The image data starts, no metadata follows.
+			return s, nil
+		}
+
+		length := int64(binary.BigEndian.Uint16(marker[2:]))
+		if length < 2 {
+			break
+		}
+
+		if marker[1] == 0xE1 && (s.exif == nil || s.xmp == nil) {
+			payload, err := readAt(r, size, offset+4, length-2)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(payload, exifHeader) && s.exif == nil {
+				s.exif, s.size = bytes.NewReader(payload[len(exifHeader):]), int64(len(payload)-len(exifHeader))
+			} else if bytes.HasPrefix(payload, xmpHeader) && s.xmp == nil {
+				s.xmp = payload[len(xmpHeader):]
+			}
+		} else if marker[1] == 0xED && s.iptc == nil {
+			payload, err := readAt(r, size, offset+4, length-2)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(payload, photoshopHeader) {
+				// Corrupt resources don't prevent reading the other metadata.
+				resources, _ := parseResources(payload[len(photoshopHeader):])
+				for _, r := range resources {
+					if r.id == resourceIPTC {
+						s.iptc = r.data
+					}
+				}
+			}
+		}
+
+		offset += 2 + length
+	}
+
+	return s, nil
+}
+
+// extractPNG reads the eXIf chunk and the iTXt chunk with the XMP packet of a PNG file.
+func extractPNG(r io.ReaderAt, size int64) (*segments, error) {
+	s := &segments{}
+
+	for offset := int64(len(pngSignature)); offset+8 <= size; {
+		header, err := readAt(r, size, offset, 8)
+		if err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+
+		switch string(header[4:]) {
+		case "eXIf":
+			data, err := readAt(r, size, offset+8, length)
+			if err != nil {
+				return nil, err
+			}
+			s.exif, s.size = bytes.NewReader(data), length
+		case "iTXt":
+			data, err := readAt(r, size, offset+8, length)
+			if err != nil {
+				return nil, err
+			}
+			if xmp := pngText(data); xmp != nil {
+				s.xmp = xmp
+			}
+		case "IEND":
+			return s, nil
+		}
+
+		// Chunks consist of the length, the type, the data and a CRC.
+		offset += 12 + length
+	}
+
+	return s, nil
+}
+
+// pngText returns the text of an uncompressed iTXt chunk with the XMP keyword.
+func pngText(data []byte) []byte {
+	if !bytes.HasPrefix(data, xmpKeyword) || len(data) < len(xmpKeyword)+2 {
+		return nil
+	}
+
+	// The keyword is followed by the compression flag and method, the language tag and the translated keyword.
+	rest := data[len(xmpKeyword):]
+	if rest[0] != 0 {
+		return nil
+	}
+	rest = rest[2:]
+	for k := 0; k < 2; k++ {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return nil
+		}
+		rest = rest[i+1:]
+	}
+
+	return rest
+}
+
+// extractHEIF reads the Exif item and the XMP item of a HEIF file, e. g. a HEIC image.
+// The items are listed by the iinf box and located by the iloc box of the meta box.
+func extractHEIF(r io.ReaderAt, size int64) (*segments, error) {
+	s := &segments{}
+
+	var meta []byte
+	err := forEachBox(r, 0, size, func(typ string, start int64, end int64) error {
+		if typ != "meta" {
+			return nil
+		}
+
+		var err error
+		meta, err = readAt(r, size, start, end-start)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) < 4 {
+		return s, nil
+	}
+
+	// The meta box is a full box, its content starts after the version and flags.
+	content := bytes.NewReader(meta[4:])
+	items := map[uint32]string{}
+	var locations map[uint32][][2]int64
+
+	err = forEachBox(content, 0, int64(content.Len()), func(typ string, start int64, end int64) error {
+		switch typ {
+		case "iinf":
+			items = parseItemInfo(boxContent(meta[4:], start, end))
+		case "iloc":
+			locations = parseItemLocations(boxContent(meta[4:], start, end))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id, typ := range items {
+		var data []byte
+		for _, extent := range locations[id] {
+			chunk, err := readAt(r, size, extent[0], extent[1])
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, chunk...)
+		}
+
+		switch {
+		case typ == "Exif" && len(data) >= 4:
+			// The data starts with the offset of the TIFF header.
+			offset := int64(binary.BigEndian.Uint32(data)) + 4
+			if offset <= int64(len(data)) {
+				s.exif, s.size = bytes.NewReader(data[offset:]), int64(len(data))-offset
+			}
+		case typ == "application/rdf+xml":
+			s.xmp = data
+		}
+	}
+
+	return s, nil
+}
+
+// forEachBox calls {fn} with the type and the content range of each ISO BMFF box between {start} and {end}.
+func forEachBox(r io.ReaderAt, start int64, end int64, fn func(typ string, start int64, end int64) error) error {
+	for offset := start; offset+8 <= end; {
+		header, err := readAt(r, end, offset, 8)
+		if err != nil {
+			return err
+		}
+
+		length, headerLength := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch length {
+		case 0:
+			// The box extends to the end of the file.
+			length = end - offset
+		case 1:
+			large, err := readAt(r, end, offset+8, 8)
+			if err != nil {
+				return err
+			}
+			length, headerLength = int64(binary.BigEndian.Uint64(large)), 16
+		}
+		// The length is compared to the rest, offset+length may overflow.
+		if length < headerLength || length > end-offset {
+			return io.ErrUnexpectedEOF
+		}
+
+		if err := fn(string(header[4:]), offset+headerLength, offset+length); err != nil {
+			return err
+		}
+		offset += length
+	}
+
+	return nil
+}
+
+// boxContent returns the content of a box from {start} to {end}, or nil if the range exceeds the data.
+func boxContent(data []byte, start int64, end int64) []byte {
+	if start < 0 || start > end || end > int64(len(data)) {
+		return nil
+	}
+	return data[start:end]
+}
+
+// boxReader reads the big endian fields of a box. Reads past the end return 0 and set the error flag.
+type boxReader struct {
+	data []byte
+	err  bool
+}
+
+func (b *boxReader) uint(n int) uint64 {
+	if n > len(b.data) {
+		b.err = true
+		return 0
+	}
+
+	value := uint64(0)
+	for _, c := range b.data[:n] {
+		value = value<<8 | uint64(c)
+	}
+	b.data = b.data[n:]
+	return value
+}
+
+func (b *boxReader) string() string {
+	i := bytes.IndexByte(b.data, 0)
+	if i < 0 {
+		b.err = true
+		return ""
+	}
+
+	value := string(b.data[:i])
+	b.data = b.data[i+1:]
+	return value
+}
+
+// parseItemInfo returns the types of the items listed by an iinf box, or their content type for mime items.
+func parseItemInfo(data []byte) map[uint32]string {
+	items := map[uint32]string{}
+
+	b := &boxReader{data: data}
+	version := b.uint(1)
+	b.uint(3)
+	if version == 0 {
+		b.uint(2)
+	} else {
+		b.uint(4)
+	}
+	if b.err {
+		return items
+	}
+
+	entries := bytes.NewReader(b.data)
+	_ = forEachBox(entries, 0, int64(entries.Len()), func(typ string, start int64, end int64) error {
+		if typ != "infe" {
+			return nil
+		}
+
+		e := &boxReader{data: boxContent(b.data, start, end)}
+		version := e.uint(1)
+		e.uint(3)
+		if version < 2 {
+			return nil
+		}
+
+		var id uint32
+		if version >= 3 {
+			id = uint32(e.uint(4))
+		} else {
+			id = uint32(e.uint(2))
+		}
+		e.uint(2)
+		itemType := string(e.data[:util.MinInt(4, len(e.data))])
+		e.uint(4)
+
+		if itemType == "mime" {
+			e.string()
+			itemType = e.string()
+		}
+		if !e.err {
+			items[id] = itemType
+		}
+		return nil
+	})
+
+	return items
+}
+
+// parseItemLocations returns the file offset and length of the extents of the items of an iloc box.
+// Only items stored in the file itself are returned.
+func parseItemLocations(data []byte) map[uint32][][2]int64 {
+	locations := map[uint32][][2]int64{}
+
+	b := &boxReader{data: data}
+	version := b.uint(1)
+	b.uint(3)
+	sizes := b.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	if version != 1 && version != 2 {
+		indexSize = 0
+	}
+
+	var count uint64
+	if version == 2 {
+		count = b.uint(4)
+	} else {
+		count = b.uint(2)
+	}
+
+	for k := uint64(0); k < count && !b.err; k++ {
+		var id uint32
+		if version == 2 {
+			id = uint32(b.uint(4))
+		} else {
+			id = uint32(b.uint(2))
+		}
+
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = b.uint(2) & 0xF
+		}
+		b.uint(2)
+		base := int64(b.uint(baseOffsetSize))
+
+		extents := int(b.uint(2))
+		for e := 0; e < extents && !b.err; e++ {
+			b.uint(indexSize)
+			offset, length := int64(b.uint(offsetSize)), int64(b.uint(lengthSize))
+			if method == 0 {
+				locations[id] = append(locations[id], [2]int64{base + offset, length})
+			}
+		}
+	}
+
+	return locations
+}
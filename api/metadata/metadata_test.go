@@ -0,0 +1,357 @@
+package metadata_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/model"
+	"tagallery.com/api/testutil"
+)
+
+// field is an IFD entry of a TIFF fixture. Fields with {ifd} > 0 point to the IFD with that index.
+type field struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	ifd   int
+}
+
+func ascii(tag uint16, value string) field {
+	return field{tag: tag, typ: 2, count: uint32(len(value) + 1), value: append([]byte(value), 0)}
+}
+
+func short(order binary.ByteOrder, tag uint16, value uint16) field {
+	data := make([]byte, 2)
+	order.PutUint16(data, value)
+	return field{tag: tag, typ: 3, count: 1, value: data}
+}
+
+func rationals(order binary.ByteOrder, tag uint16, values ...uint32) field {
+	data := make([]byte, len(values)*4)
+	for k, value := range values {
+		order.PutUint32(data[k*4:], value)
+	}
+	return field{tag: tag, typ: 5, count: uint32(len(values) / 2), value: data}
+}
+
+func pointer(tag uint16, ifd int) field {
+	return field{tag: tag, typ: 4, count: 1, ifd: ifd}
+}
+
+// buildTIFF lays out the IFDs one after another, followed by the values that don't fit into their entries.
+func buildTIFF(order binary.ByteOrder, ifds ...[]field) []byte {
+	offsets := make([]uint32, len(ifds))
+	offset := uint32(8)
+	for k, ifd := range ifds {
+		offsets[k] = offset
+		offset += 2 + 12*uint32(len(ifd)) + 4
+	}
+
+	buf := &bytes.Buffer{}
+	values := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+	binary.Write(buf, order, offsets[0])
+
+	for _, ifd := range ifds {
+		binary.Write(buf, order, uint16(len(ifd)))
+		for _, f := range ifd {
+			binary.Write(buf, order, f.tag)
+			binary.Write(buf, order, f.typ)
+			binary.Write(buf, order, f.count)
+
+			switch {
+			case f.ifd > 0:
+				binary.Write(buf, order, offsets[f.ifd])
+			case len(f.value) <= 4:
+				buf.Write(append(append([]byte{}, f.value...), make([]byte, 4-len(f.value))...))
+			default:
+				binary.Write(buf, order, offset+uint32(values.Len()))
+				values.Write(f.value)
+			}
+		}
+		binary.Write(buf, order, uint32(0))
+	}
+
+	return append(buf.Bytes(), values.Bytes()...)
+}
+
+// segment creates a JPEG marker segment.
+func segment(marker byte, payload []byte) []byte {
+	data := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(data[2:], uint16(len(payload)+2))
+	return append(data, payload...)
+}
+
+// chunk creates a PNG chunk.
+func chunk(typ string, data []byte) []byte {
+	buf := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], typ)
+	buf = append(buf, data...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(buf[4:]))
+	return append(buf, crc...)
+}
+
+// box creates an ISO BMFF box.
+func box(typ string, content ...[]byte) []byte {
+	data := bytes.Join(content, nil)
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+8))
+	copy(buf[4:], typ)
+	return append(buf, data...)
+}
+
+func cameraTIFF(order binary.ByteOrder) []byte {
+	return buildTIFF(order,
+		[]field{
+			ascii(0x010F, "Canon"),
+			ascii(0x0110, "Canon EOS 5D"),
+			short(order, 0x0112, 6),
+			pointer(0x8769, 1),
+			pointer(0x8825, 2),
+		},
+		[]field{
+			ascii(0x9003, "2019:07:14 10:31:00"),
+			ascii(0x9011, "+02:00"),
+			short(order, 0xA002, 4000),
+			short(order, 0xA003, 3000),
+			ascii(0xA434, "EF50mm f/1.8"),
+		},
+		[]field{
+			ascii(0x0001, "N"),
+			rationals(order, 0x0002, 51, 1, 30, 1, 0, 1),
+			ascii(0x0003, "W"),
+			rationals(order, 0x0004, 7, 1, 15, 1, 36, 1),
+			field{tag: 0x0005, typ: 1, count: 1, value: []byte{1}},
+			rationals(order, 0x0006, 1234, 10),
+		},
+	)
+}
+
+func cameraMetadata() *model.Metadata {
+	taken := time.Date(2019, 7, 14, 8, 31, 0, 0, time.UTC)
+	altitude := -123.4
+	return &model.Metadata{
+		TakenAt:     &taken,
+		Make:        "Canon",
+		Model:       "Canon EOS 5D",
+		Lens:        "EF50mm f/1.8",
+		Orientation: 6,
+		Width:       4000,
+		Height:      3000,
+		GPS:         &model.GPS{Latitude: 51.5, Longitude: -7.26, Altitude: &altitude},
+	}
+}
+
+const xmpPacket = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+  <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+    <rdf:Description rdf:about=""
+        xmlns:exif="http://ns.adobe.com/exif/1.0/"
+        xmlns:tiff="http://ns.adobe.com/tiff/1.0/"
+        exif:DateTimeOriginal="2020-01-02T03:04:05+01:00"
+        exif:GPSLatitude="48,8.4S"
+        exif:GPSLongitude="11,34,30E">
+      <tiff:Model>Pixel 4</tiff:Model>
+      <tiff:Make>
+        Google
+      </tiff:Make>
+    </rdf:Description>
+  </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func pngImage(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal("Unable to encode the image.", err)
+	}
+	return buf.Bytes()
+}
+
+// withChunks inserts chunks after the IHDR chunk of a PNG image.
+func withChunks(data []byte, chunks ...[]byte) []byte {
+	// The signature (8 bytes) is followed by the IHDR chunk with 13 bytes of data.
+	end := 8 + 12 + 13
+	return append(append(append([]byte{}, data[:end]...), bytes.Join(chunks, nil)...), data[end:]...)
+}
+
+// heic creates a HEIF file whose meta box lists an Exif item stored in the mdat box.
+func heic(tiff []byte) []byte {
+	item := append([]byte{0, 0, 0, 6}, append([]byte("Exif\x00\x00"), tiff...)...)
+
+	infe := box("infe", []byte{2, 0, 0, 0}, []byte{0, 1}, []byte{0, 0}, []byte("Exif"), []byte{0})
+	iinf := box("iinf", []byte{0, 0, 0, 0}, []byte{0, 1}, infe)
+	ftyp := box("ftyp", []byte("heic"), []byte{0, 0, 0, 0}, []byte("mif1heic"))
+
+	iloc := func(offset uint32) []byte {
+		location := make([]byte, 8)
+		binary.BigEndian.PutUint32(location, offset)
+		binary.BigEndian.PutUint32(location[4:], uint32(len(item)))
+		// Version 0, 4 byte offsets and lengths, no base offset, one item with one extent.
+		return box("iloc", []byte{0, 0, 0, 0}, []byte{0x44, 0x00}, []byte{0, 1}, []byte{0, 1}, []byte{0, 0}, []byte{0, 1}, location)
+	}
+	meta := func(offset uint32) []byte {
+		return box("meta", []byte{0, 0, 0, 0}, box("hdlr", make([]byte, 24)), iinf, iloc(offset))
+	}
+
+	// The item starts after the header of the mdat box.
+	offset := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(offset), box("mdat", item)}, nil)
+}
+
+func TestRead(t *testing.T) {
+	taken := time.Date(2020, 1, 2, 2, 4, 5, 0, time.UTC)
+	created := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		data        []byte
+		expected    *model.Metadata
+	}{
+		{
+			"Expected the EXIF data of a JPEG file to be read.",
+			bytes.Join([][]byte{
+				{0xFF, 0xD8},
+				segment(0xE0, []byte("JFIF\x00\x01\x01")),
+				segment(0xE1, append([]byte("Exif\x00\x00"), cameraTIFF(binary.BigEndian)...)),
+				segment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmpPacket...)),
+				{0xFF, 0xD9},
+			}, nil),
+			cameraMetadata(),
+		},
+		{
+			"Expected the IFD0 of a TIFF file to be read.",
+			buildTIFF(binary.LittleEndian, []field{
+				short(binary.LittleEndian, 0x0100, 640),
+				short(binary.LittleEndian, 0x0101, 480),
+				ascii(0x0132, "2018:01:01 12:00:00"),
+			}),
+			&model.Metadata{TakenAt: &created, Width: 640, Height: 480},
+		},
+		{
+			"Expected the EXIF data of a HEIC file to be read.",
+			heic(cameraTIFF(binary.LittleEndian)),
+			cameraMetadata(),
+		},
+		{
+			"Expected the XMP packet and the dimensions of a PNG file to be read.",
+			withChunks(pngImage(t),
+				chunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmpPacket...)),
+			),
+			&model.Metadata{
+				TakenAt: &taken,
+				Make:    "Google",
+				Model:   "Pixel 4",
+				Width:   3,
+				Height:  2,
+				GPS:     &model.GPS{Latitude: -48.14, Longitude: 11.575},
+			},
+		},
+		{
+			"Expected the eXIf chunk of a PNG file to be read.",
+			withChunks(pngImage(t), chunk("eXIf", buildTIFF(binary.BigEndian, []field{ascii(0x0110, "Scanner")}))),
+			&model.Metadata{Model: "Scanner", Width: 3, Height: 2},
+		},
+		{
+			"Expected corrupt EXIF data to be skipped.",
+			bytes.Join([][]byte{
+				{0xFF, 0xD8},
+				segment(0xE1, []byte("Exif\x00\x00MM\x00*\xFF\xFF\xFF\xFF")),
+				{0xFF, 0xD9},
+			}, nil),
+			nil,
+		},
+		{
+			"Expected an image without metadata to have none.",
+			withChunks(pngImage(t))[:8],
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		m, err := metadata.Read(bytes.NewReader(test.data), int64(len(test.data)))
+		if err != nil || !equalMetadata(m, test.expected) {
+			format, args := testutil.FormatTestError(
+				test.description,
+				map[string]interface{}{
+					"error":    err,
+					"expected": test.expected,
+					"got":      m,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestReadOversizedBox(t *testing.T) {
+	ftyp := box("ftyp", []byte("heic"), []byte{0, 0, 0, 0}, []byte("mif1heic"))
+	// A box with a 64 bit size near the maximum, after another box.
+	infe := []byte("\x00\x00\x00\x01infe\x7F\xFF\xFF\xFF\xFF\xFF\xFF\xFF")
+	iinf := box("iinf", []byte{0, 0, 0, 0}, []byte{0, 2}, box("free"), infe)
+	data := append(ftyp, box("meta", []byte{0, 0, 0, 0}, iinf)...)
+
+	if m, err := metadata.Read(bytes.NewReader(data), int64(len(data))); m != nil {
+		t.Errorf("Expected an oversized box to be skipped, got %v (%v).", m, err)
+	}
+
+	// The size of the meta box exceeds the file.
+	data = append(ftyp, []byte("\x00\x00\x00\x01meta\x7F\xFF\xFF\xFF\xFF\xFF\xFF\xF0")...)
+	if m, err := metadata.Read(bytes.NewReader(data), int64(len(data))); m != nil {
+		t.Errorf("Expected a truncated box to be skipped, got %v (%v).", m, err)
+	}
+}
+
+// equalMetadata compares metadata, the coordinates are rounded to 6 decimal places.
+func equalMetadata(a *model.Metadata, b *model.Metadata) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	round := func(m model.Metadata) model.Metadata {
+		if m.GPS != nil {
+			gps := *m.GPS
+			gps.Latitude = math.Round(gps.Latitude*1e6) / 1e6
+			gps.Longitude = math.Round(gps.Longitude*1e6) / 1e6
+			m.GPS = &gps
+		}
+		return m
+	}
+
+	return reflect.DeepEqual(round(*a), round(*b))
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+	if err := ioutil.WriteFile(path, []byte("no image"), 0644); err != nil {
+		t.Fatal("Unable to create the file.", err)
+	}
+
+	if m, err := metadata.File(path); !errors.Is(err, metadata.ErrUnsupported) || m != nil {
+		format, args := testutil.FormatTestError(
+			"Expected files of unknown formats to be unsupported.",
+			map[string]interface{}{
+				"error": err,
+				"got":   m,
+			})
+		t.Errorf(format, args...)
+	}
+}
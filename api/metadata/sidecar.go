@@ -0,0 +1,178 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// nsDC is the namespace of the Dublin Core properties, which include the keywords (dc:subject).
+const nsDC = "http://purl.org/dc/elements/1.1/"
+
+// errInvalidSidecar indicates an XMP sidecar without rdf:Description, which can't be updated.
+var errInvalidSidecar = errors.New("the XMP sidecar has no rdf:Description")
+
+// SidecarExt is the extension of XMP sidecar files.
+const SidecarExt = ".xmp"
+
+// SidecarPath returns the path of the XMP sidecar of a file, which is the path with the extension appended,
+// e. g. beach.jpg.xmp. This is the naming of digiKam and darktable.
+func SidecarPath(path string) string {
+	return path + SidecarExt
+}
+
+// IsSidecar reports whether a file is an XMP sidecar.
+func IsSidecar(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), SidecarExt)
+}
+
+// Sidecar are the properties written into an XMP sidecar.
+// Keywords are written as dc:subject. A label is written as xmp:Label together with the rating as xmp:Rating,
+// an empty label removes both properties. Without a label both properties are kept as they are.
+type Sidecar struct {
+	Keywords []string
+	Label    *string
+	Rating   int
+}
+
+var (
+	sidecarSubject     = regexp.MustCompile(`(?s)\s*<dc:subject\b[^>]*?(/>|>.*?</dc:subject>)`)
+	sidecarLabel       = regexp.MustCompile(`(?s)\s*<xmp:(Label|Rating)\b[^>]*?(/>|>.*?</xmp:(Label|Rating)>)`)
+	sidecarLabelAttr   = regexp.MustCompile(`\s+xmp:(Label|Rating)\s*=\s*("[^"]*"|'[^']*')`)
+	sidecarDescription = regexp.MustCompile(`(?s)<rdf:Description\b[^>]*?(/?)>`)
+)
+
+// WriteSidecar writes the properties into the XMP sidecar at the given path.
+// An existing sidecar is updated in place, so that the properties written by other applications are kept.
+// No sidecar is created if there is nothing to write.
+func WriteSidecar(path string, sidecar Sidecar) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if len(sidecar.Keywords) == 0 && (sidecar.Label == nil || *sidecar.Label == "") {
+			return nil
+		}
+		data = newSidecar(sidecar)
+	} else if err != nil {
+		return err
+	} else if data, err = updateSidecar(data, sidecar); err != nil {
+		return fmt.Errorf("%w: %s", err, path)
+	}
+
+	return writeFile(path, data)
+}
+
+// newSidecar returns a new XMP sidecar with the properties.
+func newSidecar(sidecar Sidecar) []byte {
+	var b bytes.Buffer
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"" + nsRDF + "\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\" xmlns:dc=\"" + nsDC + "\" xmlns:xmp=\"" + nsXMP + "\">")
+	b.WriteString(sidecarProperties(sidecar))
+	b.WriteString("\n  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	return b.Bytes()
+}
+
+// updateSidecar replaces the properties of an existing XMP sidecar. They are written into its first
+// rdf:Description, the namespaces are declared there if the sidecar doesn't declare them yet.
+func updateSidecar(data []byte, sidecar Sidecar) ([]byte, error) {
+	if _, err := parseXMP(data); err != nil {
+		return nil, err
+	}
+
+	data = sidecarSubject.ReplaceAll(data, nil)
+	if sidecar.Label != nil {
+		data = sidecarLabel.ReplaceAll(data, nil)
+		data = sidecarLabelAttr.ReplaceAll(data, nil)
+	}
+
+	match := sidecarDescription.FindSubmatchIndex(data)
+	if match == nil {
+		return nil, errInvalidSidecar
+	}
+
+	var tag strings.Builder
+	tag.Write(data[match[0]:match[2]])
+	for _, ns := range [][2]string{{"dc", nsDC}, {"xmp", nsXMP}} {
+		if !bytes.Contains(data, []byte("xmlns:"+ns[0]+"=")) {
+			tag.WriteString(" xmlns:" + ns[0] + "=\"" + ns[1] + "\"")
+		}
+	}
+	tag.WriteString(">")
+	tag.WriteString(sidecarProperties(sidecar))
+	if match[3] > match[2] {
+		// The description was empty.
+		tag.WriteString("\n  </rdf:Description>")
+	}
+
+	result := append([]byte{}, data[:match[0]]...)
+	result = append(result, tag.String()...)
+	return append(result, data[match[1]:]...), nil
+}
+
+// sidecarProperties returns the elements of the properties of a sidecar.
+func sidecarProperties(sidecar Sidecar) string {
+	var b strings.Builder
+
+	if len(sidecar.Keywords) > 0 {
+		b.WriteString("\n   <dc:subject>\n    <rdf:Bag>")
+		for _, keyword := range sidecar.Keywords {
+			b.WriteString("\n     <rdf:li>" + escapeXML(keyword) + "</rdf:li>")
+		}
+		b.WriteString("\n    </rdf:Bag>\n   </dc:subject>")
+	}
+	if sidecar.Label != nil && *sidecar.Label != "" {
+		b.WriteString("\n   <xmp:Label>" + escapeXML(*sidecar.Label) + "</xmp:Label>")
+		b.WriteString(fmt.Sprintf("\n   <xmp:Rating>%d</xmp:Rating>", sidecar.Rating))
+	}
+
+	return b.String()
+}
+
+// escapeXML escapes the special characters of a text.
+func escapeXML(text string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// writeFile replaces the file at the given path. The data is written into a temporary file
+// that replaces the file once it is complete, so that the file is never left half written.
+// The permissions of an existing file are kept.
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
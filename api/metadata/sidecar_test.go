@@ -0,0 +1,180 @@
+package metadata_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/util"
+)
+
+// sidecarValues reads the keywords, the label and the rating of a sidecar, and the values of {others}.
+func sidecarValues(t *testing.T, path string, others ...string) map[string][]string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("Unable to read the sidecar.", err)
+	}
+
+	values := map[string][]string{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values
+		} else if err != nil {
+			t.Fatal("Unable to parse the sidecar.", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, token.Name.Local)
+			for _, attr := range token.Attr {
+				if util.ContainsString(others, attr.Name.Local, true) {
+					values[attr.Name.Local] = append(values[attr.Name.Local], attr.Value)
+				}
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			text := strings.TrimSpace(string(token))
+			if text == "" || len(stack) < 2 {
+				continue
+			}
+			name := stack[len(stack)-1]
+			if name == "li" && len(stack) >= 3 {
+				name = stack[len(stack)-3]
+			}
+			if util.ContainsString(append(others, "subject", "Label", "Rating"), name, true) {
+				values[name] = append(values[name], text)
+			}
+		}
+	}
+}
+
+func TestWriteSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := metadata.SidecarPath(filepath.Join(dir, "beach.jpg"))
+
+	if path != filepath.Join(dir, "beach.jpg.xmp") || !metadata.IsSidecar(path) || metadata.IsSidecar("beach.jpg") {
+		t.Errorf("Expected the sidecar of beach.jpg to be beach.jpg.xmp, got %s.", path)
+	}
+
+	if err := metadata.WriteSidecar(path, metadata.Sidecar{Label: util.StringPtr("")}); err != nil {
+		t.Fatal("Unable to write the sidecar.", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no sidecar without properties, got %v.", err)
+	}
+
+	tests := []struct {
+		desc     string
+		existing string
+		sidecar  metadata.Sidecar
+		expected map[string][]string
+	}{
+		{
+			desc:     "Expected a new sidecar with the keywords and the label.",
+			sidecar:  metadata.Sidecar{Keywords: []string{"Beach", "Sun & Sea"}, Label: util.StringPtr("Beach"), Rating: 5},
+			expected: map[string][]string{"subject": {"Beach", "Sun & Sea"}, "Label": {"Beach"}, "Rating": {"5"}},
+		},
+		{
+			desc:     "Expected the keywords to be replaced and the label to be kept.",
+			sidecar:  metadata.Sidecar{Keywords: []string{"Sea"}},
+			expected: map[string][]string{"subject": {"Sea"}, "Label": {"Beach"}, "Rating": {"5"}},
+		},
+		{
+			desc:     "Expected an empty label to remove the label and the rating.",
+			sidecar:  metadata.Sidecar{Keywords: []string{"Sea"}, Label: util.StringPtr("")},
+			expected: map[string][]string{"subject": {"Sea"}},
+		},
+		{
+			desc: "Expected the properties of other applications to be kept.",
+			existing: `<?xml version="1.0" encoding="UTF-8"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:darktable="http://darktable.sf.net/"
+    xmp:Rating="3"
+    darktable:xmp_version="3">
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>darktable|changed</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <darktable:history>
+    <rdf:Seq/>
+   </darktable:history>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+`,
+			sidecar:  metadata.Sidecar{Keywords: []string{"Sea"}, Label: util.StringPtr("Sea"), Rating: 5},
+			expected: map[string][]string{"subject": {"Sea"}, "Label": {"Sea"}, "Rating": {"5"}, "xmp_version": {"3"}},
+		},
+		{
+			desc: "Expected the namespaces to be declared in an empty description.",
+			existing: `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+				`<rdf:Description rdf:about=""/></rdf:RDF></x:xmpmeta>`,
+			sidecar:  metadata.Sidecar{Keywords: []string{"Sea"}, Label: util.StringPtr("Sea"), Rating: 5},
+			expected: map[string][]string{"subject": {"Sea"}, "Label": {"Sea"}, "Rating": {"5"}},
+		},
+	}
+
+	for _, test := range tests {
+		if test.existing != "" {
+			if err := ioutil.WriteFile(path, []byte(test.existing), 0644); err != nil {
+				t.Fatal("Unable to create the sidecar.", err)
+			}
+		}
+
+		if err := metadata.WriteSidecar(path, test.sidecar); err != nil {
+			t.Errorf("%s Got error %v.", test.desc, err)
+			continue
+		}
+
+		if values := sidecarValues(t, path, "xmp_version"); !reflect.DeepEqual(values, test.expected) {
+			format, args := testutil.FormatTestError(
+				test.desc,
+				map[string]interface{}{
+					"expected": test.expected,
+					"got":      values,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal("Unable to change the permissions of the sidecar.", err)
+	}
+	if err := metadata.WriteSidecar(path, metadata.Sidecar{}); err != nil {
+		t.Fatal("Unable to write the sidecar.", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the sidecar to keep its permissions, got %v (%v).", info.Mode(), err)
+	}
+}
+
+func TestWriteSidecarInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "beach.jpg.xmp")
+	if err := ioutil.WriteFile(path, []byte("<x:xmpmeta><rdf:RDF>"), 0644); err != nil {
+		t.Fatal("Unable to create the sidecar.", err)
+	}
+
+	if err := metadata.WriteSidecar(path, metadata.Sidecar{Keywords: []string{"Sea"}}); err == nil {
+		t.Error("Expected an invalid sidecar not to be overwritten.")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "<x:xmpmeta><rdf:RDF>" {
+		t.Errorf("Expected the invalid sidecar to be kept, got %s.", data)
+	}
+}
@@ -0,0 +1,230 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"tagallery.com/api/model"
+)
+
+// Namespaces of the XMP properties.
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsEXIF      = "http://ns.adobe.com/exif/1.0/"
+	nsEXIFEX    = "http://cipa.jp/exif/1.0/"
+	nsAux       = "http://ns.adobe.com/exif/1.0/aux/"
+	nsTIFF      = "http://ns.adobe.com/tiff/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+// xmpValues are the properties of an XMP packet, keyed by their namespace and name, see property().
+// Simple properties have a single value, arrays (rdf:Bag, rdf:Seq and rdf:Alt) the values of their items.
+type xmpValues map[string][]string
+
+// property returns the key of a property.
+func property(namespace string, name string) string {
+	return namespace + " " + name
+}
+
+// first returns the first value of a property, or an empty string.
+func (v xmpValues) first(namespace string, name string) string {
+	if values := v[property(namespace, name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// parseXMP reads the properties of the top level rdf:Description elements of an XMP packet.
+// Both properties written as attributes and as elements are read, nested structures are skipped.
+// The properties read up to a syntax error are returned together with the error.
+func parseXMP(data []byte) (xmpValues, error) {
+	values := xmpValues{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var stack []xml.Name
+	var text strings.Builder
+	description, name, items, inItem := 0, "", 0, false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, nil
+		} else if err != nil {
+			return values, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			parent := xml.Name{}
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, t.Name)
+
+			switch {
+			case t.Name == xml.Name{Space: nsRDF, Local: "Description"} && parent == xml.Name{Space: nsRDF, Local: "RDF"}:
+				description = len(stack)
+				for _, attr := range t.Attr {
+					if attr.Name.Space != "" && attr.Name.Space != "xmlns" && attr.Name.Space != nsRDF {
+						key := property(attr.Name.Space, attr.Name.Local)
+						values[key] = append(values[key], strings.TrimSpace(attr.Value))
+					}
+				}
+			case description > 0 && len(stack) == description+1:
+				name, items = property(t.Name.Space, t.Name.Local), 0
+				text.Reset()
+			case name != "" && t.Name == xml.Name{Space: nsRDF, Local: "li"}:
+				inItem, items = true, items+1
+				text.Reset()
+			}
+		case xml.CharData:
+			if name != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case inItem && t.Name == xml.Name{Space: nsRDF, Local: "li"}:
+				if value := strings.TrimSpace(text.String()); value != "" {
+					values[name] = append(values[name], value)
+				}
+				inItem = false
+				text.Reset()
+			case name != "" && len(stack) == description+1:
+				if value := strings.TrimSpace(text.String()); items == 0 && value != "" {
+					values[name] = append(values[name], value)
+				}
+				name = ""
+			case len(stack) == description:
+				description = 0
+			}
+
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+}
+
+// applyXMP fills the fields of the metadata that the EXIF data didn't set with the XMP properties.
+func applyXMP(m *model.Metadata, values xmpValues) {
+	if m.TakenAt == nil {
+		for _, value := range []string{
+			values.first(nsEXIF, "DateTimeOriginal"),
+			values.first(nsPhotoshop, "DateCreated"),
+			values.first(nsXMP, "CreateDate"),
+		} {
+			if m.TakenAt = parseXMPTime(value); m.TakenAt != nil {
+				break
+			}
+		}
+	}
+
+	if m.Make == "" {
+		m.Make = values.first(nsTIFF, "Make")
+	}
+	if m.Model == "" {
+		m.Model = values.first(nsTIFF, "Model")
+	}
+	if m.Lens == "" {
+		m.Lens = values.first(nsEXIFEX, "LensModel")
+	}
+	if m.Lens == "" {
+		m.Lens = values.first(nsAux, "Lens")
+	}
+	if m.Orientation == 0 {
+		if orientation, err := strconv.Atoi(values.first(nsTIFF, "Orientation")); err == nil && orientation >= 1 && orientation <= 8 {
+			m.Orientation = orientation
+		}
+	}
+	if m.Width == 0 || m.Height == 0 {
+		width, errWidth := strconv.Atoi(values.first(nsEXIF, "PixelXDimension"))
+		height, errHeight := strconv.Atoi(values.first(nsEXIF, "PixelYDimension"))
+		if errWidth == nil && errHeight == nil {
+			m.Width, m.Height = width, height
+		}
+	}
+
+	if m.GPS == nil {
+		latitude := parseXMPCoordinate(values.first(nsEXIF, "GPSLatitude"))
+		longitude := parseXMPCoordinate(values.first(nsEXIF, "GPSLongitude"))
+		if latitude != nil && longitude != nil {
+			m.GPS = &model.GPS{Latitude: *latitude, Longitude: *longitude}
+			if altitude := parseXMPRational(values.first(nsEXIF, "GPSAltitude")); altitude != nil {
+				if values.first(nsEXIF, "GPSAltitudeRef") == "1" {
+					*altitude = -*altitude
+				}
+				m.GPS.Altitude = altitude
+			}
+		}
+	}
+}
+
+// xmpTimeLayouts are the layouts of XMP dates, which are a subset of ISO 8601.
+var xmpTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseXMPTime parses an XMP date. Dates without time zone are taken as UTC.
+func parseXMPTime(value string) *time.Time {
+	for _, layout := range xmpTimeLayouts {
+		if taken, err := time.Parse(layout, value); err == nil {
+			taken = taken.UTC()
+			return &taken
+		}
+	}
+	return nil
+}
+
+// parseXMPCoordinate parses an XMP GPS coordinate like "51,30.5N" or "51,30,30N" to decimal degrees.
+func parseXMPCoordinate(value string) *float64 {
+	if len(value) < 2 {
+		return nil
+	}
+
+	ref := value[len(value)-1]
+	values := []float64{}
+	for _, part := range strings.Split(value[:len(value)-1], ",") {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil
+		}
+		values = append(values, number)
+	}
+
+	result := degrees(values)
+	switch ref {
+	case 'S', 'W':
+		*result = -*result
+	case 'N', 'E':
+	default:
+		return nil
+	}
+	return result
+}
+
+// parseXMPRational parses an XMP rational like "1234/10".
+func parseXMPRational(value string) *float64 {
+	parts := strings.SplitN(value, "/", 2)
+	numerator, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil
+	}
+
+	if len(parts) == 2 {
+		denominator, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || denominator == 0 {
+			return nil
+		}
+		numerator /= denominator
+	}
+	return &numerator
+}
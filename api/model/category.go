@@ -1,15 +1,62 @@
 package model
 
 // Category model.
+// ParentID is the id of the parent category, categories without parent are at the root of the hierarchy.
+// Aliases are other names of the category, e. g. synonyms, which are resolved to the category.
 type Category struct {
-	ID          *string `json:"id,omitempty" bson:"_id,omitempty"`
-	Name        string  `json:"name" bson:"name" binding:"required"`
-	Description string  `json:"description" bson:"description"`
+	ID          *string  `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string   `json:"name" bson:"name" binding:"required"`
+	Description string   `json:"description" bson:"description"`
+	ParentID    *string  `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	Aliases     []string `json:"aliases,omitempty" bson:"aliases,omitempty"`
+}
+
+// Names returns the name and the aliases of a category.
+func (c Category) Names() []string {
+	return append([]string{c.Name}, c.Aliases...)
+}
+
+// CategoryNode is a category of the category tree with its child categories.
+type CategoryNode struct {
+	Category
+	Children []CategoryNode `json:"children"`
 }
 
 // CategoryMap models one starred and a list of proposed and assigned categories.
+// Descendants maps categories of the lists to the names of their descendants, which match the filter as well.
 type CategoryMap struct {
-	Starred  *string
-	Proposed []string
-	Assigned []string
+	Starred     *string
+	Proposed    []string
+	Assigned    []string
+	Descendants map[string][]string
+}
+
+// Names returns a category of the filter together with its descendants.
+func (m *CategoryMap) Names(category string) []string {
+	return append([]string{category}, m.Descendants[category]...)
+}
+
+// CategoryMigration summarizes the migration of the category references of the images, see
+// Controller.MigrateCategories(). CategoriesRemoved are the unknown categories removed from the proposals
+// and rejections of the images.
+type CategoryMigration struct {
+	CategoriesCreated []string `json:"categoriesCreated"`
+	CategoriesRemoved []string `json:"categoriesRemoved"`
+	ImagesUpdated     int      `json:"imagesUpdated"`
+}
+
+// CategoryMergeRequest merges a category into the target category. With ConcatenateDescription the description
+// of the merged category is appended to the one of the target, otherwise the target keeps its description.
+type CategoryMergeRequest struct {
+	TargetID               string `json:"targetId" binding:"required"`
+	ConcatenateDescription bool   `json:"concatenateDescription"`
+}
+
+// CategoryMergeReport summarizes the merge of a category. Files are the images that referred to the merged
+// category and refer to the target instead.
+type CategoryMergeReport struct {
+	Source string   `json:"source"`
+	Target Category `json:"target"`
+	Images int      `json:"images"`
+	Files  []string `json:"files"`
 }
@@ -0,0 +1,44 @@
+package model
+
+// ConsistencyReport lists the differences between the images of the store and the files of the image folders.
+// MissingFiles are images whose file doesn't exist, OrphanedFiles are files without an image
+// and StalePaths are images whose file doesn't exist anymore, but was found under another path.
+type ConsistencyReport struct {
+	MissingFiles  []ConsistencyIssue `json:"missingFiles"`
+	OrphanedFiles []ConsistencyIssue `json:"orphanedFiles"`
+	StalePaths    []ConsistencyIssue `json:"stalePaths"`
+}
+
+// ConsistencyIssue is a single difference of a ConsistencyReport.
+// File is the file of the image or the orphaned file, Path is the new path of a stale image.
+// Repairs lists the repair actions that can be applied to the issue.
+type ConsistencyIssue struct {
+	File    string   `json:"file"`
+	Path    string   `json:"path,omitempty"`
+	Repairs []string `json:"repairs"`
+}
+
+// Repair actions of consistency issues.
+const (
+	// RepairReimport indexes an orphaned file, or the new path of a stale image, which keeps its categories.
+	RepairReimport = "reimport"
+	// RepairUnprocess moves an orphaned file back into the unprocessed folder.
+	RepairUnprocess = "unprocess"
+	// RepairPrune deletes the image of a missing file.
+	RepairPrune = "prune"
+)
+
+// RepairRequest applies a repair action to the issues of the given files,
+// or to all issues that offer the action if no files are given.
+type RepairRequest struct {
+	Action string   `json:"action" binding:"required"`
+	Files  []string `json:"files"`
+}
+
+// RepairResult is the outcome of the repair of a single issue.
+// Image is the repaired image, unless the image was pruned.
+type RepairResult struct {
+	File  string `json:"file"`
+	Image *Image `json:"image,omitempty"`
+	Error string `json:"error,omitempty"`
+}
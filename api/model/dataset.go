@@ -0,0 +1,33 @@
+package model
+
+// DatasetRequest exports the categorized images as a training dataset with the given name.
+// Links is the layout of the image folders: "symlink" (the default) or "hardlink" create ImageFolder-style
+// <split>/<category>/<file> links, "none" creates none. Manifest is the format of the multi-label manifest:
+// "csv" (the default), "jsonl" or "none". Split defaults to 80 % train, 10 % val and 10 % test, the seed
+// determines which images go into which split. Without categories all assigned categories are exported.
+type DatasetRequest struct {
+	Name       string        `json:"name" binding:"required"`
+	Links      string        `json:"links"`
+	Manifest   string        `json:"manifest"`
+	Split      *DatasetSplit `json:"split"`
+	Seed       int64         `json:"seed"`
+	Categories []string      `json:"categories"`
+}
+
+// DatasetSplit are the fractions of the images in the train, validation and test split, which add up to 1.
+type DatasetSplit struct {
+	Train float64 `json:"train"`
+	Val   float64 `json:"val"`
+	Test  float64 `json:"test"`
+}
+
+// DatasetReport summarizes the export of a dataset. Added, Removed and Unchanged count the links,
+// Splits the images per split.
+type DatasetReport struct {
+	Path      string         `json:"path"`
+	Images    int            `json:"images"`
+	Splits    map[string]int `json:"splits"`
+	Added     int            `json:"added"`
+	Removed   int            `json:"removed"`
+	Unchanged int            `json:"unchanged"`
+}
@@ -1,7 +1,23 @@
 package model
 
+import "time"
+
 // ImageOptions structures options to filter images.
+// MinConfidence filters images whose best proposal of the filtered categories has
+// a lower score. SortByConfidence sorts images by the score of that proposal,
+// the most confident first. Cursor continues a listing of unprocessed images.
+// TakenAfter and TakenBefore filter images by their capture time (inclusive), Camera by
+// the camera model (case insensitive) and HasGPS by whether their location is known.
+// With Descendants the filtered categories also match the images of their descendant categories.
 type ImageOptions struct {
-	Count     *int
-	LastImage *string
+	Count            *int
+	LastImage        *string
+	Cursor           *string
+	MinConfidence    *float64
+	SortByConfidence bool
+	TakenAfter       *time.Time
+	TakenBefore      *time.Time
+	Camera           *string
+	HasGPS           *bool
+	Descendants      bool
 }
@@ -1,9 +1,30 @@
 package model
 
 // Image model.
+// Hash is the hex encoded SHA-256 hash of the file content,
+// PerceptualHash the hex encoded difference hash (see package phash).
+// RejectedCategories were rejected as proposals by the user and are never proposed again.
+// Metadata is read from the file, it is nil if the file has none.
+// ExtractionFailed records that the perceptual hash or the metadata couldn't be extracted from the file of
+// the Hash, so that it isn't decoded again until its content changes.
+// Unprocessed images are indexed files of the unprocessed folder. They are only
+// returned by ImageStore.AllImages(), but not by ImageStore.GetImages().
 type Image struct {
-	File               string   `json:"file" bson:"file" binding:"required"`
-	AssignedCategories []string `json:"assignedCategories" bson:"assignedCategories"`
-	ProposedCategories []string `json:"proposedCategories" bson:"proposedCategories"`
-	StarredCategory    *string  `json:"starredCategory" bson:"starredCategory"`
+	File               string     `json:"file" bson:"file" binding:"required"`
+	AssignedCategories []string   `json:"assignedCategories" bson:"assignedCategories"`
+	ProposedCategories []Proposal `json:"proposedCategories" bson:"proposedCategories"`
+	StarredCategory    *string    `json:"starredCategory" bson:"starredCategory"`
+	RejectedCategories []string   `json:"rejectedCategories,omitempty" bson:"rejectedCategories,omitempty"`
+	Hash               string     `json:"hash,omitempty" bson:"hash,omitempty"`
+	PerceptualHash     string     `json:"perceptualHash,omitempty" bson:"perceptualHash,omitempty"`
+	Unprocessed        bool       `json:"unprocessed,omitempty" bson:"unprocessed,omitempty"`
+	Metadata           *Metadata  `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	ExtractionFailed   bool       `json:"extractionFailed,omitempty" bson:"extractionFailed,omitempty"`
+}
+
+// SimilarImage is an image found by a similarity search together with
+// the Hamming distance of its perceptual hash to the one of the searched image.
+type SimilarImage struct {
+	Image    Image `json:"image"`
+	Distance int   `json:"distance"`
 }
@@ -0,0 +1,18 @@
+package model
+
+// KeywordExportRequest exports the categories of the images with the given files, or of all processed images
+// if no files are given. The mode is either "sidecar" or "iptc", without mode the configured sidecar export
+// is used.
+type KeywordExportRequest struct {
+	Mode  string   `json:"mode"`
+	Files []string `json:"files"`
+}
+
+// KeywordExportResult is the outcome of the export of the categories of a single image. Mode is the mode the
+// categories were written in, Fallback the reason why a sidecar was written instead of the requested IPTC keywords.
+type KeywordExportResult struct {
+	File     string `json:"file"`
+	Mode     string `json:"mode,omitempty"`
+	Fallback string `json:"fallback,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// LibraryVersion is the version of the library format written by exports. Imports accept it and older versions.
+const LibraryVersion = 1
+
+// Library is the export of all categories and images, which can be imported into another instance.
+type Library struct {
+	Version    int        `json:"version"`
+	ExportedAt time.Time  `json:"exportedAt"`
+	Categories []Category `json:"categories"`
+	Images     []Image    `json:"images"`
+}
+
+// LibraryRecord is a line of a library in NDJSON format. The first line is the header with the version
+// and the time of the export, every following line holds either a category or an image.
+type LibraryRecord struct {
+	Version    int        `json:"version,omitempty"`
+	ExportedAt *time.Time `json:"exportedAt,omitempty"`
+	Category   *Category  `json:"category,omitempty"`
+	Image      *Image     `json:"image,omitempty"`
+}
+
+// ImportReport summarizes the import of a library. Merged categories had the name of an existing
+// (or previously imported) category, compared case insensitive. Renamed maps the names of the imported categories
+// to the names they were merged into, if they differ. Merged images existed already and keep their file data.
+type ImportReport struct {
+	CategoriesCreated int               `json:"categoriesCreated"`
+	CategoriesMerged  int               `json:"categoriesMerged"`
+	ImagesCreated     int               `json:"imagesCreated"`
+	ImagesMerged      int               `json:"imagesMerged"`
+	Renamed           map[string]string `json:"renamed"`
+}
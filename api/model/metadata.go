@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Metadata of an image file, read from its EXIF or XMP data (see package metadata).
+// TakenAt is the capture time, in UTC if the file has no time zone offset.
+// Orientation is the EXIF orientation (1 to 8), Width and Height are the dimensions in pixels.
+type Metadata struct {
+	TakenAt     *time.Time `json:"takenAt,omitempty" bson:"takenAt,omitempty"`
+	Make        string     `json:"make,omitempty" bson:"make,omitempty"`
+	Model       string     `json:"model,omitempty" bson:"model,omitempty"`
+	Lens        string     `json:"lens,omitempty" bson:"lens,omitempty"`
+	Orientation int        `json:"orientation,omitempty" bson:"orientation,omitempty"`
+	Width       int        `json:"width,omitempty" bson:"width,omitempty"`
+	Height      int        `json:"height,omitempty" bson:"height,omitempty"`
+	GPS         *GPS       `json:"gps,omitempty" bson:"gps,omitempty"`
+}
+
+// GPS is the location an image was taken at, in decimal degrees and meters above sea level.
+type GPS struct {
+	Latitude  float64  `json:"latitude" bson:"latitude"`
+	Longitude float64  `json:"longitude" bson:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty" bson:"altitude,omitempty"`
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Proposal is a category proposed for an image.
+// The score ranges from 0 (no confidence) to 1 (certain), source names the proposer
+// (e. g. model name and version) and timestamp is the time of the proposal.
+// Older records store proposals as plain category names, they are decoded
+// as proposals without score, source and timestamp.
+type Proposal struct {
+	Category  string     `json:"category" bson:"category"`
+	Score     float64    `json:"score" bson:"score"`
+	Source    string     `json:"source,omitempty" bson:"source,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty" bson:"timestamp,omitempty"`
+}
+
+// proposal has the fields of Proposal without its decoding methods.
+type proposal Proposal
+
+// UnmarshalJSON decodes a proposal object or a plain category name.
+func (p *Proposal) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = Proposal{Category: name}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*proposal)(p))
+}
+
+// UnmarshalBSONValue decodes a proposal document or a plain category name.
+func (p *Proposal) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	if name, ok := raw.StringValueOK(); ok {
+		*p = Proposal{Category: name}
+		return nil
+	}
+
+	*p = Proposal{}
+	return raw.Unmarshal((*proposal)(p))
+}
+
+// ProposalReview accepts or rejects the proposed categories of an image.
+// Without categories all proposals of the image are reviewed.
+// Action is either "accept" or "reject", it's only needed when reviewing multiple images at once.
+type ProposalReview struct {
+	File       string   `json:"file" binding:"required"`
+	Action     string   `json:"action,omitempty"`
+	Categories []string `json:"categories"`
+}
+
+// CategoryReview accepts or rejects the proposed categories of the image given by the path of the request.
+// Without categories all proposals of the image are reviewed.
+type CategoryReview struct {
+	Categories []string `json:"categories"`
+}
+
+// ProposalReviewResult is the outcome of a single ProposalReview of a bulk review.
+// It either holds the updated image or the error of the review.
+type ProposalReviewResult struct {
+	File  string `json:"file"`
+	Image *Image `json:"image,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ProposalNames returns the category names of proposals.
+func ProposalNames(proposals []Proposal) []string {
+	names := make([]string, len(proposals))
+	for k, proposal := range proposals {
+		names[k] = proposal.Category
+	}
+
+	return names
+}
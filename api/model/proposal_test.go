@@ -0,0 +1,68 @@
+package model_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"tagallery.com/api/model"
+	"tagallery.com/api/testutil"
+)
+
+func TestProposalJSON(t *testing.T) {
+	var image model.Image
+	timestamp := time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)
+	expected := []model.Proposal{
+		{Category: "Category 1"},
+		{Category: "Category 2", Score: 0.8, Source: "knn", Timestamp: &timestamp},
+	}
+
+	data := `{"file": "test.jpg", "proposedCategories": [
+		"Category 1",
+		{"category": "Category 2", "score": 0.8, "source": "knn", "timestamp": "2020-12-01T10:00:00Z"}
+	]}`
+	if err := json.Unmarshal([]byte(data), &image); err != nil || !reflect.DeepEqual(image.ProposedCategories, expected) {
+		format, args := testutil.FormatTestError(
+			"Expected plain category names and proposal objects to be decoded.",
+			map[string]interface{}{
+				"error":    err,
+				"got":      image.ProposedCategories,
+				"expected": expected,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestProposalBSON(t *testing.T) {
+	var image model.Image
+	timestamp := time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)
+	expected := []model.Proposal{
+		{Category: "Category 1"},
+		{Category: "Category 2", Score: 0.8, Source: "knn", Timestamp: &timestamp},
+	}
+
+	data, err := bson.Marshal(bson.M{"file": "test.jpg", "proposedCategories": bson.A{
+		"Category 1",
+		bson.M{"category": "Category 2", "score": 0.8, "source": "knn", "timestamp": timestamp},
+	}})
+	if err == nil {
+		err = bson.Unmarshal(data, &image)
+	}
+
+	if err != nil || len(image.ProposedCategories) != 2 ||
+		!reflect.DeepEqual(image.ProposedCategories[0], expected[0]) ||
+		image.ProposedCategories[1].Category != "Category 2" || image.ProposedCategories[1].Score != 0.8 ||
+		image.ProposedCategories[1].Source != "knn" ||
+		image.ProposedCategories[1].Timestamp == nil || !image.ProposedCategories[1].Timestamp.Equal(timestamp) {
+		format, args := testutil.FormatTestError(
+			"Expected plain category names and proposal documents to be decoded.",
+			map[string]interface{}{
+				"error":    err,
+				"got":      image.ProposedCategories,
+				"expected": expected,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -2,23 +2,31 @@ package mongodb
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"tagallery.com/api/config"
 	"tagallery.com/api/model"
+	"tagallery.com/api/store"
 )
 
-// QueryCategories returns all categories.
-func QueryCategories() ([]model.Category, error) {
-	client := Client()
+// DBCategory extends a model.Category by its keys, the name and the aliases, whose unique index
+// enforces that no name or alias is used twice.
+type DBCategory struct {
+	model.Category `bson:",inline"`
+	Keys           []string `bson:"keys"`
+}
 
+// QueryCategories returns all categories.
+func (s *Store) QueryCategories() ([]model.Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	collection := client.Database(config.Get().Database).Collection("category")
+	collection := s.db.Collection("category")
 
 	cur, err := collection.Find(ctx, bson.M{})
 
@@ -39,12 +47,24 @@ func QueryCategories() ([]model.Category, error) {
 // If the provided category has a valid id, then the entire document is updated.
 // If the id is missing, then the name is taken as an identifier and everything else is updated.
 // You can also provide a valid ObjectId {category.id} for a new category.
-// The name is compared case insensitive and must be unique.
-func UpsertCategory(category model.Category) (*model.Category, error) {
+// The names and aliases are compared case insensitive and must be unique across all categories,
+// which the unique index of the keys enforces. The parent must exist and must not create a cycle.
+func (s *Store) UpsertCategory(category model.Category) (*model.Category, error) {
+	if category.ParentID != nil && *category.ParentID == "" {
+		category.ParentID = nil
+	}
+	if category.ParentID != nil {
+		if err := s.checkParent(category); err != nil {
+			return nil, err
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	collection := Client().Database(config.Get().Database).Collection("category")
+	store.NormalizeAliases(&category)
+
+	collection := s.db.Collection("category")
 	opts := options.Replace().SetUpsert(true)
 	filter := bson.M{}
 
@@ -59,9 +79,11 @@ func UpsertCategory(category model.Category) (*model.Category, error) {
 	}
 	category.ID = nil
 
-	result, err := collection.ReplaceOne(ctx, filter, category, opts)
+	result, err := collection.ReplaceOne(ctx, filter, DBCategory{Category: category, Keys: category.Names()}, opts)
 
-	if err != nil {
+	if isDuplicateKey(err) {
+		return nil, fmt.Errorf("%w: %s", store.ErrDuplicateName, category.Name)
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -72,12 +94,34 @@ func UpsertCategory(category model.Category) (*model.Category, error) {
 	return &category, nil
 }
 
+// checkParent checks the parent of a category against the stored categories, see store.CheckParent().
+// Unlike the unique name, the hierarchy isn't enforced by the database, so concurrent upserts aren't checked.
+func (s *Store) checkParent(category model.Category) error {
+	categories, err := s.QueryCategories()
+	if err != nil {
+		return err
+	}
+
+	id := ""
+	if category.ID != nil {
+		id = *category.ID
+	} else {
+		for _, v := range categories {
+			if v.Name == category.Name {
+				id = *v.ID
+			}
+		}
+	}
+
+	return store.CheckParent(categories, id, category.ParentID)
+}
+
 // DeleteCategory deletes a category.
-func DeleteCategory(id string) error {
+func (s *Store) DeleteCategory(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	collection := Client().Database(config.Get().Database).Collection("category")
+	collection := s.db.Collection("category")
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -89,3 +133,16 @@ func DeleteCategory(id string) error {
 	return err
 
 }
+
+// isDuplicateKey reports whether an error is caused by the violation of a unique index.
+func isDuplicateKey(err error) bool {
+	var exception mongo.WriteException
+	if errors.As(err, &exception) {
+		for _, writeError := range exception.WriteErrors {
+			if writeError.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
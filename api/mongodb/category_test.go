@@ -32,8 +32,9 @@ func TestUpsertCategory(t *testing.T) {
 
 	defer testutil.CleanCollection(t, configuration.Database, "category")
 	mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, config.Get().DatabaseHost))
+	db := mongodb.NewStore(mongodb.Client().Database(configuration.Database))
 
-	category, err := mongodb.UpsertCategory(testCategory)
+	category, err := db.UpsertCategory(testCategory)
 
 	if err != nil ||
 		category.Name != testCategory.Name ||
@@ -52,7 +53,7 @@ func TestUpsertCategory(t *testing.T) {
 		Description: "New category description",
 	}
 
-	updatedCategory, err := mongodb.UpsertCategory(newCategory)
+	updatedCategory, err := db.UpsertCategory(newCategory)
 	if err != nil ||
 		updatedCategory.Name != newCategory.Name ||
 		updatedCategory.Description != newCategory.Description {
@@ -72,7 +73,7 @@ func TestUpsertCategory(t *testing.T) {
 		Name:        "Invalid category",
 		Description: "Invalid category description",
 	}
-	_, err = mongodb.UpsertCategory(invalidCategory)
+	_, err = db.UpsertCategory(invalidCategory)
 	if err == nil {
 		format, args := testutil.FormatTestError(
 			"Expected category with an invalid object id to not be updated.",
@@ -89,8 +90,9 @@ func TestQueryCategories(t *testing.T) {
 
 	defer testutil.CleanCollection(t, configuration.Database, "category")
 	mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, config.Get().DatabaseHost))
+	db := mongodb.NewStore(mongodb.Client().Database(configuration.Database))
 
-	category, err := mongodb.UpsertCategory(testCategory)
+	category, err := db.UpsertCategory(testCategory)
 	if err != nil {
 		format, args := testutil.FormatTestError(
 			"Failed to create test category.",
@@ -100,7 +102,7 @@ func TestQueryCategories(t *testing.T) {
 		t.Errorf(format, args...)
 	}
 
-	categories, err := mongodb.QueryCategories()
+	categories, err := db.QueryCategories()
 	if err != nil ||
 		!reflect.DeepEqual(categories, []model.Category{*category}) {
 		format, args := testutil.FormatTestError(
@@ -117,6 +119,7 @@ func TestDeleteCategory(t *testing.T) {
 
 	defer testutil.CleanCollection(t, configuration.Database, "category")
 	mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, config.Get().DatabaseHost))
+	db := mongodb.NewStore(mongodb.Client().Database(configuration.Database))
 
 	collection := mongodb.Client().Database(configuration.Database).Collection("category")
 	result, err := collection.InsertOne(context.Background(), testCategory, options.InsertOne())
@@ -131,7 +134,7 @@ func TestDeleteCategory(t *testing.T) {
 	}
 	insertId := result.InsertedID.(primitive.ObjectID).Hex()
 
-	if err := mongodb.DeleteCategory(insertId); err != nil {
+	if err := db.DeleteCategory(insertId); err != nil {
 		format, args := testutil.FormatTestError(
 			"Expected category to be deleted.",
 			map[string]interface{}{
@@ -151,7 +154,7 @@ func TestDeleteCategory(t *testing.T) {
 
 	invalidInsertId := "123xyz"
 
-	if err := mongodb.DeleteCategory(invalidInsertId); err == nil {
+	if err := db.DeleteCategory(invalidInsertId); err == nil {
 		format, args := testutil.FormatTestError(
 			"Expected providing an invalid object id to fail.",
 			map[string]interface{}{
@@ -2,15 +2,18 @@ package mongodb
 
 import (
 	"context"
+	"errors"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"tagallery.com/api/config"
 	"tagallery.com/api/logger"
 	"tagallery.com/api/model"
+	"tagallery.com/api/store"
 )
 
 // DBImage extends a model.Image by an Id.
@@ -25,31 +28,16 @@ type DBImage struct {
 // If categories == nil then instead of (auto)-categorized images,
 // only images that have no assigned category will be returned.
 // With lastImage you get only images after this one. Used for pagination.
-func GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.Image, error) {
-	var dbLastImage DBImage
-	doc := bson.D{}
+// MinConfidence and SortByConfidence filter and sort by the score of the proposals.
+func (s *Store) GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.Image, error) {
+	doc := bson.D{{Key: "unprocessed", Value: bson.M{"$ne": true}}}
 
-	collection := Client().Database(config.Get().Database).Collection("image")
+	collection := s.db.Collection("image")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if opts.LastImage != nil {
-		err := collection.FindOne(ctx, bson.M{"file": opts.LastImage}).Decode(&dbLastImage)
-
-		if err != nil {
-			logger.Logger().Warnw("Unable to find lastImage in the database.",
-				"lastImage", *opts.LastImage,
-				"error", err,
-			)
-		} else {
-			doc = append(doc, bson.E{
-				Key: "_id", Value: bson.M{
-					"$gt": dbLastImage.ID,
-				},
-			})
-		}
-	}
+	lastImage := findLastImage(ctx, collection, opts)
 
 	// Uncategorized images only
 	if categories == nil {
@@ -65,9 +53,7 @@ func GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.
 	} else {
 		if categories.Assigned != nil {
 			if len(categories.Assigned) > 0 {
-				doc = append(doc, bson.E{Key: "assignedCategories", Value: bson.M{
-					"$all": categories.Assigned},
-				})
+				doc = append(doc, allCategories("assignedCategories", categories.Assigned, categories))
 			} else {
 				doc = append(doc, bson.E{Key: "$and", Value: bson.A{
 					bson.M{"assignedCategories": bson.M{"$ne": nil}},
@@ -78,9 +64,11 @@ func GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.
 
 		if categories.Proposed != nil {
 			if len(categories.Proposed) > 0 {
-				doc = append(doc, bson.E{Key: "proposedCategories", Value: bson.M{
-					"$all": categories.Proposed},
-				})
+				// Older documents store the proposals as plain category names.
+				doc = append(doc, bson.E{Key: "$or", Value: bson.A{
+					bson.D{allCategories("proposedCategories.category", categories.Proposed, categories)},
+					bson.D{allCategories("proposedCategories", categories.Proposed, categories)},
+				}})
 			} else {
 				doc = append(doc, bson.E{Key: "$and", Value: bson.A{
 					bson.M{"proposedCategories": bson.M{"$ne": nil}},
@@ -94,8 +82,138 @@ func GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.
 		}
 	}
 
-	cur, err := collection.Find(ctx, doc, options.Find().SetLimit(int64(*opts.Count)))
+	doc = append(doc, metadataFilter(opts)...)
+
+	if opts.MinConfidence != nil || opts.SortByConfidence {
+		return s.getImagesByConfidence(ctx, doc, lastImage, opts, categories)
+	}
+
+	if lastImage != nil {
+		doc = append(doc, bson.E{Key: "_id", Value: bson.M{"$gt": lastImage.ID}})
+	}
+
+	findOptions := options.Find()
+	if opts.Count != nil {
+		findOptions.SetLimit(int64(*opts.Count))
+	}
+
+	cur, err := collection.Find(ctx, doc, findOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	images := []model.Image{}
+
+	if err := cur.All(ctx, &images); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// metadataFilter returns the query of the metadata filters of the options, see store.MatchMetadata().
+func metadataFilter(opts model.ImageOptions) bson.D {
+	doc := bson.D{}
+
+	taken := bson.M{}
+	if opts.TakenAfter != nil {
+		taken["$gte"] = *opts.TakenAfter
+	}
+	if opts.TakenBefore != nil {
+		taken["$lte"] = *opts.TakenBefore
+	}
+	if len(taken) > 0 {
+		doc = append(doc, bson.E{Key: "metadata.takenAt", Value: taken})
+	}
+
+	if opts.Camera != nil {
+		doc = append(doc, bson.E{Key: "metadata.model", Value: primitive.Regex{
+			Pattern: "^" + regexp.QuoteMeta(*opts.Camera) + "$",
+			Options: "i",
+		}})
+	}
+
+	if opts.HasGPS != nil {
+		doc = append(doc, bson.E{Key: "metadata.gps", Value: bson.M{"$exists": *opts.HasGPS}})
+	}
+
+	return doc
+}
+
+// allCategories returns the filter of a field that has to contain all of the given categories,
+// or one of their descendants in the category filter.
+func allCategories(field string, names []string, categories *model.CategoryMap) bson.E {
+	if len(categories.Descendants) == 0 {
+		return bson.E{Key: field, Value: bson.M{"$all": names}}
+	}
+
+	all := bson.A{}
+	for _, name := range names {
+		all = append(all, bson.M{field: bson.M{"$in": categories.Names(name)}})
+	}
+	return bson.E{Key: "$and", Value: all}
+}
+
+// getImagesByConfidence queries the images that match {doc} with an aggregation,
+// which computes the confidence of each image like store.Confidence() does.
+func (s *Store) getImagesByConfidence(
+	ctx context.Context, doc bson.D, lastImage *DBImage, opts model.ImageOptions, categories *model.CategoryMap,
+) ([]model.Image, error) {
+	proposals := bson.M{"$ifNull": bson.A{"$proposedCategories", bson.A{}}}
+	if categories != nil && len(categories.Proposed) > 0 {
+		proposed := []string{}
+		for _, category := range categories.Proposed {
+			proposed = append(proposed, categories.Names(category)...)
+		}
+		proposals = bson.M{"$filter": bson.M{
+			"input": proposals,
+			"as":    "proposal",
+			"cond":  bson.M{"$in": bson.A{"$$proposal.category", proposed}},
+		}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: doc}},
+		{{Key: "$addFields", Value: bson.M{"confidence": bson.M{"$ifNull": bson.A{
+			bson.M{"$max": bson.M{"$map": bson.M{
+				"input": proposals,
+				"as":    "proposal",
+				"in":    bson.M{"$ifNull": bson.A{"$$proposal.score", 0}},
+			}}},
+			0,
+		}}}}},
+	}
+
+	if opts.MinConfidence != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{
+			"confidence": bson.M{"$gte": *opts.MinConfidence},
+		}}})
+	}
+
+	sort := bson.D{{Key: "_id", Value: 1}}
+	if lastImage != nil {
+		after := bson.M{"_id": bson.M{"$gt": lastImage.ID}}
+		if opts.SortByConfidence {
+			confidence := store.Confidence(lastImage.Image, categories)
+			after = bson.M{"$or": bson.A{
+				bson.M{"confidence": bson.M{"$lt": confidence}},
+				bson.M{"confidence": confidence, "_id": bson.M{"$gt": lastImage.ID}},
+			}}
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: after}})
+	}
+	if opts.SortByConfidence {
+		sort = append(bson.D{{Key: "confidence", Value: -1}}, sort...)
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
 
+	if opts.Count != nil && *opts.Count > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: *opts.Count}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{"confidence": 0}}})
+
+	cur, err := s.db.Collection("image").Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -109,15 +227,200 @@ func GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.
 	return images, nil
 }
 
+// findLastImage looks up the image after which a page starts. An image that can't be found
+// is logged and results in nil, which starts the page with the first image.
+func findLastImage(ctx context.Context, collection *mongo.Collection, opts model.ImageOptions) *DBImage {
+	var dbLastImage DBImage
+
+	if opts.LastImage == nil {
+		return nil
+	}
+
+	if err := collection.FindOne(ctx, bson.M{"file": opts.LastImage}).Decode(&dbLastImage); err != nil {
+		logger.Logger().Warnw("Unable to find lastImage in the database.",
+			"lastImage", *opts.LastImage,
+			"error", err,
+		)
+		return nil
+	}
+
+	return &dbLastImage
+}
+
 // UpsertImage inserts or updates an existing image in the db.
-func UpsertImage(image model.Image) error {
+func (s *Store) UpsertImage(image model.Image) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	collection := Client().Database(config.Get().Database).Collection("image")
+	collection := s.db.Collection("image")
 
 	opts := options.Replace().SetUpsert(true)
 	_, err := collection.ReplaceOne(ctx, bson.M{"file": image.File}, image, opts)
 
 	return err
 }
+
+// UpdateImage atomically applies {update} to the image of a file.
+// The image is only replaced if the stored document is still the one {update} was applied to,
+// otherwise the update is retried with the current document.
+func (s *Store) UpdateImage(file string, update func(image *model.Image) error) (*model.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := s.db.Collection("image")
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		var raw bson.Raw
+		var image model.Image
+
+		err := collection.FindOne(ctx, bson.M{"file": file}).Decode(&raw)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, store.ErrNotFound
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := bson.Unmarshal(raw, &image); err != nil {
+			return nil, err
+		}
+		if err := update(&image); err != nil {
+			return nil, err
+		}
+		image.File = file
+
+		// Every field has to be unchanged, which makes the replacement a compare-and-swap.
+		elements, err := raw.Elements()
+		if err != nil {
+			return nil, err
+		}
+		filter := bson.D{}
+		for _, element := range elements {
+			filter = append(filter, bson.E{Key: element.Key(), Value: element.Value()})
+		}
+
+		result, err := collection.ReplaceOne(ctx, filter, image)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 1 {
+			return &image, nil
+		}
+	}
+
+	return nil, ErrConcurrentUpdate
+}
+
+// GetUnprocessedImages returns the unprocessed images after a cursor or the last image.
+// The cursor is the hex encoded ObjectID of the last returned image.
+func (s *Store) GetUnprocessedImages(opts model.ImageOptions) ([]model.Image, string, error) {
+	doc := append(bson.D{{Key: "unprocessed", Value: true}}, metadataFilter(opts)...)
+
+	collection := s.db.Collection("image")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if opts.Cursor != nil {
+		id, err := primitive.ObjectIDFromHex(*opts.Cursor)
+		if err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		doc = append(doc, bson.E{Key: "_id", Value: bson.M{"$gt": id}})
+	} else if lastImage := findLastImage(ctx, collection, opts); lastImage != nil {
+		doc = append(doc, bson.E{Key: "_id", Value: bson.M{"$gt": lastImage.ID}})
+	}
+
+	// One more image than requested tells if there is a next page.
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if opts.Count != nil && *opts.Count > 0 {
+		findOptions.SetLimit(int64(*opts.Count) + 1)
+	}
+
+	cur, err := collection.Find(ctx, doc, findOptions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dbImages := []DBImage{}
+	if err := cur.All(ctx, &dbImages); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if opts.Count != nil && *opts.Count > 0 && len(dbImages) > *opts.Count {
+		dbImages = dbImages[:*opts.Count]
+		next = dbImages[len(dbImages)-1].ID.Hex()
+	}
+
+	images := make([]model.Image, len(dbImages))
+	for k, image := range dbImages {
+		images[k] = image.Image
+	}
+
+	return images, next, nil
+}
+
+// AllImages returns all images, including the unprocessed ones.
+func (s *Store) AllImages() ([]model.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := s.db.Collection("image").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	images := []model.Image{}
+
+	if err := cur.All(ctx, &images); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// GetImage returns the image of a file.
+func (s *Store) GetImage(file string) (*model.Image, error) {
+	var image model.Image
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := s.db.Collection("image").FindOne(ctx, bson.M{"file": file}).Decode(&image)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+// GetImagesByHash returns all images with the given content hash.
+func (s *Store) GetImagesByHash(hash string) ([]model.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := s.db.Collection("image").Find(ctx, bson.M{"hash": hash})
+	if err != nil {
+		return nil, err
+	}
+
+	images := []model.Image{}
+
+	if err := cur.All(ctx, &images); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// DeleteImage deletes the image of a file.
+func (s *Store) DeleteImage(file string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.db.Collection("image").DeleteOne(ctx, bson.M{"file": file}, options.Delete())
+
+	return err
+}
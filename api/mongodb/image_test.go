@@ -22,10 +22,10 @@ func init() {
 var imageFixtures = []model.Image{
 	{File: "test1.jpg"},
 	{File: "test2.jpg", AssignedCategories: []string{"Category 1", "Category 2"}},
-	{File: "test3.jpg", ProposedCategories: []string{"Category 2"}},
+	{File: "test3.jpg", ProposedCategories: []model.Proposal{{Category: "Category 2"}}},
 	{File: "test4.jpg", StarredCategory: util.StringPtr("Category 1")},
-	{File: "test5.jpg", AssignedCategories: []string{"Category 2"}, ProposedCategories: []string{"Category 1", "Category 3"}},
-	{File: "test6.jpg", ProposedCategories: []string{"Category 1"}, StarredCategory: util.StringPtr("Category 2")},
+	{File: "test5.jpg", AssignedCategories: []string{"Category 2"}, ProposedCategories: []model.Proposal{{Category: "Category 1"}, {Category: "Category 3"}}},
+	{File: "test6.jpg", ProposedCategories: []model.Proposal{{Category: "Category 1"}}, StarredCategory: util.StringPtr("Category 2")},
 }
 
 // createImageFixtures inserts the image fixtures into the database.
@@ -49,6 +49,7 @@ func TestGetImages(t *testing.T) {
 	configuration := config.Load()
 
 	mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, configuration.DatabaseHost))
+	db := mongodb.NewStore(mongodb.Client().Database(configuration.Database))
 	defer testutil.CleanCollection(t, configuration.Database, "image")
 
 	if err := createImageFixtures(context.Background(), configuration.Database); err != nil {
@@ -61,7 +62,7 @@ func TestGetImages(t *testing.T) {
 	}
 
 	expectedImages = imageFixtures
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(10)},
 		&model.CategoryMap{},
 	)
@@ -80,7 +81,7 @@ func TestGetImages(t *testing.T) {
 		imageFixtures[1],
 		imageFixtures[2],
 	}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(3)},
 		&model.CategoryMap{},
 	)
@@ -98,7 +99,7 @@ func TestGetImages(t *testing.T) {
 		imageFixtures[4],
 		imageFixtures[5],
 	}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{
 			Count:     util.IntPtr(10),
 			LastImage: util.StringPtr(imageFixtures[2].File),
@@ -115,7 +116,7 @@ func TestGetImages(t *testing.T) {
 	}
 
 	expectedImages = []model.Image{imageFixtures[0]}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(10)},
 		nil,
 	)
@@ -132,7 +133,7 @@ func TestGetImages(t *testing.T) {
 		imageFixtures[1],
 		imageFixtures[4],
 	}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(10)},
 		&model.CategoryMap{
 			Assigned: []string{"Category 2"},
@@ -151,7 +152,7 @@ func TestGetImages(t *testing.T) {
 		imageFixtures[1],
 		imageFixtures[4],
 	}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(10)},
 		&model.CategoryMap{
 			Assigned: []string{},
@@ -170,7 +171,7 @@ func TestGetImages(t *testing.T) {
 		imageFixtures[4],
 		imageFixtures[5],
 	}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(10)},
 		&model.CategoryMap{
 			Proposed: []string{"Category 1"},
@@ -190,7 +191,7 @@ func TestGetImages(t *testing.T) {
 		imageFixtures[4],
 		imageFixtures[5],
 	}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(10)},
 		&model.CategoryMap{
 			Proposed: []string{},
@@ -206,7 +207,7 @@ func TestGetImages(t *testing.T) {
 	}
 
 	expectedImages = []model.Image{imageFixtures[3]}
-	dbImages, _ = mongodb.GetImages(
+	dbImages, _ = db.GetImages(
 		model.ImageOptions{Count: util.IntPtr(10)},
 		&model.CategoryMap{
 			Starred: util.StringPtr("Category 1"),
@@ -230,9 +231,10 @@ func TestUpsertImage(t *testing.T) {
 	}
 
 	mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, configuration.DatabaseHost))
+	db := mongodb.NewStore(mongodb.Client().Database(configuration.Database))
 	defer testutil.CleanCollection(t, configuration.Database, "image")
 
-	err := mongodb.UpsertImage(image)
+	err := db.UpsertImage(image)
 	if err != nil {
 		format, args := testutil.FormatTestError(
 			"Expected image to be inserted.",
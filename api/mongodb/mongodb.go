@@ -3,16 +3,40 @@ package mongodb
 import (
 	"context"
 	"errors"
+	"fmt"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
 )
 
 // ErrInvalidObjectID indicates that a provided string is not a valid object id.
-var ErrInvalidObjectID = errors.New("the provided string is not a valid ObjectID")
+var ErrInvalidObjectID = fmt.Errorf("%w: the provided string is not a valid ObjectID", store.ErrInvalidID)
+
+// ErrConcurrentUpdate indicates that an image could not be updated because it was changed concurrently too often.
+var ErrConcurrentUpdate = errors.New("the image was changed concurrently, please retry")
+
+// maxUpdateAttempts is the number of times UpdateImage() retries a concurrently changed image.
+const maxUpdateAttempts = 10
 
 var client *mongo.Client
 
+var _ store.ImageStore = (*Store)(nil)
+var _ store.CategoryStore = (*Store)(nil)
+
+// Store implements the image and category store on top of a MongoDB database.
+type Store struct {
+	db *mongo.Database
+}
+
+// NewStore creates a Store that persists its data in the given database.
+func NewStore(db *mongo.Database) *Store {
+	return &Store{db: db}
+}
+
 // Client returns the mongodb client. Make sure to call Connect() beforehand.
 func Client() *mongo.Client {
 	return client
@@ -30,3 +54,68 @@ func Connect(ctx context.Context, dbURI string) (*mongo.Client, error) {
 
 	return dbClient, nil
 }
+
+// Setup creates unique indexes on the category names and aliases and indexes on the image hash and status
+// of a database.
+func Setup(ctx context.Context, db *mongo.Database) error {
+	categoryCollection := db.Collection("category")
+	imageCollection := db.Collection("image")
+
+	// Creating MongoDb indexes is an idempotent operation.
+	// Therefore we don't have to check if the index already exists.
+	_, err := categoryCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetName("name_unique").SetUnique(true).SetCollation(&options.Collation{
+			Locale:   "en",
+			Strength: 2,
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The keys hold the name and the aliases of a category, categories of older versions have none yet.
+	cur, err := categoryCollection.Find(ctx, bson.M{"keys": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	categories := []model.Category{}
+	if err := cur.All(ctx, &categories); err != nil {
+		return err
+	}
+	for _, category := range categories {
+		objectID, err := primitive.ObjectIDFromHex(*category.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := categoryCollection.UpdateOne(ctx, bson.M{"_id": objectID},
+			bson.M{"$set": bson.M{"keys": category.Names()}}); err != nil {
+			return err
+		}
+	}
+
+	_, err = categoryCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "keys", Value: 1}},
+		Options: options.Index().SetName("keys_unique").SetUnique(true).SetCollation(&options.Collation{
+			Locale:   "en",
+			Strength: 2,
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = imageCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash"),
+		},
+		{
+			// Serves the cursor pagination of the unprocessed images.
+			Keys:    bson.D{{Key: "unprocessed", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("unprocessed"),
+		},
+	})
+
+	return err
+}
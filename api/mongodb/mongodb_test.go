@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"tagallery.com/api/config"
 	"tagallery.com/api/logger"
 	"tagallery.com/api/mongodb"
+	"tagallery.com/api/store"
 	"tagallery.com/api/testutil"
 )
 
@@ -32,3 +34,32 @@ func TestConnect(t *testing.T) {
 		t.Errorf(format, args...)
 	}
 }
+
+func TestStore(t *testing.T) {
+	configuration := config.Load()
+	if _, err := mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, configuration.DatabaseHost)); err != nil {
+		t.Fatal("Unable to connect to the database.", err)
+	}
+
+	testutil.RunStoreTests(t, func(t *testing.T) store.Store {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Every test gets an empty database with the indexes of the API.
+		database := mongodb.Client().Database(configuration.Database + "_store")
+		if err := database.Drop(ctx); err != nil {
+			t.Fatal("Unable to drop the database.", err)
+		}
+		if err := mongodb.Setup(ctx, database); err != nil {
+			t.Fatal("Unable to setup the database.", err)
+		}
+		t.Cleanup(func() {
+			if err := database.Drop(context.Background()); err != nil {
+				t.Error("Unable to drop the database.", err)
+			}
+		})
+
+		db := mongodb.NewStore(database)
+		return store.Store{Images: db, Categories: db}
+	})
+}
@@ -0,0 +1,97 @@
+// Package phash computes perceptual hashes of images, which are similar for visually similar images.
+// Unlike a cryptographic hash, the hash survives resizing, recompression and small edits.
+package phash
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"strconv"
+
+	"tagallery.com/api/thumbnail"
+	"tagallery.com/api/util"
+
+	// Register the decoders of the supported image formats.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// DHash computes the 64 bit difference hash of an image.
+// The image is reduced to 9x8 grey values and every bit encodes whether a pixel is brighter
+// than its right neighbour, which makes the hash robust against scaling and colour changes.
+func DHash(img image.Image) uint64 {
+	var grey [8][9]float64
+	var hash uint64
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return 0
+	}
+
+	for y := 0; y < 8; y++ {
+		y0 := bounds.Min.Y + y*height/8
+		y1 := util.MaxInt(y0+1, bounds.Min.Y+(y+1)*height/8)
+
+		for x := 0; x < 9; x++ {
+			x0 := bounds.Min.X + x*width/9
+			x1 := util.MaxInt(x0+1, bounds.Min.X+(x+1)*width/9)
+
+			var sum, n float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					n++
+				}
+			}
+			grey[y][x] = sum / n
+		}
+	}
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// File decodes an image file and returns its hex encoded DHash.
+// Images with more than {maxPixels} pixels are rejected, see thumbnail.Decode().
+func File(path string, maxPixels int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	img, _, err := thumbnail.Decode(file, maxPixels)
+	if err != nil {
+		return "", err
+	}
+
+	return Format(DHash(img)), nil
+}
+
+// Format encodes a hash as a hex string of 16 characters.
+func Format(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// Parse decodes a hash encoded by Format().
+func Parse(hash string) (uint64, error) {
+	return strconv.ParseUint(hash, 16, 64)
+}
+
+// Distance returns the Hamming distance of two hashes, the number of differing bits.
+// Visually similar images have a small distance, 0 means (almost) identical.
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,60 @@
+package phash_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"tagallery.com/api/phash"
+	"tagallery.com/api/testutil"
+)
+
+// createGradient creates a horizontal gradient, which is darker on the right side if {inverse} is set.
+func createGradient(width int, height int, inverse bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / width)
+			if inverse {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	small := phash.DHash(createGradient(90, 80, true))
+	large := phash.DHash(createGradient(900, 800, true))
+	inverse := phash.DHash(createGradient(900, 800, false))
+
+	if distance := phash.Distance(small, large); distance != 0 {
+		format, args := testutil.FormatTestError(
+			"Expected a resized image to have the same hash.",
+			map[string]interface{}{
+				"distance": distance,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if distance := phash.Distance(large, inverse); distance != 64 {
+		format, args := testutil.FormatTestError(
+			"Expected an inverted image to have an inverted hash.",
+			map[string]interface{}{
+				"distance": distance,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	hash := uint64(0x00ff00ff00ff00ff)
+
+	if formatted := phash.Format(hash); formatted != "00ff00ff00ff00ff" {
+		t.Error("Expected the hash to be formatted as 16 hex characters.", formatted)
+	}
+	if parsed, err := phash.Parse(phash.Format(hash)); err != nil || parsed != hash {
+		t.Error("Expected the formatted hash to be parsed.", err)
+	}
+}
@@ -0,0 +1,211 @@
+package proposal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+)
+
+// ClassifierRequest is sent to an external classifier. It asks for proposals of a batch of images
+// out of the given category names. Depending on the configuration, the images are referenced
+// by their absolute path on the shared file system, or their content is sent base64 encoded.
+type ClassifierRequest struct {
+	Images     []ClassifierImage `json:"images"`
+	Categories []string          `json:"categories"`
+}
+
+// ClassifierImage is a single image of a ClassifierRequest.
+type ClassifierImage struct {
+	File string `json:"file"`
+	Path string `json:"path,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// ClassifierResponse is the answer of an external classifier. It contains one result per requested image.
+// Model and version describe the classifier, they are stored as the source of the proposals.
+type ClassifierResponse struct {
+	Model   string             `json:"model"`
+	Version string             `json:"version"`
+	Results []ClassifierResult `json:"results"`
+}
+
+// ClassifierResult holds the proposals of a single image, matched to the request by the file.
+type ClassifierResult struct {
+	File      string           `json:"file"`
+	Proposals []model.Proposal `json:"proposals"`
+}
+
+// HTTPClassifier is a BatchProposer that delegates to an external classifier via HTTP and JSON.
+// Each batch is posted as a ClassifierRequest to the URL, which has to respond with a ClassifierResponse.
+// Failed requests (network errors and 5xx responses) are retried with an exponential backoff.
+type HTTPClassifier struct {
+	url       string
+	client    *http.Client
+	retries   int
+	backoff   time.Duration
+	batchSize int
+	sendData  bool
+}
+
+var _ BatchProposer = (*HTTPClassifier)(nil)
+
+// ClassifierOptions configure an HTTPClassifier.
+type ClassifierOptions struct {
+	// URL the requests are posted to.
+	URL string
+	// Timeout after which a request is cancelled.
+	Timeout time.Duration
+	// Retries is the number of times a failed request is retried.
+	Retries int
+	// Backoff is the time to wait before the first retry, it doubles with every further retry.
+	// Defaults to one second.
+	Backoff time.Duration
+	// BatchSize is the maximum number of images per request.
+	BatchSize int
+	// SendData sends the content of the images instead of their path,
+	// which is needed if the classifier has no access to the image folders.
+	SendData bool
+}
+
+// NewHTTPClassifier creates an HTTPClassifier.
+func NewHTTPClassifier(opts ClassifierOptions) *HTTPClassifier {
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+
+	return &HTTPClassifier{
+		url:       opts.URL,
+		client:    &http.Client{Timeout: opts.Timeout},
+		retries:   opts.Retries,
+		backoff:   opts.Backoff,
+		batchSize: opts.BatchSize,
+		sendData:  opts.SendData,
+	}
+}
+
+// BatchSize returns the maximum number of images per request.
+func (p *HTTPClassifier) BatchSize() int {
+	return p.batchSize
+}
+
+// Propose asks the classifier for proposals of a single image.
+func (p *HTTPClassifier) Propose(image model.Image, path string, categories []model.Category) ([]model.Proposal, error) {
+	results, err := p.ProposeBatch([]model.Image{image}, []string{path}, categories)
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0], nil
+}
+
+// ProposeBatch asks the classifier for proposals of multiple images with a single request.
+// Proposals are matched to the categories by their names and aliases, case insensitive,
+// and proposals of categories that weren't asked for are dropped.
+// The source of the proposals is "<model>/<version>" of the response.
+func (p *HTTPClassifier) ProposeBatch(images []model.Image, paths []string, categories []model.Category) ([][]model.Proposal, error) {
+	request := ClassifierRequest{Images: make([]ClassifierImage, len(images)), Categories: []string{}}
+
+	for _, category := range categories {
+		request.Categories = append(request.Categories, category.Name)
+	}
+
+	for k, image := range images {
+		request.Images[k] = ClassifierImage{File: image.File, Path: paths[k]}
+		if p.sendData {
+			data, err := ioutil.ReadFile(paths[k])
+			if err != nil {
+				return nil, err
+			}
+			request.Images[k] = ClassifierImage{File: image.File, Data: data}
+		}
+	}
+
+	response, err := p.send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	byFile := map[string][]model.Proposal{}
+	for _, result := range response.Results {
+		byFile[result.File] = result.Proposals
+	}
+
+	source := response.Model
+	if response.Version != "" {
+		source += "/" + response.Version
+	}
+
+	results := make([][]model.Proposal, len(images))
+	for k, image := range images {
+		results[k] = []model.Proposal{}
+		for _, proposal := range byFile[image.File] {
+			if name := store.ResolveCategory(categories, proposal.Category); name != "" {
+				proposal.Category = name
+				proposal.Source = source
+				results[k] = append(results[k], proposal)
+			}
+		}
+	}
+
+	logger.Logger().Debugw("Classifier responded.", "model", response.Model, "version", response.Version, "images", len(images))
+
+	return results, nil
+}
+
+// send posts the request and retries it on failures.
+func (p *HTTPClassifier) send(request ClassifierRequest) (*ClassifierResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := p.backoff
+	for attempt := 0; ; attempt++ {
+		response, retry, err := p.post(body)
+		if err == nil {
+			return response, nil
+		}
+		if !retry || attempt >= p.retries {
+			return nil, err
+		}
+
+		logger.Logger().Infow("Classifier request failed, retrying.", "attempt", attempt+1, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a single request. The returned flag reports if the request may be retried.
+func (p *HTTPClassifier) post(body []byte) (*ClassifierResponse, bool, error) {
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode >= 500 {
+		return nil, true, fmt.Errorf("classifier responded with status %d: %s", resp.StatusCode, content)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("classifier responded with status %d: %s", resp.StatusCode, content)
+	}
+
+	var response ClassifierResponse
+	if err := json.Unmarshal(content, &response); err != nil {
+		return nil, false, errors.New("classifier responded with invalid json: " + err.Error())
+	}
+
+	return &response, false, nil
+}
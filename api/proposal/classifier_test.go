@@ -0,0 +1,148 @@
+package proposal_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/proposal"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+func TestHTTPClassifier(t *testing.T) {
+	config.Load()
+
+	fake := &testutil.FakeClassifier{Failures: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	db := memory.NewStore()
+	db.UpsertCategory(model.Category{Name: "Cat"})
+	db.UpsertCategory(model.Category{Name: "Dog"})
+	for _, file := range []string{"processed/cat.jpg", "processed/dog-and-cat.jpg", "processed/bird.jpg"} {
+		db.UpsertImage(model.Image{File: file})
+	}
+
+	classifier := proposal.NewHTTPClassifier(proposal.ClassifierOptions{
+		URL:       server.URL,
+		Timeout:   time.Second,
+		Retries:   2,
+		Backoff:   time.Millisecond,
+		BatchSize: 2,
+	})
+	worker := proposal.NewWorker(store.Store{Images: db, Categories: db}, classifier, 0.5, 3)
+
+	count, err := worker.RunOnce()
+	if err != nil || count != 2 {
+		format, args := testutil.FormatTestError(
+			"Expected proposals for the images with a category in their name.",
+			map[string]interface{}{
+				"error": err,
+				"count": count,
+			})
+		t.Errorf(format, args...)
+	}
+
+	// The first request failed and was retried, the third image was sent in a second batch.
+	if requests := fake.Requests(); len(requests) != 3 ||
+		len(requests[0].Images) != 2 || len(requests[2].Images) != 1 ||
+		!reflect.DeepEqual(requests[0].Categories, []string{"Cat", "Dog"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the images to be sent in batches and failed requests to be retried.",
+			map[string]interface{}{
+				"requests": requests,
+			})
+		t.Errorf(format, args...)
+	}
+
+	image, _ := db.GetImage("processed/dog-and-cat.jpg")
+	if !reflect.DeepEqual(model.ProposalNames(image.ProposedCategories), []string{"Cat", "Dog"}) ||
+		image.ProposedCategories[0].Source != "fake/1" {
+		format, args := testutil.FormatTestError(
+			"Expected the proposals of the classifier to be stored with the classifier as source.",
+			map[string]interface{}{
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestHTTPClassifierData(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	fake := &testutil.FakeClassifier{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	path := filepath.Join(configuration.Images, "cat.jpg")
+	if err := ioutil.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+
+	classifier := proposal.NewHTTPClassifier(proposal.ClassifierOptions{URL: server.URL, BatchSize: 1, SendData: true})
+	proposals, err := classifier.Propose(model.Image{File: "cat.jpg"}, path, []model.Category{{Name: "Cat"}})
+
+	requests := fake.Requests()
+	if err != nil || len(proposals) != 1 || len(requests) != 1 ||
+		string(requests[0].Images[0].Data) != "content" || requests[0].Images[0].Path != "" {
+		format, args := testutil.FormatTestError(
+			"Expected the content of the image to be sent instead of its path.",
+			map[string]interface{}{
+				"error":     err,
+				"proposals": proposals,
+				"requests":  requests,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestHTTPClassifierTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	classifier := proposal.NewHTTPClassifier(proposal.ClassifierOptions{
+		URL:     server.URL,
+		Timeout: 10 * time.Millisecond,
+		Backoff: time.Millisecond,
+	})
+
+	if _, err := classifier.Propose(model.Image{File: "cat.jpg"}, "cat.jpg", []model.Category{{Name: "Cat"}}); err == nil {
+		t.Error("Expected slow requests to time out.")
+	}
+}
+
+func TestHTTPClassifierAliases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model": "fake", "results": [{"file": "cat.jpg", "proposals": [` +
+			`{"category": "kitty", "score": 0.9}, {"category": "DOG", "score": 0.6}, {"category": "Bird", "score": 0.5}]}]}`))
+	}))
+	defer server.Close()
+
+	classifier := proposal.NewHTTPClassifier(proposal.ClassifierOptions{URL: server.URL, BatchSize: 1})
+	categories := []model.Category{{Name: "Cat", Aliases: []string{"Kitty"}}, {Name: "Dog"}}
+	proposals, err := classifier.Propose(model.Image{File: "cat.jpg"}, "cat.jpg", categories)
+
+	expected := []string{"Cat", "Dog"}
+	if err != nil || !reflect.DeepEqual(model.ProposalNames(proposals), expected) {
+		format, args := testutil.FormatTestError(
+			"Expected aliases and case variants to be resolved to their category and unknown categories to be dropped.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      proposals,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,191 @@
+package proposal
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/model"
+	"tagallery.com/api/phash"
+	"tagallery.com/api/store"
+	"tagallery.com/api/thumbnail"
+
+	// Register the decoders of the supported image formats.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// histogramBins is the number of bins per colour channel.
+const histogramBins = 4
+
+// features describe the look of an image by its colour distribution and its perceptual hash.
+type features struct {
+	histogram [histogramBins * histogramBins * histogramBins]float64
+	hash      uint64
+}
+
+// distance compares two images and returns a value between 0 (identical) and 1 (completely different).
+// The L1 distance of the colour histograms and the Hamming distance of the perceptual hashes are weighted equally.
+func (f *features) distance(other *features) float64 {
+	var histogram float64
+	for k := range f.histogram {
+		histogram += math.Abs(f.histogram[k] - other.histogram[k])
+	}
+
+	return histogram/4 + float64(phash.Distance(f.hash, other.hash))/128
+}
+
+// computeFeatures decodes an image file and computes its features.
+func computeFeatures(path string) (*features, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := thumbnail.Decode(file, config.Get().MaxImagePixels)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &features{hash: phash.DHash(img)}
+
+	// The histogram of a small version is as meaningful but a lot faster to compute.
+	small := thumbnail.Resize(img, 64)
+	bounds := small.Bounds()
+	pixels := float64(bounds.Dx() * bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := small.At(x, y).RGBA()
+			bin := (int(r)*histogramBins>>16)*histogramBins*histogramBins +
+				(int(g)*histogramBins>>16)*histogramBins +
+				int(b)*histogramBins>>16
+			f.histogram[bin] += 1 / pixels
+		}
+	}
+
+	return f, nil
+}
+
+// KNNSource is the source of the proposals of a KNN proposer.
+const KNNSource = "knn"
+
+// KNN proposes categories by looking at the k nearest neighbours of an image.
+// The neighbours are the already categorized images, compared by their colour histogram and perceptual hash.
+// A category is scored by the share of (distance weighted) neighbours it is assigned to.
+// It runs on the CPU and needs no training, but is only as good as colours and shapes tell categories apart.
+type KNN struct {
+	images store.ImageStore
+	k      int
+
+	mu    sync.Mutex
+	cache map[string]*features
+}
+
+var _ Proposer = (*KNN)(nil)
+
+// NewKNN creates a KNN proposer that compares images with the categorized ones of the store.
+func NewKNN(images store.ImageStore, k int) *KNN {
+	if k < 1 {
+		k = 1
+	}
+
+	return &KNN{images: images, k: k, cache: map[string]*features{}}
+}
+
+// Propose returns the categories of the nearest categorized images.
+func (p *KNN) Propose(img model.Image, path string, categories []model.Category) ([]model.Proposal, error) {
+	target, err := p.features(img, path)
+	if err != nil || target == nil {
+		return []model.Proposal{}, err
+	}
+
+	images, err := p.images.AllImages()
+	if err != nil {
+		return nil, err
+	}
+
+	type neighbour struct {
+		image    model.Image
+		distance float64
+	}
+	neighbours := []neighbour{}
+
+	for _, other := range images {
+		if other.File == img.File || other.Unprocessed || len(other.AssignedCategories) == 0 {
+			continue
+		}
+
+		f, err := p.features(other, filepath.Join(config.Get().Images, other.File))
+		if err != nil || f == nil {
+			continue
+		}
+		neighbours = append(neighbours, neighbour{image: other, distance: target.distance(f)})
+	}
+
+	sort.SliceStable(neighbours, func(i, j int) bool {
+		return neighbours[i].distance < neighbours[j].distance
+	})
+	if len(neighbours) > p.k {
+		neighbours = neighbours[:p.k]
+	}
+
+	var total float64
+	scores := map[string]float64{}
+	for _, n := range neighbours {
+		weight := 1 - n.distance
+		total += weight
+		for _, category := range categories {
+			for _, assigned := range n.image.AssignedCategories {
+				if strings.EqualFold(category.Name, assigned) {
+					scores[category.Name] += weight
+					break
+				}
+			}
+		}
+	}
+
+	proposals := []model.Proposal{}
+	for _, category := range categories {
+		if score, ok := scores[category.Name]; ok && total > 0 {
+			proposals = append(proposals, model.Proposal{Category: category.Name, Score: score / total, Source: KNNSource})
+		}
+	}
+	sortProposals(proposals)
+
+	return proposals, nil
+}
+
+// features returns the cached features of an image or computes them.
+// Images that can't be decoded are cached as nil, so that they are not decoded over and over again.
+func (p *KNN) features(img model.Image, path string) (*features, error) {
+	key := img.Hash
+	if key == "" {
+		key = img.File
+	}
+
+	p.mu.Lock()
+	f, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok {
+		return f, nil
+	}
+
+	f, err := computeFeatures(path)
+	if os.IsNotExist(err) {
+		return nil, err
+	} else if err != nil {
+		f = nil
+	}
+
+	p.mu.Lock()
+	p.cache[key] = f
+	p.mu.Unlock()
+
+	return f, nil
+}
@@ -0,0 +1,90 @@
+package proposal_test
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/proposal"
+	"tagallery.com/api/testutil"
+)
+
+func init() {
+	logger.Setup(true)
+}
+
+// writeImage creates a png file of the given colour with a white square in the top left corner.
+func writeImage(t *testing.T, file string, c color.Color) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			if x < 10 && y < 10 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, c)
+			}
+		}
+	}
+
+	path := filepath.Join(config.Get().Images, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal("Unable to create the image folder.", err)
+	}
+	out, err := os.Create(path)
+	if err == nil {
+		err = png.Encode(out, img)
+		out.Close()
+	}
+	if err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+}
+
+func TestKNN(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	categories := []model.Category{{Name: "Red"}, {Name: "Blue"}, {Name: "Green"}}
+
+	db := memory.NewStore()
+	for _, image := range []struct {
+		file     string
+		color    color.Color
+		assigned []string
+	}{
+		{"processed/red1.png", red, []string{"red"}},
+		{"processed/red2.png", red, []string{"Red"}},
+		{"processed/blue1.png", blue, []string{"Blue"}},
+		{"processed/blue2.png", blue, []string{"Blue"}},
+		{"processed/blue3.png", blue, []string{}},
+	} {
+		writeImage(t, image.file, image.color)
+		db.UpsertImage(model.Image{File: image.file, AssignedCategories: image.assigned})
+	}
+	writeImage(t, "unprocessed/new.png", red)
+
+	knn := proposal.NewKNN(db, 3)
+	target := model.Image{File: "unprocessed/new.png", Unprocessed: true}
+	proposals, err := knn.Propose(target, filepath.Join(configuration.Images, target.File), categories)
+
+	if err != nil || len(proposals) != 2 ||
+		proposals[0].Category != "Red" || proposals[1].Category != "Blue" ||
+		proposals[0].Score <= proposals[1].Score {
+		format, args := testutil.FormatTestError(
+			"Expected the categories of the most similar images to be proposed first.",
+			map[string]interface{}{
+				"error":     err,
+				"proposals": proposals,
+			})
+		t.Errorf(format, args...)
+	}
+}
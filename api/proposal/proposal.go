@@ -0,0 +1,33 @@
+// Package proposal automatically proposes categories for images that are not categorized yet.
+package proposal
+
+import (
+	"sort"
+
+	"tagallery.com/api/model"
+)
+
+// Proposer proposes categories for images.
+type Proposer interface {
+	// Propose returns scored proposals for an image out of the given categories.
+	// {path} is the absolute path of the image file. The proposals name the proposer as their source,
+	// the timestamp is set by the Worker.
+	Propose(image model.Image, path string, categories []model.Category) ([]model.Proposal, error)
+}
+
+// BatchProposer is a Proposer that is more efficient when asked for multiple images at once,
+// e. g. because of network round trips. The worker prefers ProposeBatch() over Propose().
+type BatchProposer interface {
+	Proposer
+	// ProposeBatch returns the proposals of each image, in the order of the images.
+	ProposeBatch(images []model.Image, paths []string, categories []model.Category) ([][]model.Proposal, error)
+	// BatchSize returns the maximum number of images per batch.
+	BatchSize() int
+}
+
+// sortProposals sorts proposals by their score, the most confident first.
+func sortProposals(proposals []model.Proposal) {
+	sort.SliceStable(proposals, func(i, j int) bool {
+		return proposals[i].Score > proposals[j].Score
+	})
+}
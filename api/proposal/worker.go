@@ -0,0 +1,225 @@
+package proposal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/util"
+)
+
+// Worker periodically asks a Proposer for categories of all unprocessed and uncategorized images
+// and stores the confident ones as proposed categories.
+type Worker struct {
+	store        store.Store
+	proposer     Proposer
+	minScore     float64
+	maxProposals int
+
+	// attempted holds the images the proposer had no confident proposal for. They are only
+	// retried once the number of categorized images changes, which may change the outcome.
+	attempted   map[string]bool
+	categorized int
+
+	trigger chan struct{}
+}
+
+// NewWorker creates a Worker. Only proposals with a score of at least {minScore} are stored,
+// and no more than {maxProposals} per image.
+func NewWorker(s store.Store, proposer Proposer, minScore float64, maxProposals int) *Worker {
+	return &Worker{
+		store:        s,
+		proposer:     proposer,
+		minScore:     minScore,
+		maxProposals: maxProposals,
+		attempted:    map[string]bool{},
+		trigger:      make(chan struct{}, 1),
+	}
+}
+
+// Run calls RunOnce() every {interval} and whenever Trigger() is called, until the context is cancelled.
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if count, err := w.RunOnce(); err != nil {
+			logger.Logger().Warnw("Unable to propose categories.", "error", err)
+		} else if count > 0 {
+			logger.Logger().Infow("Categories proposed successfully.", "images", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.trigger:
+		}
+	}
+}
+
+// Trigger makes Run() look for new images right away, e. g. because files were added.
+// It doesn't block, multiple triggers while the worker is busy result in a single run.
+func (w *Worker) Trigger() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// RunOnce proposes categories for all images that have neither assigned nor proposed categories
+// and returns the number of images that got proposals. RunOnce must not be called concurrently.
+func (w *Worker) RunOnce() (int, error) {
+	categories, err := w.store.Categories.QueryCategories()
+	if err != nil || len(categories) == 0 {
+		return 0, err
+	}
+
+	images, err := w.store.Images.AllImages()
+	if err != nil {
+		return 0, err
+	}
+
+	categorized := 0
+	for _, image := range images {
+		if !image.Unprocessed && len(image.AssignedCategories) > 0 {
+			categorized++
+		}
+	}
+	if categorized != w.categorized {
+		w.attempted = map[string]bool{}
+		w.categorized = categorized
+	}
+
+	pending := []model.Image{}
+	for _, image := range images {
+		if !w.attempted[image.File+image.Hash] && store.MatchImage(withoutUnprocessed(image), nil) {
+			pending = append(pending, image)
+		}
+	}
+
+	count := 0
+	w.propose(pending, categories, func(image model.Image, proposals []model.Proposal, err error) {
+		saved := false
+		if err == nil {
+			saved, err = w.save(image, proposals, categories)
+		}
+
+		switch {
+		case err != nil:
+			logger.Logger().Warnw("Unable to propose categories for an image.", "file", image.File, "error", err)
+		case saved:
+			count++
+		default:
+			w.attempted[image.File+image.Hash] = true
+		}
+	})
+
+	return count, nil
+}
+
+// propose asks the proposer for categories of the images and calls {done} with the result of each image.
+// Batch proposers are asked for multiple images at once.
+func (w *Worker) propose(images []model.Image, categories []model.Category, done func(model.Image, []model.Proposal, error)) {
+	batcher, ok := w.proposer.(BatchProposer)
+	if !ok {
+		for _, image := range images {
+			proposals, err := w.proposer.Propose(image, imagePath(image), categories)
+			done(image, proposals, err)
+		}
+		return
+	}
+
+	size := batcher.BatchSize()
+	if size < 1 {
+		size = 1
+	}
+
+	for start := 0; start < len(images); start += size {
+		batch := images[start:util.MinInt(start+size, len(images))]
+		paths := make([]string, len(batch))
+		for k, image := range batch {
+			paths[k] = imagePath(image)
+		}
+
+		results, err := batcher.ProposeBatch(batch, paths, categories)
+		for k, image := range batch {
+			if err != nil {
+				done(image, nil, err)
+			} else if k >= len(results) {
+				done(image, nil, errors.New("the proposer returned less results than images"))
+			} else {
+				done(image, results[k], nil)
+			}
+		}
+	}
+}
+
+// save stores the confident proposals of an image. It reports whether any proposal was stored.
+// Proposed aliases are resolved to their category, a category proposed twice keeps its best proposal.
+// Categories the user rejected for the image are never proposed.
+func (w *Worker) save(image model.Image, proposals []model.Proposal, categories []model.Category) (bool, error) {
+	sortProposals(proposals)
+
+	// MongoDB stores timestamps with millisecond precision.
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	confident := []model.Proposal{}
+	for _, proposal := range proposals {
+		if name := store.ResolveCategory(categories, proposal.Category); name != "" {
+			proposal.Category = name
+		}
+		if proposal.Score >= w.minScore && len(confident) < w.maxProposals &&
+			!util.ContainsString(image.RejectedCategories, proposal.Category, false) &&
+			!util.ContainsString(model.ProposalNames(confident), proposal.Category, false) {
+			proposal.Timestamp = &now
+			confident = append(confident, proposal)
+		}
+	}
+	if len(confident) == 0 {
+		return false, nil
+	}
+
+	// The image may have been categorized while the proposer was busy.
+	saved := false
+	_, err := w.store.Images.UpdateImage(image.File, func(current *model.Image) error {
+		saved = false
+		if !store.MatchImage(withoutUnprocessed(*current), nil) {
+			return nil
+		}
+
+		current.ProposedCategories = []model.Proposal{}
+		for _, proposal := range confident {
+			if !util.ContainsString(current.RejectedCategories, proposal.Category, false) {
+				current.ProposedCategories = append(current.ProposedCategories, proposal)
+			}
+		}
+		saved = len(current.ProposedCategories) > 0
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if saved {
+		logger.Logger().Debugw("Proposing categories.", "file", image.File, "proposals", proposals)
+	}
+
+	return saved, nil
+}
+
+// imagePath returns the absolute path of an image file.
+func imagePath(image model.Image) string {
+	return filepath.Join(config.Get().Images, image.File)
+}
+
+// withoutUnprocessed returns the image as a processed one, which lets unprocessed images pass
+// the uncategorized filter of store.MatchImage().
+func withoutUnprocessed(image model.Image) model.Image {
+	image.Unprocessed = false
+	return image
+}
@@ -0,0 +1,140 @@
+package proposal_test
+
+import (
+	"reflect"
+	"testing"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/proposal"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/util"
+)
+
+// fakeProposer proposes the same categories for every image and counts its calls.
+type fakeProposer struct {
+	proposals []model.Proposal
+	calls     int
+}
+
+func (p *fakeProposer) Propose(image model.Image, path string, categories []model.Category) ([]model.Proposal, error) {
+	p.calls++
+	return p.proposals, nil
+}
+
+func TestWorker(t *testing.T) {
+	config.Load()
+
+	db := memory.NewStore()
+	db.UpsertCategory(model.Category{Name: "Category 1"})
+
+	images := []model.Image{
+		{File: "unprocessed/a.jpg", Unprocessed: true},
+		{File: "processed/b.jpg"},
+		{File: "processed/c.jpg", AssignedCategories: []string{"Category 1"}},
+		{File: "processed/d.jpg", StarredCategory: util.StringPtr("Category 1")},
+	}
+	for _, image := range images {
+		db.UpsertImage(image)
+	}
+
+	proposer := &fakeProposer{proposals: []model.Proposal{
+		{Category: "Category 3", Score: 0.2},
+		{Category: "Category 1", Score: 0.9},
+		{Category: "Category 2", Score: 0.6},
+	}}
+	worker := proposal.NewWorker(store.Store{Images: db, Categories: db}, proposer, 0.5, 1)
+
+	count, err := worker.RunOnce()
+	if err != nil || count != 2 || proposer.calls != 2 {
+		format, args := testutil.FormatTestError(
+			"Expected proposals for the unprocessed and the uncategorized image.",
+			map[string]interface{}{
+				"error": err,
+				"count": count,
+				"calls": proposer.calls,
+			})
+		t.Errorf(format, args...)
+	}
+
+	for _, file := range []string{"unprocessed/a.jpg", "processed/b.jpg"} {
+		image, _ := db.GetImage(file)
+		if !reflect.DeepEqual(model.ProposalNames(image.ProposedCategories), []string{"Category 1"}) ||
+			image.ProposedCategories[0].Score != 0.9 || image.ProposedCategories[0].Timestamp == nil {
+			format, args := testutil.FormatTestError(
+				"Expected only the most confident proposal to be stored with its score and timestamp.",
+				map[string]interface{}{
+					"image": image,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	proposer.proposals = []model.Proposal{{Category: "Category 1", Score: 0.1}}
+	db.UpsertImage(model.Image{File: "processed/e.jpg"})
+	worker.RunOnce()
+	worker.RunOnce()
+	if proposer.calls != 3 {
+		t.Error("Expected images without confident proposals not to be retried.", proposer.calls)
+	}
+}
+
+func TestWorkerRejectedCategories(t *testing.T) {
+	config.Load()
+
+	db := memory.NewStore()
+	db.UpsertCategory(model.Category{Name: "Category 1"})
+	db.UpsertImage(model.Image{File: "processed/a.jpg", RejectedCategories: []string{"Category 1"}})
+
+	proposer := &fakeProposer{proposals: []model.Proposal{
+		{Category: "Category 1", Score: 0.9},
+		{Category: "Category 2", Score: 0.6},
+	}}
+	worker := proposal.NewWorker(store.Store{Images: db, Categories: db}, proposer, 0.5, 1)
+
+	count, err := worker.RunOnce()
+	image, _ := db.GetImage("processed/a.jpg")
+	if err != nil || count != 1 || !reflect.DeepEqual(model.ProposalNames(image.ProposedCategories), []string{"Category 2"}) {
+		format, args := testutil.FormatTestError(
+			"Expected rejected categories to never be proposed.",
+			map[string]interface{}{
+				"error": err,
+				"count": count,
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestWorkerAliases(t *testing.T) {
+	config.Load()
+
+	db := memory.NewStore()
+	db.UpsertCategory(model.Category{Name: "Cats", Aliases: []string{"Kitty", "Cat"}})
+	db.UpsertCategory(model.Category{Name: "Dogs"})
+	db.UpsertImage(model.Image{File: "processed/a.jpg"})
+
+	proposer := &fakeProposer{proposals: []model.Proposal{
+		{Category: "kitty", Score: 0.9},
+		{Category: "Cat", Score: 0.8},
+		{Category: "Dogs", Score: 0.7},
+	}}
+	worker := proposal.NewWorker(store.Store{Images: db, Categories: db}, proposer, 0.5, 2)
+
+	count, err := worker.RunOnce()
+	image, _ := db.GetImage("processed/a.jpg")
+	expected := []string{"Cats", "Dogs"}
+	if err != nil || count != 1 || !reflect.DeepEqual(model.ProposalNames(image.ProposedCategories), expected) {
+		format, args := testutil.FormatTestError(
+			"Expected the proposed aliases to be resolved to their category once.",
+			map[string]interface{}{
+				"error":    err,
+				"count":    count,
+				"expected": expected,
+				"image":    image,
+			})
+		t.Errorf(format, args...)
+	}
+}
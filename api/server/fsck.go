@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/logger"
+)
+
+// Fsck checks the consistency between the store and the image folders, like GET /admin/consistency does.
+// The report is written as JSON to {out}. With -repair <action> the action is applied to the issues of the
+// files given as further arguments, or to all issues that offer the action, and the results are written instead.
+// It returns the exit code: 0 if there are no issues or all repairs succeeded, 1 otherwise and 2 on errors.
+func Fsck(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	action := flags.String("repair", "", "the repair action to apply: reimport, unprocess or prune")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config := config.Load()
+	log := logger.Setup(config.Debug)
+	defer log.Sync()
+
+	s, closeStore, err := openStore(config)
+	if err != nil {
+		log.Errorw("Unable to open the storage.", "storage", config.Storage, "error", err)
+		return 2
+	}
+	defer closeStore()
+
+	ctrl := controller.New(s)
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	if *action != "" {
+		results, err := ctrl.Repair(*action, flags.Args())
+		if err != nil {
+			log.Errorw("Unable to repair the consistency issues.", "error", err)
+			return 2
+		}
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintln(out, err)
+			return 2
+		}
+
+		for _, result := range results {
+			if result.Error != "" {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	report, err := ctrl.CheckConsistency()
+	if err != nil {
+		log.Errorw("Unable to check the consistency.", "error", err)
+		return 2
+	}
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	if len(report.MissingFiles)+len(report.OrphanedFiles)+len(report.StalePaths) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/logger"
+)
+
+// Export writes all categories and images of the store to {out}, like GET /admin/export does.
+// The format is set with -format json or -format ndjson.
+// It returns the exit code: 0 on success and 2 on errors.
+func Export(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", controller.FormatJSON, "the format of the library: json or ndjson")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config := config.Load()
+	log := logger.Setup(config.Debug)
+	defer log.Sync()
+
+	s, closeStore, err := openStore(config)
+	if err != nil {
+		log.Errorw("Unable to open the storage.", "storage", config.Storage, "error", err)
+		return 2
+	}
+	defer closeStore()
+
+	library, err := controller.New(s).ExportLibrary()
+	if err != nil {
+		log.Errorw("Unable to export the library.", "error", err)
+		return 2
+	}
+	if err := controller.WriteLibrary(out, library, *format); err != nil {
+		log.Errorw("Unable to write the library.", "error", err)
+		return 2
+	}
+
+	return 0
+}
+
+// Import imports the library of the file given as argument, or of {in} without argument, like POST /admin/import
+// does. The mode is set with -mode merge or -mode replace, the format with -format json or -format ndjson.
+// The report is written as JSON to {out}. It returns the exit code: 0 on success and 2 on errors.
+func Import(args []string, in io.Reader, out io.Writer) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	mode := flags.String("mode", controller.ImportMerge, "the import mode: merge or replace")
+	format := flags.String("format", controller.FormatJSON, "the format of the library: json or ndjson")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config := config.Load()
+	log := logger.Setup(config.Debug)
+	defer log.Sync()
+
+	if flags.NArg() > 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Errorw("Unable to open the library.", "file", flags.Arg(0), "error", err)
+			return 2
+		}
+		defer file.Close()
+		in = file
+	}
+
+	library, err := controller.ReadLibrary(in, *format)
+	if err != nil {
+		log.Errorw("Unable to read the library.", "error", err)
+		return 2
+	}
+
+	s, closeStore, err := openStore(config)
+	if err != nil {
+		log.Errorw("Unable to open the storage.", "storage", config.Storage, "error", err)
+		return 2
+	}
+	defer closeStore()
+
+	report, err := controller.New(s).ImportLibrary(library, *mode)
+	if err != nil {
+		log.Errorw("Unable to import the library.", "error", err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	return 0
+}
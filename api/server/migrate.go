@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/logger"
+)
+
+// Migrate migrates the category references of images stored by older versions, see
+// Controller.MigrateCategories(). The migration can't be undone, it only runs with this command.
+// The report is written as JSON to {out}. It returns the exit code: 0 on success and 2 on errors.
+func Migrate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config := config.Load()
+	log := logger.Setup(config.Debug)
+	defer log.Sync()
+
+	s, closeStore, err := openStore(config)
+	if err != nil {
+		log.Errorw("Unable to open the storage.", "storage", config.Storage, "error", err)
+		return 2
+	}
+	defer closeStore()
+
+	migration, err := controller.New(s).MigrateCategories()
+	if err != nil {
+		log.Errorw("Unable to migrate the categories of the images.", "error", err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(migration); err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	return 0
+}
@@ -1,23 +1,32 @@
 package server
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"tagallery.com/api/controller"
 	"tagallery.com/api/logger"
 	"tagallery.com/api/model"
-	"tagallery.com/api/mongodb"
+	"tagallery.com/api/store"
+	"tagallery.com/api/thumbnail"
 )
 
 // ConfigureRouter creates and sets the routes on the gin router.
-func ConfigureRouter() *gin.Engine {
+// The handlers read and write their data from/to the given store.
+func ConfigureRouter(s store.Store) *gin.Engine {
 	r := gin.Default()
+	// Routes match the escaped path, so that a parameter can hold a file path with escaped slashes.
+	r.UseRawPath, r.UnescapePathValues = true, true
+	ctrl := controller.New(s)
 
 	r.GET("/category", func(c *gin.Context) {
-		if categories, err := mongodb.QueryCategories(); err != nil {
+		if categories, err := s.Categories.QueryCategories(); err != nil {
 			logger.Logger().Warnw("Unable to query cagegories.", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		} else {
@@ -32,12 +41,14 @@ func ConfigureRouter() *gin.Engine {
 		if err := c.ShouldBindJSON(&category); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		} else {
-			if upsertedCategory, err := mongodb.UpsertCategory(category); err != nil {
+			if upsertedCategory, err := ctrl.UpsertCategory(category); err != nil {
 				logger.Logger().Warnw("Unable to upsert cagegory.", "error", err)
 
 				status := http.StatusInternalServerError
-				if errors.Is(err, mongodb.ErrInvalidObjectID) {
+				if errors.Is(err, store.ErrInvalidID) || errors.Is(err, store.ErrInvalidParent) {
 					status = http.StatusBadRequest
+				} else if errors.Is(err, store.ErrDuplicateName) {
+					status = http.StatusConflict
 				}
 				c.JSON(status, gin.H{"error": err.Error()})
 			} else {
@@ -47,15 +58,51 @@ func ConfigureRouter() *gin.Engine {
 		}
 	})
 
+	r.POST("/category/:id/merge", func(c *gin.Context) {
+		var request model.CategoryMergeRequest
+		id := c.Param("id")
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if report, err := ctrl.MergeCategory(id, request); err != nil {
+			logger.Logger().Warnw("Unable to merge the category.", "id", id, "target", request.TargetID, "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, store.ErrInvalidID) || errors.Is(err, controller.ErrInvalidMerge) {
+				status = http.StatusBadRequest
+			} else if errors.Is(err, store.ErrNotFound) {
+				status = http.StatusNotFound
+			} else if errors.Is(err, store.ErrDuplicateName) {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Category merged successfully.", "id", id, "report", report)
+			c.JSON(http.StatusOK, report)
+		}
+	})
+
+	r.GET("/category/tree", func(c *gin.Context) {
+		if tree, err := ctrl.CategoryTree(); err != nil {
+			logger.Logger().Warnw("Unable to query the category tree.", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Category tree queried successfully.", "tree", tree)
+			c.JSON(http.StatusOK, tree)
+		}
+	})
+
+	// The policy for categories with child categories is either refuse (the default), reparent or cascade.
 	r.DELETE("/category/:id", func(c *gin.Context) {
 		id := c.Param("id")
 
-		if err := mongodb.DeleteCategory(id); err != nil {
+		if err := ctrl.DeleteCategory(id, c.Query("policy")); err != nil {
 			logger.Logger().Warnw("Unable to delete cagegory.", "error", err)
 
 			status := http.StatusInternalServerError
-			if errors.Is(err, mongodb.ErrInvalidObjectID) {
+			if errors.Is(err, store.ErrInvalidID) || errors.Is(err, controller.ErrInvalidDeletePolicy) {
 				status = http.StatusBadRequest
+			} else if errors.Is(err, controller.ErrHasChildren) {
+				status = http.StatusConflict
 			}
 			c.JSON(status, gin.H{"error": err.Error()})
 		} else {
@@ -69,12 +116,26 @@ func ConfigureRouter() *gin.Engine {
 		status := c.Query("status")
 		count := c.DefaultQuery("count", "15")
 		lastImage := c.Query("lastImage")
+		cursor := c.Query("cursor")
+		minConfidence := c.Query("minConfidence")
+		takenAfter := c.Query("takenAfter")
+		takenBefore := c.Query("takenBefore")
+		camera := c.Query("camera")
+		hasGPS := c.Query("hasGps")
+		descendants := c.Query("descendants")
 		categories := c.QueryArray("categories")
 
 		logger.Logger().Infow("Request parameters.",
 			"status", status,
 			"count", count,
 			"lastImage", lastImage,
+			"cursor", cursor,
+			"minConfidence", minConfidence,
+			"takenAfter", takenAfter,
+			"takenBefore", takenBefore,
+			"camera", camera,
+			"hasGps", hasGPS,
+			"descendants", descendants,
 			"categories", categories,
 		)
 
@@ -90,11 +151,71 @@ func ConfigureRouter() *gin.Engine {
 			opts.LastImage = &lastImage
 		}
 
-		if images, err := controller.GetImages(status, opts, categories); err != nil {
+		if cursor != "" {
+			opts.Cursor = &cursor
+		}
+
+		if minConfidence != "" {
+			value, err := strconv.ParseFloat(minConfidence, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			opts.MinConfidence = &value
+		}
+
+		if takenAfter != "" {
+			value, err := parseDate(takenAfter, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			opts.TakenAfter = value
+		}
+
+		if takenBefore != "" {
+			value, err := parseDate(takenBefore, true)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			opts.TakenBefore = value
+		}
+
+		if camera != "" {
+			opts.Camera = &camera
+		}
+
+		if hasGPS != "" {
+			value, err := strconv.ParseBool(hasGPS)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			opts.HasGPS = &value
+		}
+
+		if descendants != "" {
+			value, err := strconv.ParseBool(descendants)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			opts.Descendants = value
+		}
+
+		if images, next, err := ctrl.GetImages(status, opts, categories); err != nil {
 			logger.Logger().Warnw("Unable to retrieve images.", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			status := http.StatusInternalServerError
+			if errors.Is(err, store.ErrInvalidCursor) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 		} else {
 			logger.Logger().Infow("Images retrieved succesfully.", "images", images)
+			if next != "" {
+				c.Header("X-Next-Cursor", next)
+			}
 			c.JSON(http.StatusOK, images)
 		}
 	})
@@ -109,10 +230,10 @@ func ConfigureRouter() *gin.Engine {
 				image.AssignedCategories = []string{}
 			}
 			if image.ProposedCategories == nil {
-				image.ProposedCategories = []string{}
+				image.ProposedCategories = []model.Proposal{}
 			}
 
-			if updated, err := controller.UpsertImage(image); err != nil {
+			if updated, err := ctrl.UpsertImage(image); err != nil {
 				logger.Logger().Warnw("Unable to upsert image.", "error", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			} else {
@@ -122,5 +243,338 @@ func ConfigureRouter() *gin.Engine {
 		}
 	})
 
+	// The file is escaped, because file paths contain slashes, e. g. processed%2Fcat.jpg.
+	r.POST("/image/:file/proposals/accept", reviewProposals(ctrl.AcceptProposals))
+	r.POST("/image/:file/proposals/reject", reviewProposals(ctrl.RejectProposals))
+
+	r.POST("/image/proposals", func(c *gin.Context) {
+		var reviews []model.ProposalReview
+
+		if err := c.ShouldBindJSON(&reviews); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			results := ctrl.ReviewProposals(reviews)
+			logger.Logger().Infow("Proposals reviewed.", "results", results)
+			c.JSON(http.StatusOK, results)
+		}
+	})
+
+	r.GET("/image/duplicates", func(c *gin.Context) {
+		if duplicates, err := ctrl.GetDuplicates(); err != nil {
+			logger.Logger().Warnw("Unable to find duplicates.", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Duplicates found successfully.", "duplicates", duplicates)
+			c.JSON(http.StatusOK, duplicates)
+		}
+	})
+
+	r.GET("/image/similar", func(c *gin.Context) {
+		file := c.Query("file")
+		if file == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the file parameter is required"})
+			return
+		}
+
+		maxDistance, err := strconv.Atoi(c.DefaultQuery("maxDistance", "10"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if images, err := ctrl.GetSimilarImages(file, maxDistance); err != nil {
+			logger.Logger().Warnw("Unable to find similar images.", "file", file, "error", err)
+			c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Similar images found successfully.", "file", file, "images", images)
+			c.JSON(http.StatusOK, images)
+		}
+	})
+
+	r.GET("/image/similar/clusters", func(c *gin.Context) {
+		maxDistance, err := strconv.Atoi(c.DefaultQuery("maxDistance", "10"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if clusters, err := ctrl.GetSimilarClusters(maxDistance); err != nil {
+			logger.Logger().Warnw("Unable to cluster similar images.", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Similar images clustered successfully.", "clusters", clusters)
+			c.JSON(http.StatusOK, clusters)
+		}
+	})
+
+	r.POST("/image/rescan", func(c *gin.Context) {
+		if images, err := ctrl.Rescan(); err != nil {
+			logger.Logger().Warnw("Unable to rescan the processed images.", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Processed images rescanned successfully.", "images", images)
+			c.JSON(http.StatusOK, images)
+		}
+	})
+
+	r.POST("/image/reindex", func(c *gin.Context) {
+		if changed, removed, err := ctrl.Reindex(); err != nil {
+			logger.Logger().Warnw("Unable to reindex the images.", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Images reindexed successfully.", "changed", changed, "removed", removed)
+			c.JSON(http.StatusOK, gin.H{"changed": changed, "removed": removed})
+		}
+	})
+
+	r.POST("/image/keywords/export", func(c *gin.Context) {
+		var request model.KeywordExportRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if results, err := ctrl.ExportKeywords(request.Mode, request.Files); err != nil {
+			logger.Logger().Warnw("Unable to export the keywords.", "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, controller.ErrInvalidKeywordExport) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Keywords exported.", "results", results)
+			c.JSON(http.StatusOK, results)
+		}
+	})
+
+	r.GET("/admin/consistency", func(c *gin.Context) {
+		if report, err := ctrl.CheckConsistency(); err != nil {
+			logger.Logger().Warnw("Unable to check the consistency.", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Consistency checked successfully.", "report", report)
+			c.JSON(http.StatusOK, report)
+		}
+	})
+
+	r.GET("/admin/export", func(c *gin.Context) {
+		format := c.DefaultQuery("format", controller.FormatJSON)
+		if format != controller.FormatJSON && format != controller.FormatNDJSON {
+			c.JSON(http.StatusBadRequest, gin.H{"error": controller.ErrInvalidFormat.Error()})
+			return
+		}
+
+		if library, err := ctrl.ExportLibrary(); err != nil {
+			logger.Logger().Warnw("Unable to export the library.", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			contentType := "application/json"
+			if format == controller.FormatNDJSON {
+				contentType = "application/x-ndjson"
+			}
+			c.Header("Content-Type", contentType)
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="tagallery-%s.%s"`,
+				library.ExportedAt.Format("2006-01-02"), format))
+			c.Status(http.StatusOK)
+
+			if err := controller.WriteLibrary(c.Writer, library, format); err != nil {
+				logger.Logger().Warnw("Unable to write the library.", "error", err)
+			} else {
+				logger.Logger().Infow("Library exported successfully.", "categories", len(library.Categories), "images", len(library.Images))
+			}
+		}
+	})
+
+	// The format of the import defaults to the content type, application/x-ndjson for NDJSON.
+	r.POST("/admin/import", func(c *gin.Context) {
+		format := controller.FormatJSON
+		if c.ContentType() == "application/x-ndjson" {
+			format = controller.FormatNDJSON
+		}
+		format = c.DefaultQuery("format", format)
+
+		if library, err := controller.ReadLibrary(c.Request.Body, format); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if report, err := ctrl.ImportLibrary(library, c.Query("mode")); err != nil {
+			logger.Logger().Warnw("Unable to import the library.", "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, controller.ErrInvalidImportMode) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Library imported successfully.", "report", report)
+			c.JSON(http.StatusOK, report)
+		}
+	})
+
+	r.POST("/admin/dataset", func(c *gin.Context) {
+		var request model.DatasetRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if report, err := ctrl.ExportDataset(request); err != nil {
+			logger.Logger().Warnw("Unable to export the dataset.", "name", request.Name, "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, controller.ErrInvalidDataset) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Dataset exported successfully.", "name", request.Name, "report", report)
+			c.JSON(http.StatusOK, report)
+		}
+	})
+
+	r.POST("/admin/consistency/repair", func(c *gin.Context) {
+		var request model.RepairRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if results, err := ctrl.Repair(request.Action, request.Files); err != nil {
+			logger.Logger().Warnw("Unable to repair the consistency issues.", "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, controller.ErrUnknownRepair) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Consistency issues repaired.", "results", results)
+			c.JSON(http.StatusOK, results)
+		}
+	})
+
+	r.POST("/image/upload", func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		files := form.File["files"]
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no files were uploaded"})
+			return
+		}
+
+		if images, err := ctrl.UploadImages(files); err != nil {
+			logger.Logger().Warnw("Unable to upload images.", "error", err)
+
+			status := http.StatusInternalServerError
+			if errors.Is(err, controller.ErrUnsupportedMediaType) {
+				status = http.StatusUnsupportedMediaType
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Images uploaded successfully.", "images", images)
+			c.JSON(http.StatusOK, images)
+		}
+	})
+
+	r.GET("/image/file/*path", func(c *gin.Context) {
+		path, err := controller.ImagePath(c.Param("path"))
+		if err != nil {
+			logger.Logger().Warnw("Unable to resolve image file.", "path", c.Param("path"), "error", err)
+			c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		serveFile(c, path)
+	})
+
+	r.GET("/image/thumb/*path", func(c *gin.Context) {
+		size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		path, err := controller.ThumbnailPath(c.Param("path"), size)
+		if err != nil {
+			logger.Logger().Warnw("Unable to create thumbnail.", "path", c.Param("path"), "size", size, "error", err)
+			c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		serveFile(c, path)
+	})
+
 	return r
 }
+
+// serveFile streams a file to the client. http.ServeContent takes care of
+// conditional (ETag, Last-Modified) and range requests.
+func serveFile(c *gin.Context, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+
+	c.Header("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	c.Header("Cache-Control", "no-cache")
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// reviewProposals returns a handler that accepts or rejects the proposals of the image of the file parameter
+// with {review}. The body is optional, without categories all proposals are reviewed.
+func reviewProposals(review func(file string, categories []string) (*model.Image, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request model.CategoryReview
+		file := c.Param("file")
+
+		if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if image, err := review(file, request.Categories); err != nil {
+			logger.Logger().Warnw("Unable to review proposals.", "file", file, "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, store.ErrNotFound) {
+				status = http.StatusNotFound
+			} else if errors.Is(err, controller.ErrNotProposed) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			logger.Logger().Infow("Proposals reviewed successfully.", "image", image)
+			c.JSON(http.StatusOK, image)
+		}
+	}
+}
+
+// fileErrorStatus maps errors of file operations to http status codes.
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, controller.ErrInvalidPath), errors.Is(err, controller.ErrInvalidThumbnailSize):
+		return http.StatusBadRequest
+	case errors.Is(err, thumbnail.ErrUnsupportedFormat), errors.Is(err, controller.ErrNoPerceptualHash):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, thumbnail.ErrTooLarge):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseDate parses a RFC 3339 time or a date like "2019-07-14", which is taken as UTC.
+// With {endOfDay} a date refers to the last instant of the day, so that it can be used as an inclusive upper bound.
+func parseDate(value string, endOfDay bool) (*time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		if endOfDay {
+			date = date.Add(24*time.Hour - time.Nanosecond)
+		}
+		return &date, nil
+	}
+
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
@@ -0,0 +1,166 @@
+package server_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"tagallery.com/api/config"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/server"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+)
+
+func init() {
+	logger.Setup(true)
+}
+
+// newRouter creates a router on an empty memory store.
+func newRouter() (*gin.Engine, *memory.Store) {
+	gin.SetMode(gin.TestMode)
+	db := memory.NewStore()
+	return server.ConfigureRouter(store.Store{Images: db, Categories: db}), db
+}
+
+// serve sends a request to the router and returns the recorded response.
+func serve(router *gin.Engine, method string, url string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, url, bytes.NewBufferString(body)))
+	return recorder
+}
+
+func TestGIFThumbnail(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	router, _ := newRouter()
+
+	path := filepath.Join(configuration.Images, configuration.ProcessedImagesFolder, "a.gif")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal("Unable to create the processed image folder.", err)
+	}
+	file, err := os.Create(path)
+	if err == nil {
+		err = gif.Encode(file, image.NewPaletted(image.Rect(0, 0, 200, 100), []color.Color{color.White}), nil)
+		file.Close()
+	}
+	if err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+
+	response := serve(router, http.MethodGet, "/image/thumb/processed/a.gif?size=50", "")
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "image/png" ||
+		!bytes.HasPrefix(response.Body.Bytes(), []byte("\x89PNG")) {
+		format, args := testutil.FormatTestError(
+			"Expected the png thumbnail of a gif to be served as png.",
+			map[string]interface{}{
+				"status":      response.Code,
+				"contentType": response.Header().Get("Content-Type"),
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestUpsertCategoryConflict(t *testing.T) {
+	config.Load()
+	router, db := newRouter()
+	db.UpsertCategory(model.Category{Name: "Cats", Aliases: []string{"Kitty"}})
+
+	tests := []struct {
+		body     string
+		expected int
+	}{
+		{`{"name": "Dogs", "aliases": ["Puppy"]}`, http.StatusOK},
+		{`{"name": "cats"}`, http.StatusConflict},
+		{`{"name": "Mice", "aliases": ["kitty"]}`, http.StatusConflict},
+		{`{"name": "Birds", "parentId": "invalid"}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if response := serve(router, http.MethodPost, "/category", test.body); response.Code != test.expected {
+			format, args := testutil.FormatTestError(
+				"Expected names and aliases of other categories to conflict.",
+				map[string]interface{}{
+					"body":     test.body,
+					"expected": test.expected,
+					"got":      response.Code,
+					"response": response.Body.String(),
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func TestRepairUnknownAction(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	router, _ := newRouter()
+
+	if response := serve(router, http.MethodPost, "/admin/consistency/repair", `{"action": "delete"}`); response.Code != http.StatusBadRequest {
+		format, args := testutil.FormatTestError(
+			"Expected an unknown repair action to be rejected, even without issues.",
+			map[string]interface{}{
+				"got":      response.Code,
+				"response": response.Body.String(),
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestReviewProposalsRoute(t *testing.T) {
+	config.Load()
+	router, db := newRouter()
+	db.UpsertImage(model.Image{
+		File:               "processed/Trip/a.jpg",
+		AssignedCategories: []string{},
+		ProposedCategories: []model.Proposal{{Category: "Cats"}, {Category: "Dogs"}, {Category: "Birds"}},
+	})
+
+	tests := []struct {
+		url      string
+		body     string
+		expected int
+	}{
+		{"/image/processed%2FTrip%2Fa.jpg/proposals/accept", `{"categories": ["Cats"]}`, http.StatusOK},
+		{"/image/processed%2FTrip%2Fa.jpg/proposals/reject", `{"categories": ["Dogs"]}`, http.StatusOK},
+		{"/image/processed%2FTrip%2Fa.jpg/proposals/accept", "", http.StatusOK},
+		{"/image/processed%2FTrip%2Fa.jpg/proposals/accept", `{"categories": ["Mice"]}`, http.StatusBadRequest},
+		{"/image/processed%2Fb.jpg/proposals/reject", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		if response := serve(router, http.MethodPost, test.url, test.body); response.Code != test.expected {
+			format, args := testutil.FormatTestError(
+				"Expected the proposals of the image of the path to be reviewed.",
+				map[string]interface{}{
+					"url":      test.url,
+					"body":     test.body,
+					"expected": test.expected,
+					"got":      response.Code,
+					"response": response.Body.String(),
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	image, _ := db.GetImage("processed/Trip/a.jpg")
+	if !reflect.DeepEqual(image.AssignedCategories, []string{"Cats", "Birds"}) ||
+		!reflect.DeepEqual(image.RejectedCategories, []string{"Dogs"}) {
+		format, args := testutil.FormatTestError(
+			"Expected the reviews to be applied to the image of the path.",
+			map[string]interface{}{
+				"image": image,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -6,68 +6,154 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"tagallery.com/api/boltdb"
 	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
 	"tagallery.com/api/logger"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
 	"tagallery.com/api/mongodb"
+	"tagallery.com/api/proposal"
+	"tagallery.com/api/store"
+	"tagallery.com/api/watcher"
 )
 
-// StartServer loads the config, sets up the logger, establishes a connection to the db and starts the router.
+// StartServer loads the config, sets up the logger, opens the configured storage backend and starts the router.
 func StartServer() {
 	config := config.Load()
 
 	log := logger.Setup(config.Debug)
 
-	if client, err := mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, config.DatabaseHost)); err != nil {
-		log.Fatalw("Unable to connect to database.", "error", err)
+	s, closeStore, err := openStore(config)
+	if err != nil {
+		log.Fatalw("Unable to open the storage.", "storage", config.Storage, "error", err)
+	}
+	defer closeStore()
+
+	if config.Debug {
+		gin.SetMode(gin.DebugMode)
 	} else {
-		defer func() {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	defer log.Sync()
+
+	// Processing that was interrupted by a crash is completed or rolled back before the folders are indexed.
+	if images, err := controller.New(s).Recover(); err != nil {
+		log.Warnw("Unable to recover the processing of images.", "error", err)
+	} else if len(images) > 0 {
+		log.Infow("Recovered the processing of images.", "recovered", len(images))
+	}
+
+	worker := startProposalWorker(s, config)
+
+	if config.Watch {
+		startWatcher(s, config, worker)
+	}
+
+	// Index the image folders in the background, the API is usable in the meantime.
+	go func() {
+		if images, err := controller.New(s).Rescan(); err != nil {
+			log.Warnw("Unable to index the image folders.", "error", err)
+		} else {
+			log.Infow("Image folders indexed successfully.", "changed", len(images))
+		}
+	}()
+
+	r := ConfigureRouter(s)
+	r.Run(fmt.Sprintf(":%d", config.Port))
+}
+
+// openStore opens the configured storage backend. The returned function closes it.
+func openStore(config *config.Configuration) (store.Store, func(), error) {
+	switch config.Storage {
+	case "memory":
+		logger.Logger().Infow("Using the in-memory storage. Nothing will be persisted.")
+		mem := memory.NewStore()
+		return store.Store{Images: mem, Categories: mem}, func() {}, nil
+	case "bolt":
+		db, err := boltdb.Open(config.DatabaseFile)
+		if err != nil {
+			return store.Store{}, nil, err
+		}
+
+		return store.Store{Images: db, Categories: db}, func() { db.Close() }, nil
+	case "mongodb":
+		client, err := mongodb.Connect(context.Background(), fmt.Sprintf(`mongodb://%s`, config.DatabaseHost))
+		if err != nil {
+			return store.Store{}, nil, err
+		}
+
+		closeClient := func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-			if err = client.Disconnect(ctx); err != nil {
-				log.Fatalw("Unable to disconnect from database.", "error", err)
+			if err := client.Disconnect(ctx); err != nil {
+				logger.Logger().Warnw("Unable to disconnect from database.", "error", err)
 			}
-		}()
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		if err := client.Ping(ctx, readpref.Primary()); err != nil {
-			log.Fatalw("Ping to database not successful.", "error", err)
+			closeClient()
+			return store.Store{}, nil, fmt.Errorf("ping to database not successful: %w", err)
 		}
-		if err := setupDatabase(ctx, client); err != nil {
-			log.Fatalw("Unable to setup the database.", "error", err)
+		if err := mongodb.Setup(ctx, client.Database(config.Database)); err != nil {
+			closeClient()
+			return store.Store{}, nil, fmt.Errorf("unable to setup the database: %w", err)
 		}
 
+		db := mongodb.NewStore(client.Database(config.Database))
+		return store.Store{Images: db, Categories: db}, closeClient, nil
+	default:
+		return store.Store{}, nil, fmt.Errorf("unknown storage backend %q", config.Storage)
 	}
+}
 
-	if config.Debug {
-		gin.SetMode(gin.DebugMode)
-	} else {
-		gin.SetMode(gin.ReleaseMode)
+// startProposalWorker starts the background worker that proposes categories with the configured proposer.
+// It returns nil if no worker was started.
+func startProposalWorker(s store.Store, config *config.Configuration) *proposal.Worker {
+	var proposer proposal.Proposer
+
+	if config.ProposalInterval <= 0 {
+		return nil
 	}
 
-	defer log.Sync()
+	switch config.Proposer {
+	case "none":
+		return nil
+	case "knn":
+		proposer = proposal.NewKNN(s.Images, config.ProposalNeighbours)
+	case "http":
+		proposer = proposal.NewHTTPClassifier(proposal.ClassifierOptions{
+			URL:       config.ClassifierURL,
+			Timeout:   config.ClassifierTimeout,
+			Retries:   config.ClassifierRetries,
+			BatchSize: config.ClassifierBatchSize,
+			SendData:  config.ClassifierSendData,
+		})
+	default:
+		logger.Logger().Fatalw("Unknown proposer.", "proposer", config.Proposer)
+	}
 
-	r := ConfigureRouter()
-	r.Run(fmt.Sprintf(":%d", config.Port))
+	worker := proposal.NewWorker(s, proposer, config.ProposalMinScore, config.ProposalMax)
+	go worker.Run(context.Background(), config.ProposalInterval)
+
+	return worker
 }
 
-// setupDatabase creates a unique index on the category name.
-func setupDatabase(ctx context.Context, client *mongo.Client) error {
-	categoryCollection := client.Database(config.Get().Database).Collection("category")
-
-	// Creating MongoDb indexes is an idempotent operation.
-	// Therefore we don't have to check if the index already exists.
-	_, err := categoryCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "name", Value: 1}},
-		Options: options.Index().SetName("name_unique").SetUnique(true).SetCollation(&options.Collation{
-			Locale:   "en",
-			Strength: 2,
-		}),
+// startWatcher starts watching the image folders, new images trigger the proposal worker.
+func startWatcher(s store.Store, config *config.Configuration, worker *proposal.Worker) {
+	w := watcher.New(controller.New(s), config.WatchDelay, func(images []model.Image) {
+		if worker != nil {
+			worker.Trigger()
+		}
 	})
 
-	return err
+	go func() {
+		if err := w.Run(context.Background()); err != nil {
+			logger.Logger().Warnw("Unable to watch the image folders.", "error", err)
+		}
+	}()
 }
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/util"
+)
+
+// ErrInvalidParent indicates a parent category that doesn't exist, or that is the category itself
+// or one of its descendants, which would create a cycle.
+var ErrInvalidParent = errors.New("invalid parent category")
+
+// CheckParent checks the parent of a category before it is upserted. The id is the one of the upserted category,
+// or empty for a new category. Categories without parent are always valid.
+func CheckParent(categories []model.Category, id string, parentID *string) error {
+	if parentID == nil || *parentID == "" {
+		return nil
+	}
+
+	parents := map[string]*string{}
+	for _, category := range categories {
+		if category.ID != nil {
+			parents[*category.ID] = category.ParentID
+		}
+	}
+
+	if _, ok := parents[*parentID]; !ok {
+		return fmt.Errorf("%w: the parent %s doesn't exist", ErrInvalidParent, *parentID)
+	}
+
+	// Walk up to the root, the visited ids guard against cycles that exist already.
+	visited := map[string]bool{}
+	for current := parentID; current != nil && *current != "" && !visited[*current]; current = parents[*current] {
+		if *current == id {
+			return fmt.Errorf("%w: the category would become its own ancestor", ErrInvalidParent)
+		}
+		visited[*current] = true
+	}
+
+	return nil
+}
+
+// Descendants returns the descendants of a category, children before their own children.
+func Descendants(categories []model.Category, id string) []model.Category {
+	descendants := []model.Category{}
+	visited := map[string]bool{id: true}
+
+	for queue := []string{id}; len(queue) > 0; queue = queue[1:] {
+		for _, category := range categories {
+			if category.ID != nil && category.ParentID != nil && *category.ParentID == queue[0] && !visited[*category.ID] {
+				visited[*category.ID] = true
+				descendants = append(descendants, category)
+				queue = append(queue, *category.ID)
+			}
+		}
+	}
+
+	return descendants
+}
+
+// NormalizeAliases trims the aliases of a category and removes empty aliases, aliases equal to the name
+// and duplicates, all compared case insensitive. A category without aliases has nil aliases.
+func NormalizeAliases(category *model.Category) {
+	var aliases []string
+	for _, alias := range category.Aliases {
+		alias = strings.TrimSpace(alias)
+		if alias != "" && !strings.EqualFold(alias, category.Name) && !util.ContainsString(aliases, alias, false) {
+			aliases = append(aliases, alias)
+		}
+	}
+	category.Aliases = aliases
+}
+
+// CheckNames checks that the name and the aliases of a category are unique across the names and aliases of all
+// other categories, compared case insensitive. The id is the one of the upserted category, or empty for a new one.
+func CheckNames(categories []model.Category, id string, category model.Category) error {
+	for _, other := range categories {
+		if other.ID != nil && *other.ID == id {
+			continue
+		}
+		for _, name := range category.Names() {
+			if util.ContainsString(other.Names(), name, false) {
+				return fmt.Errorf("%w: %s", ErrDuplicateName, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveCategory returns the name of the category whose name or alias matches a name case insensitively,
+// or an empty string. Names take precedence over aliases.
+func ResolveCategory(categories []model.Category, name string) string {
+	for _, category := range categories {
+		if strings.EqualFold(category.Name, name) {
+			return category.Name
+		}
+	}
+	for _, category := range categories {
+		if util.ContainsString(category.Aliases, name, false) {
+			return category.Name
+		}
+	}
+	return ""
+}
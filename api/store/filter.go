@@ -0,0 +1,179 @@
+package store
+
+import (
+	"sort"
+	"strings"
+
+	"tagallery.com/api/logger"
+	"tagallery.com/api/model"
+	"tagallery.com/api/util"
+)
+
+// MatchImage reports whether an image satisfies the category filter of ImageStore.GetImages.
+// Category names are compared case sensitive, like a MongoDB query without collation does.
+// Unprocessed images never match.
+func MatchImage(image model.Image, categories *model.CategoryMap) bool {
+	if image.Unprocessed {
+		return false
+	}
+
+	// Uncategorized images only
+	if categories == nil {
+		return len(image.AssignedCategories) == 0 &&
+			len(image.ProposedCategories) == 0 &&
+			(image.StarredCategory == nil || *image.StarredCategory == "")
+	}
+
+	if categories.Assigned != nil && !containsAll(image.AssignedCategories, categories.Assigned, categories) {
+		return false
+	}
+
+	if categories.Proposed != nil && !containsAll(model.ProposalNames(image.ProposedCategories), categories.Proposed, categories) {
+		return false
+	}
+
+	if categories.Starred != nil &&
+		(image.StarredCategory == nil || *image.StarredCategory != *categories.Starred) {
+		return false
+	}
+
+	return true
+}
+
+// MatchMetadata reports whether the metadata of an image satisfies the metadata filters of the options.
+// Images without metadata only match the filter for images without location.
+func MatchMetadata(image model.Image, opts model.ImageOptions) bool {
+	m := image.Metadata
+	if m == nil {
+		m = &model.Metadata{}
+	}
+
+	if opts.TakenAfter != nil && (m.TakenAt == nil || m.TakenAt.Before(*opts.TakenAfter)) {
+		return false
+	}
+	if opts.TakenBefore != nil && (m.TakenAt == nil || m.TakenAt.After(*opts.TakenBefore)) {
+		return false
+	}
+	if opts.Camera != nil && !strings.EqualFold(m.Model, *opts.Camera) {
+		return false
+	}
+	if opts.HasGPS != nil && (m.GPS != nil) != *opts.HasGPS {
+		return false
+	}
+
+	return true
+}
+
+// FilterImages applies the filter, sorting and pagination semantics of ImageStore.GetImages
+// to a list of images sorted in insertion order.
+func FilterImages(images []model.Image, opts model.ImageOptions, categories *model.CategoryMap) []model.Image {
+	type match struct {
+		image      model.Image
+		position   int
+		confidence float64
+	}
+
+	result := []model.Image{}
+	matches := []match{}
+
+	for k, image := range images {
+		confidence := Confidence(image, categories)
+		if MatchImage(image, categories) && MatchMetadata(image, opts) &&
+			(opts.MinConfidence == nil || confidence >= *opts.MinConfidence) {
+			matches = append(matches, match{image, k, confidence})
+		}
+	}
+
+	if opts.SortByConfidence {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].confidence > matches[j].confidence
+		})
+	}
+
+	start := 0
+	if opts.LastImage != nil {
+		found := false
+		for k, image := range images {
+			if image.File != *opts.LastImage {
+				continue
+			}
+
+			// Continue after the position the last image has, or would have, in the sorted matches.
+			last := match{image, k, Confidence(image, categories)}
+			start = sort.Search(len(matches), func(i int) bool {
+				if opts.SortByConfidence && matches[i].confidence != last.confidence {
+					return matches[i].confidence < last.confidence
+				}
+				return matches[i].position > last.position
+			})
+			found = true
+			break
+		}
+
+		if !found {
+			logger.Logger().Warnw("Unable to find lastImage in the store.", "lastImage", *opts.LastImage)
+		}
+	}
+
+	for _, match := range matches[start:] {
+		if opts.Count != nil && *opts.Count > 0 && len(result) >= *opts.Count {
+			break
+		}
+		result = append(result, match.image)
+	}
+
+	return result
+}
+
+// Confidence returns the highest score of the proposals of an image.
+// If the category filter names proposed categories then only their proposals (and the ones of their descendants)
+// are considered. Images without proposals have a confidence of 0.
+func Confidence(image model.Image, categories *model.CategoryMap) float64 {
+	confidence := 0.0
+
+	var proposed []string
+	if categories != nil {
+		for _, category := range categories.Proposed {
+			proposed = append(proposed, categories.Names(category)...)
+		}
+	}
+
+	for _, proposal := range image.ProposedCategories {
+		if len(proposed) > 0 && !util.ContainsString(proposed, proposal.Category, true) {
+			continue
+		}
+		if proposal.Score > confidence {
+			confidence = proposal.Score
+		}
+	}
+
+	return confidence
+}
+
+// containsAll checks if a list contains all of the given values, or one of their descendants in the filter.
+// An empty list of values matches every non-empty list, which mirrors the "any category" filter
+// of ImageStore.GetImages.
+func containsAll(list []string, values []string, categories *model.CategoryMap) bool {
+	if len(values) == 0 {
+		return len(list) > 0
+	}
+
+	for _, v := range values {
+		if !containsAny(list, categories.Names(v)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsAny checks if a list contains one of the given values.
+func containsAny(list []string, values []string) bool {
+	for _, v := range values {
+		if util.ContainsString(list, v, true) {
+			return true
+		}
+	}
+
+	return false
+}
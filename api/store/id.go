@@ -0,0 +1,40 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+)
+
+var idCounter uint32
+
+// processUnique is generated once per process, like the random value of MongoDB ObjectIDs.
+var processUnique = func() [4]byte {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return b
+}()
+
+// NewID generates a new unique id for backends that don't generate their own.
+// The ids share the format of MongoDB ObjectIDs (24 hex characters, prefixed by the creation time),
+// so that they sort by creation and can be moved between the different backends.
+func NewID() string {
+	var id [12]byte
+
+	binary.BigEndian.PutUint32(id[0:4], uint32(time.Now().Unix()))
+	copy(id[4:8], processUnique[:])
+	binary.BigEndian.PutUint32(id[8:12], atomic.AddUint32(&idCounter, 1))
+
+	return hex.EncodeToString(id[:])
+}
+
+// ValidID checks if a string has the format of an id generated by NewID().
+func ValidID(id string) bool {
+	if len(id) != 24 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,71 @@
+// Package store defines the storage-agnostic interfaces the API uses to persist images and categories.
+package store
+
+import (
+	"errors"
+
+	"tagallery.com/api/model"
+)
+
+// ErrInvalidID indicates that a provided id has not the format expected by the storage backend.
+var ErrInvalidID = errors.New("invalid id")
+
+// ErrNotFound indicates that the requested entity does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidCursor indicates a cursor that wasn't returned by the store.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrDuplicateName indicates that a category with the same name (compared case insensitive) already exists.
+var ErrDuplicateName = errors.New("a category with this name already exists")
+
+// ImageStore persists images.
+type ImageStore interface {
+	// GetImages queries the store for images.
+	// If count is set then no more then {ops.count} images will be returned.
+	// A *CategoryMap may be passed to filter only images that are in all of these categories.
+	// If categories == nil then instead of (auto)-categorized images,
+	// only images that have no assigned category will be returned.
+	// With lastImage you get only images after this one. Used for pagination.
+	// MinConfidence and SortByConfidence filter and sort by the score of the proposals, see Confidence().
+	// Unprocessed images are never returned.
+	GetImages(opts model.ImageOptions, categories *model.CategoryMap) ([]model.Image, error)
+	// GetUnprocessedImages returns the unprocessed images in insertion order. At most {opts.Count} images
+	// are returned if it's set. The page starts after the position {opts.Cursor}, which is the cursor
+	// returned with the previous page, or after {opts.LastImage}. Cursors stay valid when their image
+	// is deleted or processed. The returned cursor is empty if there are no more images.
+	GetUnprocessedImages(opts model.ImageOptions) ([]model.Image, string, error)
+	// AllImages returns all images, including the unprocessed ones, in insertion order.
+	AllImages() ([]model.Image, error)
+	// GetImage returns the image of a file or ErrNotFound.
+	GetImage(file string) (*model.Image, error)
+	// GetImagesByHash returns all images with the given content hash.
+	GetImagesByHash(hash string) ([]model.Image, error)
+	// UpsertImage inserts or updates an existing image. Images are identified by their file.
+	UpsertImage(image model.Image) error
+	// UpdateImage atomically applies {update} to the stored image of a file and returns the updated image.
+	// It returns ErrNotFound for unknown files. An error of {update} aborts the update and is returned.
+	// {update} may be called more than once if the image is changed concurrently. The file can't be changed.
+	UpdateImage(file string, update func(image *model.Image) error) (*model.Image, error)
+	// DeleteImage deletes the image of a file. Deleting an unknown image is not an error.
+	DeleteImage(file string) error
+}
+
+// CategoryStore persists categories.
+type CategoryStore interface {
+	// QueryCategories returns all categories.
+	QueryCategories() ([]model.Category, error)
+	// UpsertCategory inserts a category or updates it.
+	// If the provided category has an id, then the entire category is updated.
+	// If the id is missing, then the name is taken as an identifier and everything else is updated.
+	// The name is compared case insensitive and must be unique.
+	UpsertCategory(category model.Category) (*model.Category, error)
+	// DeleteCategory deletes a category.
+	DeleteCategory(id string) error
+}
+
+// Store bundles the stores of all entities.
+type Store struct {
+	Images     ImageStore
+	Categories CategoryStore
+}
@@ -0,0 +1,68 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/proposal"
+)
+
+// FakeClassifier is a local stand-in for an external classifier that speaks the protocol of proposal.HTTPClassifier.
+// It proposes every requested category whose name is part of the file name of an image, with a score of 0.9.
+type FakeClassifier struct {
+	// Failures is the number of requests that fail with 503 before the classifier starts to respond.
+	Failures int
+
+	mu       sync.Mutex
+	requests []proposal.ClassifierRequest
+}
+
+// Requests returns all requests received so far.
+func (c *FakeClassifier) Requests() []proposal.ClassifierRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]proposal.ClassifierRequest{}, c.requests...)
+}
+
+// ServeHTTP answers a proposal.ClassifierRequest.
+func (c *FakeClassifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request proposal.ClassifierRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, request)
+	fail := c.Failures > 0
+	if fail {
+		c.Failures--
+	}
+	c.mu.Unlock()
+
+	if fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	response := proposal.ClassifierResponse{Model: "fake", Version: "1", Results: []proposal.ClassifierResult{}}
+	for _, image := range request.Images {
+		result := proposal.ClassifierResult{File: image.File, Proposals: []model.Proposal{}}
+		name := strings.ToLower(filepath.Base(image.File))
+		for _, category := range request.Categories {
+			if strings.Contains(name, strings.ToLower(category)) {
+				result.Proposals = append(result.Proposals, model.Proposal{Category: category, Score: 0.9})
+			}
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -6,6 +6,9 @@ import (
 	"path/filepath"
 )
 
+// EmptyFileHash is the SHA-256 hash of an empty file, e. g. one created by TouchFile().
+const EmptyFileHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
 // FileTree represents a level of a directory structure.
 type FileTree struct {
 	Dirs  map[string]*FileTree
@@ -0,0 +1,827 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/util"
+)
+
+// StoreFactory creates a new and empty store for a single test.
+type StoreFactory func(t *testing.T) store.Store
+
+// RunStoreTests runs the behavioural tests every storage backend has to pass.
+// The tests describe the semantics of the MongoDB store, which all other backends mimic.
+func RunStoreTests(t *testing.T, newStore StoreFactory) {
+	t.Run("GetImages", func(t *testing.T) { testGetImages(t, newStore(t)) })
+	t.Run("UpsertImage", func(t *testing.T) { testUpsertImage(t, newStore(t)) })
+	t.Run("GetUnprocessedImages", func(t *testing.T) { testGetUnprocessedImages(t, newStore(t)) })
+	t.Run("AllImages", func(t *testing.T) { testAllImages(t, newStore(t)) })
+	t.Run("UpdateImage", func(t *testing.T) { testUpdateImage(t, newStore(t)) })
+	t.Run("GetImage", func(t *testing.T) { testGetImage(t, newStore(t)) })
+	t.Run("GetImagesByHash", func(t *testing.T) { testGetImagesByHash(t, newStore(t)) })
+	t.Run("DeleteImage", func(t *testing.T) { testDeleteImage(t, newStore(t)) })
+	t.Run("UpsertCategory", func(t *testing.T) { testUpsertCategory(t, newStore(t)) })
+	t.Run("QueryCategories", func(t *testing.T) { testQueryCategories(t, newStore(t)) })
+	t.Run("DeleteCategory", func(t *testing.T) { testDeleteCategory(t, newStore(t)) })
+	t.Run("CategoryParent", func(t *testing.T) { testCategoryParent(t, newStore(t)) })
+	t.Run("CategoryAliases", func(t *testing.T) { testCategoryAliases(t, newStore(t)) })
+}
+
+var (
+	storeTakenAt1 = time.Date(2019, 7, 14, 8, 31, 0, 0, time.UTC)
+	storeTakenAt2 = time.Date(2020, 1, 2, 2, 4, 5, 0, time.UTC)
+)
+
+var storeImageFixtures = []model.Image{
+	{File: "test1.jpg"},
+	{File: "test2.jpg", AssignedCategories: []string{"Category 1", "Category 2"}, Metadata: &model.Metadata{
+		TakenAt: &storeTakenAt1,
+		Model:   "Canon EOS 5D",
+		GPS:     &model.GPS{Latitude: 51.5, Longitude: -7.26},
+	}},
+	{File: "test3.jpg", ProposedCategories: []model.Proposal{{Category: "Category 2", Score: 0.6, Source: "knn"}}},
+	{File: "test4.jpg", StarredCategory: util.StringPtr("Category 1")},
+	{File: "test5.jpg", AssignedCategories: []string{"Category 2"}, ProposedCategories: []model.Proposal{{Category: "Category 1", Score: 0.4}, {Category: "Category 3", Score: 0.8}}, Metadata: &model.Metadata{
+		TakenAt: &storeTakenAt2,
+		Model:   "Pixel 4",
+		Width:   4032,
+		Height:  3024,
+	}},
+	{File: "test6.jpg", ProposedCategories: []model.Proposal{{Category: "Category 1", Score: 0.9}}, StarredCategory: util.StringPtr("Category 2")},
+}
+
+// createStoreImageFixtures inserts the image fixtures into the store.
+func createStoreImageFixtures(t *testing.T, db store.Store) {
+	for _, image := range storeImageFixtures {
+		if err := db.Images.UpsertImage(image); err != nil {
+			format, args := FormatTestError(
+				"Unable to create image fixtures in the store.",
+				map[string]interface{}{
+					"error": err,
+				})
+			t.Fatalf(format, args...)
+		}
+	}
+}
+
+func testGetImages(t *testing.T, db store.Store) {
+	createStoreImageFixtures(t, db)
+
+	tests := []struct {
+		desc       string
+		opts       model.ImageOptions
+		categories *model.CategoryMap
+		expected   []model.Image
+	}{
+		{
+			desc:       "all images",
+			opts:       model.ImageOptions{Count: util.IntPtr(10)},
+			categories: &model.CategoryMap{},
+			expected:   storeImageFixtures,
+		},
+		{
+			desc:       "limited by count",
+			opts:       model.ImageOptions{Count: util.IntPtr(3)},
+			categories: &model.CategoryMap{},
+			expected:   storeImageFixtures[0:3],
+		},
+		{
+			desc: "after lastImage",
+			opts: model.ImageOptions{
+				Count:     util.IntPtr(10),
+				LastImage: util.StringPtr(storeImageFixtures[2].File),
+			},
+			categories: &model.CategoryMap{},
+			expected:   storeImageFixtures[3:6],
+		},
+		{
+			desc:     "uncategorized",
+			opts:     model.ImageOptions{Count: util.IntPtr(10)},
+			expected: []model.Image{storeImageFixtures[0]},
+		},
+		{
+			desc:       "assigned category",
+			opts:       model.ImageOptions{Count: util.IntPtr(10)},
+			categories: &model.CategoryMap{Assigned: []string{"Category 2"}},
+			expected:   []model.Image{storeImageFixtures[1], storeImageFixtures[4]},
+		},
+		{
+			desc: "assigned category or descendant",
+			opts: model.ImageOptions{Count: util.IntPtr(10)},
+			categories: &model.CategoryMap{
+				Assigned:    []string{"Category 3", "Category 1"},
+				Descendants: map[string][]string{"Category 3": {"Category 4", "Category 2"}},
+			},
+			expected: []model.Image{storeImageFixtures[1]},
+		},
+		{
+			desc: "proposed category or descendant",
+			opts: model.ImageOptions{Count: util.IntPtr(10), SortByConfidence: true},
+			categories: &model.CategoryMap{
+				Proposed:    []string{"Category 0"},
+				Descendants: map[string][]string{"Category 0": {"Category 1"}},
+			},
+			expected: []model.Image{storeImageFixtures[5], storeImageFixtures[4]},
+		},
+		{
+			desc:       "any assigned category",
+			opts:       model.ImageOptions{Count: util.IntPtr(10)},
+			categories: &model.CategoryMap{Assigned: []string{}},
+			expected:   []model.Image{storeImageFixtures[1], storeImageFixtures[4]},
+		},
+		{
+			desc:       "proposed category",
+			opts:       model.ImageOptions{Count: util.IntPtr(10)},
+			categories: &model.CategoryMap{Proposed: []string{"Category 1"}},
+			expected:   []model.Image{storeImageFixtures[4], storeImageFixtures[5]},
+		},
+		{
+			desc:       "any proposed category",
+			opts:       model.ImageOptions{Count: util.IntPtr(10)},
+			categories: &model.CategoryMap{Proposed: []string{}},
+			expected:   []model.Image{storeImageFixtures[2], storeImageFixtures[4], storeImageFixtures[5]},
+		},
+		{
+			desc:       "starred category",
+			opts:       model.ImageOptions{Count: util.IntPtr(10)},
+			categories: &model.CategoryMap{Starred: util.StringPtr("Category 1")},
+			expected:   []model.Image{storeImageFixtures[3]},
+		},
+		{
+			desc:       "minimum confidence",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), MinConfidence: util.Float64Ptr(0.7)},
+			categories: &model.CategoryMap{Proposed: []string{}},
+			expected:   []model.Image{storeImageFixtures[4], storeImageFixtures[5]},
+		},
+		{
+			desc:       "minimum confidence of a proposed category",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), MinConfidence: util.Float64Ptr(0.7)},
+			categories: &model.CategoryMap{Proposed: []string{"Category 1"}},
+			expected:   []model.Image{storeImageFixtures[5]},
+		},
+		{
+			desc:       "sorted by confidence",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), SortByConfidence: true},
+			categories: &model.CategoryMap{Proposed: []string{}},
+			expected:   []model.Image{storeImageFixtures[5], storeImageFixtures[4], storeImageFixtures[2]},
+		},
+		{
+			desc:       "sorted by confidence of a proposed category",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), SortByConfidence: true},
+			categories: &model.CategoryMap{Proposed: []string{"Category 1"}},
+			expected:   []model.Image{storeImageFixtures[5], storeImageFixtures[4]},
+		},
+		{
+			desc: "sorted by confidence after lastImage",
+			opts: model.ImageOptions{
+				Count:            util.IntPtr(1),
+				LastImage:        util.StringPtr(storeImageFixtures[5].File),
+				SortByConfidence: true,
+			},
+			categories: &model.CategoryMap{Proposed: []string{}},
+			expected:   []model.Image{storeImageFixtures[4]},
+		},
+		{
+			desc: "taken between",
+			opts: model.ImageOptions{
+				Count:       util.IntPtr(10),
+				TakenAfter:  util.TimePtr(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)),
+				TakenBefore: util.TimePtr(time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)),
+			},
+			categories: &model.CategoryMap{},
+			expected:   []model.Image{storeImageFixtures[1]},
+		},
+		{
+			desc:       "taken after, inclusive",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), TakenAfter: &storeTakenAt1},
+			categories: &model.CategoryMap{},
+			expected:   []model.Image{storeImageFixtures[1], storeImageFixtures[4]},
+		},
+		{
+			desc:       "camera model",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), Camera: util.StringPtr("pixel 4")},
+			categories: &model.CategoryMap{},
+			expected:   []model.Image{storeImageFixtures[4]},
+		},
+		{
+			desc:       "with location",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), HasGPS: util.BoolPtr(true)},
+			categories: &model.CategoryMap{},
+			expected:   []model.Image{storeImageFixtures[1]},
+		},
+		{
+			desc:       "without location",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), HasGPS: util.BoolPtr(false)},
+			categories: &model.CategoryMap{Assigned: []string{}},
+			expected:   []model.Image{storeImageFixtures[4]},
+		},
+		{
+			desc:       "camera model sorted by confidence",
+			opts:       model.ImageOptions{Count: util.IntPtr(10), Camera: util.StringPtr("Pixel 4"), SortByConfidence: true},
+			categories: &model.CategoryMap{Proposed: []string{}},
+			expected:   []model.Image{storeImageFixtures[4]},
+		},
+	}
+
+	for _, test := range tests {
+		images, err := db.Images.GetImages(test.opts, test.categories)
+
+		if err != nil || !reflect.DeepEqual(images, test.expected) {
+			format, args := FormatTestError(
+				"Expected images from the store to equal the fixture.",
+				map[string]interface{}{
+					"filter":   test.desc,
+					"error":    err,
+					"images":   images,
+					"expected": test.expected,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
+
+func testUpsertImage(t *testing.T, db store.Store) {
+	image := model.Image{File: "test"}
+	if err := db.Images.UpsertImage(image); err != nil {
+		format, args := FormatTestError(
+			"Expected image to be inserted.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	image.AssignedCategories = []string{"Category 1"}
+	if err := db.Images.UpsertImage(image); err != nil {
+		format, args := FormatTestError(
+			"Expected image to be updated.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	images, _ := db.Images.GetImages(model.ImageOptions{}, &model.CategoryMap{})
+	if !reflect.DeepEqual(images, []model.Image{image}) {
+		format, args := FormatTestError(
+			"Expected the existing image to be replaced.",
+			map[string]interface{}{
+				"expected": []model.Image{image},
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testAllImages(t *testing.T, db store.Store) {
+	unprocessed := model.Image{File: "unprocessed.jpg", Unprocessed: true}
+
+	createStoreImageFixtures(t, db)
+	if err := db.Images.UpsertImage(unprocessed); err != nil {
+		t.Fatal("Unable to create the unprocessed image.", err)
+	}
+
+	expected := append(append([]model.Image{}, storeImageFixtures...), unprocessed)
+	images, err := db.Images.AllImages()
+	if err != nil || !reflect.DeepEqual(images, expected) {
+		format, args := FormatTestError(
+			"Expected all images including the unprocessed one.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+
+	for _, categories := range []*model.CategoryMap{nil, {}} {
+		images, err := db.Images.GetImages(model.ImageOptions{}, categories)
+		for _, image := range images {
+			if err != nil || image.Unprocessed {
+				format, args := FormatTestError(
+					"Expected unprocessed images not to be returned by GetImages().",
+					map[string]interface{}{
+						"error":  err,
+						"images": images,
+					})
+				t.Errorf(format, args...)
+			}
+		}
+	}
+}
+
+func testGetUnprocessedImages(t *testing.T, db store.Store) {
+	images := []model.Image{
+		{File: "unprocessed/1.jpg", Unprocessed: true},
+		{File: "processed/2.jpg"},
+		{File: "unprocessed/3.jpg", Unprocessed: true},
+		{File: "unprocessed/4.jpg", Unprocessed: true},
+		{File: "unprocessed/5.jpg", Unprocessed: true},
+	}
+	for _, image := range images {
+		if err := db.Images.UpsertImage(image); err != nil {
+			t.Fatal("Unable to create the images.", err)
+		}
+	}
+
+	// Every page ends with a cursor except the last one.
+	pages := [][]model.Image{}
+	var cursor *string
+	for k := 0; k < 5; k++ {
+		page, next, err := db.Images.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(2), Cursor: cursor})
+		if err != nil {
+			t.Fatal("Unable to get the unprocessed images.", err)
+		}
+		pages = append(pages, page)
+		if next == "" {
+			break
+		}
+		cursor = &next
+	}
+
+	expected := [][]model.Image{{images[0], images[2]}, {images[3], images[4]}}
+	if !reflect.DeepEqual(pages, expected) {
+		format, args := FormatTestError(
+			"Expected the unprocessed images to be paginated with cursors.",
+			map[string]interface{}{
+				"expected": expected,
+				"got":      pages,
+			})
+		t.Errorf(format, args...)
+	}
+
+	page, next, err := db.Images.GetUnprocessedImages(model.ImageOptions{LastImage: util.StringPtr(images[2].File)})
+	if err != nil || next != "" || !reflect.DeepEqual(page, images[3:5]) {
+		format, args := FormatTestError(
+			"Expected the unprocessed images after lastImage.",
+			map[string]interface{}{
+				"error": err,
+				"got":   page,
+			})
+		t.Errorf(format, args...)
+	}
+
+	// Cursors stay valid if their image is deleted or processed.
+	_, cursor1, _ := db.Images.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(1)})
+	_, cursor3, _ := db.Images.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(1), Cursor: &cursor1})
+	db.Images.DeleteImage(images[0].File)
+	processed := images[2]
+	processed.Unprocessed = false
+	db.Images.UpsertImage(processed)
+
+	page1, _, err1 := db.Images.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(1), Cursor: &cursor1})
+	page3, _, err3 := db.Images.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(1), Cursor: &cursor3})
+	if err1 != nil || err3 != nil || !reflect.DeepEqual(page1, images[3:4]) || !reflect.DeepEqual(page3, images[3:4]) {
+		format, args := FormatTestError(
+			"Expected cursors of deleted and processed images to stay valid.",
+			map[string]interface{}{
+				"errors": []error{err1, err3},
+				"pages":  [][]model.Image{page1, page3},
+			})
+		t.Errorf(format, args...)
+	}
+
+	located := images[4]
+	located.Metadata = &model.Metadata{GPS: &model.GPS{Latitude: 1, Longitude: 2}}
+	db.Images.UpsertImage(located)
+	page, next, err = db.Images.GetUnprocessedImages(model.ImageOptions{Count: util.IntPtr(1), HasGPS: util.BoolPtr(true)})
+	if err != nil || next != "" || !reflect.DeepEqual(page, []model.Image{located}) {
+		format, args := FormatTestError(
+			"Expected the unprocessed images to be filtered by their metadata.",
+			map[string]interface{}{
+				"error": err,
+				"next":  next,
+				"got":   page,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, _, err := db.Images.GetUnprocessedImages(model.ImageOptions{Cursor: util.StringPtr("invalid")}); !errors.Is(err, store.ErrInvalidCursor) {
+		format, args := FormatTestError(
+			"Expected invalid cursors to be refused.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testUpdateImage(t *testing.T, db store.Store) {
+	createStoreImageFixtures(t, db)
+
+	expected := storeImageFixtures[0]
+	expected.AssignedCategories = []string{"Category 1"}
+	image, err := db.Images.UpdateImage(expected.File, func(image *model.Image) error {
+		image.AssignedCategories = append(image.AssignedCategories, "Category 1")
+		return nil
+	})
+	stored, _ := db.Images.GetImage(expected.File)
+	if err != nil || !reflect.DeepEqual(*image, expected) || !reflect.DeepEqual(*stored, expected) {
+		format, args := FormatTestError(
+			"Expected the updated image to be returned and stored.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      image,
+				"stored":   stored,
+			})
+		t.Errorf(format, args...)
+	}
+
+	errUpdate := errors.New("update failed")
+	_, err = db.Images.UpdateImage(expected.File, func(image *model.Image) error {
+		image.AssignedCategories = []string{}
+		return errUpdate
+	})
+	stored, _ = db.Images.GetImage(expected.File)
+	if !errors.Is(err, errUpdate) || !reflect.DeepEqual(*stored, expected) {
+		format, args := FormatTestError(
+			"Expected a failed update to be aborted.",
+			map[string]interface{}{
+				"error":  err,
+				"stored": stored,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, err := db.Images.UpdateImage("unknown.jpg", func(image *model.Image) error {
+		return nil
+	}); !errors.Is(err, store.ErrNotFound) {
+		format, args := FormatTestError(
+			"Expected unknown images to not be found.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	// Concurrent updates must not overwrite each other.
+	var wg sync.WaitGroup
+	for k := 0; k < 10; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			db.Images.UpdateImage(storeImageFixtures[1].File, func(image *model.Image) error {
+				image.RejectedCategories = append(image.RejectedCategories, fmt.Sprint(k))
+				return nil
+			})
+		}(k)
+	}
+	wg.Wait()
+
+	if stored, err := db.Images.GetImage(storeImageFixtures[1].File); err != nil || len(stored.RejectedCategories) != 10 {
+		format, args := FormatTestError(
+			"Expected all concurrent updates to be stored.",
+			map[string]interface{}{
+				"error":  err,
+				"stored": stored,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testGetImage(t *testing.T, db store.Store) {
+	createStoreImageFixtures(t, db)
+
+	image, err := db.Images.GetImage(storeImageFixtures[1].File)
+	if err != nil || !reflect.DeepEqual(*image, storeImageFixtures[1]) {
+		format, args := FormatTestError(
+			"Expected the image of the file to be returned.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": storeImageFixtures[1],
+				"got":      image,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, err := db.Images.GetImage("unknown.jpg"); !errors.Is(err, store.ErrNotFound) {
+		format, args := FormatTestError(
+			"Expected unknown images to not be found.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testGetImagesByHash(t *testing.T, db store.Store) {
+	images := []model.Image{
+		{File: "test1.jpg", Hash: "abc"},
+		{File: "test2.jpg", Hash: "def"},
+		{File: "test3.jpg", Hash: "abc", AssignedCategories: []string{"Category 1"}},
+	}
+	for _, image := range images {
+		if err := db.Images.UpsertImage(image); err != nil {
+			t.Fatal("Unable to create the images.", err)
+		}
+	}
+
+	expected := []model.Image{images[0], images[2]}
+	result, err := db.Images.GetImagesByHash("abc")
+	if err != nil || !reflect.DeepEqual(result, expected) {
+		format, args := FormatTestError(
+			"Expected all images with the hash to be returned.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      result,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testDeleteImage(t *testing.T, db store.Store) {
+	createStoreImageFixtures(t, db)
+
+	if err := db.Images.DeleteImage(storeImageFixtures[0].File); err != nil {
+		format, args := FormatTestError(
+			"Expected the image to be deleted.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	images, _ := db.Images.GetImages(model.ImageOptions{}, &model.CategoryMap{})
+	if !reflect.DeepEqual(images, storeImageFixtures[1:]) {
+		format, args := FormatTestError(
+			"Expected only the deleted image to be removed from the store.",
+			map[string]interface{}{
+				"expected": storeImageFixtures[1:],
+				"got":      images,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if err := db.Images.DeleteImage("unknown.jpg"); err != nil {
+		format, args := FormatTestError(
+			"Expected deleting an unknown image to succeed.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+var storeTestCategory = model.Category{
+	Name:        "Test Category",
+	Description: "This is a test category.",
+}
+
+func testUpsertCategory(t *testing.T, db store.Store) {
+	category, err := db.Categories.UpsertCategory(storeTestCategory)
+	if err != nil ||
+		category.ID == nil ||
+		category.Name != storeTestCategory.Name ||
+		category.Description != storeTestCategory.Description {
+		format, args := FormatTestError(
+			"Expected category to be inserted.",
+			map[string]interface{}{
+				"error": err,
+				"got":   category,
+			})
+		t.Fatalf(format, args...)
+	}
+
+	newCategory := model.Category{
+		ID:          category.ID,
+		Name:        "New category",
+		Description: "New category description",
+	}
+
+	updatedCategory, err := db.Categories.UpsertCategory(newCategory)
+	if err != nil ||
+		updatedCategory.Name != newCategory.Name ||
+		updatedCategory.Description != newCategory.Description {
+		format, args := FormatTestError(
+			"Expected same category to be updated.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": newCategory,
+				"got":      updatedCategory,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if _, err = db.Categories.UpsertCategory(model.Category{Name: "NEW CATEGORY"}); !errors.Is(err, store.ErrDuplicateName) {
+		format, args := FormatTestError(
+			"Expected category names to be unique regardless of their case.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	invalidObjectID := "123xzy"
+	invalidCategory := model.Category{
+		ID:          &invalidObjectID,
+		Name:        "Invalid category",
+		Description: "Invalid category description",
+	}
+	if _, err = db.Categories.UpsertCategory(invalidCategory); !errors.Is(err, store.ErrInvalidID) {
+		format, args := FormatTestError(
+			"Expected category with an invalid id to not be updated.",
+			map[string]interface{}{
+				"error":    err,
+				"category": invalidCategory,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testQueryCategories(t *testing.T, db store.Store) {
+	category, err := db.Categories.UpsertCategory(storeTestCategory)
+	if err != nil {
+		format, args := FormatTestError(
+			"Failed to create test category.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Fatalf(format, args...)
+	}
+
+	categories, err := db.Categories.QueryCategories()
+	if err != nil ||
+		!reflect.DeepEqual(categories, []model.Category{*category}) {
+		format, args := FormatTestError(
+			"Expected category to be inserted.",
+			map[string]interface{}{
+				"error": err,
+				"got":   categories,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testDeleteCategory(t *testing.T, db store.Store) {
+	category, err := db.Categories.UpsertCategory(storeTestCategory)
+	if err != nil {
+		format, args := FormatTestError(
+			"Failed to create test category.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Fatalf(format, args...)
+	}
+
+	if err := db.Categories.DeleteCategory(*category.ID); err != nil {
+		format, args := FormatTestError(
+			"Expected category to be deleted.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if categories, _ := db.Categories.QueryCategories(); len(categories) != 0 {
+		format, args := FormatTestError(
+			"Expected category to be removed from the store.",
+			map[string]interface{}{
+				"categories": categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if err := db.Categories.DeleteCategory("123xyz"); !errors.Is(err, store.ErrInvalidID) {
+		format, args := FormatTestError(
+			"Expected providing an invalid id to fail.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testCategoryParent(t *testing.T, db store.Store) {
+	parent, err := db.Categories.UpsertCategory(model.Category{Name: "Animals"})
+	if err != nil {
+		t.Fatal("Failed to create the parent category.", err)
+	}
+	child, err := db.Categories.UpsertCategory(model.Category{Name: "Cats", ParentID: parent.ID})
+	if err != nil {
+		format, args := FormatTestError(
+			"Expected a category with an existing parent to be inserted.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Fatalf(format, args...)
+	}
+	grandchild, err := db.Categories.UpsertCategory(model.Category{Name: "Kittens", ParentID: child.ID})
+	if err != nil {
+		t.Fatal("Failed to create the grandchild category.", err)
+	}
+
+	categories, err := db.Categories.QueryCategories()
+	if err != nil || len(categories) != 3 || categories[1].ParentID == nil || *categories[1].ParentID != *parent.ID {
+		format, args := FormatTestError(
+			"Expected the parent of the category to be stored.",
+			map[string]interface{}{
+				"error": err,
+				"got":   categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	tests := []struct {
+		desc     string
+		category model.Category
+	}{
+		{"missing parent", model.Category{Name: "Dogs", ParentID: util.StringPtr("5f1e9b3c0000000000000000")}},
+		{"own parent", model.Category{ID: parent.ID, Name: "Animals", ParentID: parent.ID}},
+		{"descendant as parent", model.Category{ID: parent.ID, Name: "Animals", ParentID: grandchild.ID}},
+		{"descendant as parent by name", model.Category{Name: "Animals", ParentID: child.ID}},
+	}
+
+	for _, test := range tests {
+		if _, err := db.Categories.UpsertCategory(test.category); !errors.Is(err, store.ErrInvalidParent) {
+			format, args := FormatTestError(
+				"Expected an invalid parent to be rejected.",
+				map[string]interface{}{
+					"test":  test.desc,
+					"error": err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	// Moving a category below another branch is fine.
+	if _, err := db.Categories.UpsertCategory(model.Category{ID: grandchild.ID, Name: "Kittens", ParentID: parent.ID}); err != nil {
+		format, args := FormatTestError(
+			"Expected the category to be moved to another parent.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func testCategoryAliases(t *testing.T, db store.Store) {
+	cat, err := db.Categories.UpsertCategory(model.Category{Name: "Cat", Aliases: []string{" Kitty ", "kitty", "", "CAT", "Feline"}})
+	if err != nil {
+		t.Fatal("Failed to create the category with aliases.", err)
+	}
+	if _, err := db.Categories.UpsertCategory(model.Category{Name: "Dog", Aliases: []string{"Puppy"}}); err != nil {
+		t.Fatal("Failed to create the second category with aliases.", err)
+	}
+
+	categories, err := db.Categories.QueryCategories()
+	expected := []string{"Kitty", "Feline"}
+	if err != nil || len(categories) != 2 || !reflect.DeepEqual(categories[0].Aliases, expected) {
+		format, args := FormatTestError(
+			"Expected the aliases to be trimmed and deduplicated.",
+			map[string]interface{}{
+				"error":    err,
+				"expected": expected,
+				"got":      categories,
+			})
+		t.Errorf(format, args...)
+	}
+
+	tests := []struct {
+		desc     string
+		category model.Category
+	}{
+		{"name as alias", model.Category{Name: "Mouse", Aliases: []string{"dog"}}},
+		{"alias as alias", model.Category{Name: "Mouse", Aliases: []string{"PUPPY"}}},
+		{"alias as name", model.Category{Name: "kitty"}},
+		{"alias of another category on update", model.Category{ID: cat.ID, Name: "Cat", Aliases: []string{"Puppy"}}},
+	}
+
+	for _, test := range tests {
+		if _, err := db.Categories.UpsertCategory(test.category); !errors.Is(err, store.ErrDuplicateName) {
+			format, args := FormatTestError(
+				"Expected a name or alias used by another category to be rejected.",
+				map[string]interface{}{
+					"test":  test.desc,
+					"error": err,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+
+	// A category can keep its own aliases and drop them.
+	if _, err := db.Categories.UpsertCategory(model.Category{ID: cat.ID, Name: "Cat", Aliases: []string{"kitty"}}); err != nil {
+		format, args := FormatTestError(
+			"Expected the aliases of the category to be updated.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+	if _, err := db.Categories.UpsertCategory(model.Category{Name: "Feline"}); err != nil {
+		format, args := FormatTestError(
+			"Expected a dropped alias to be free again.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,152 @@
+// Package thumbnail creates downscaled versions of images.
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tagallery.com/api/util"
+
+	// Register the gif decoder, thumbnails of gifs are encoded as png.
+	_ "image/gif"
+)
+
+// ErrUnsupportedFormat indicates that an image could not be decoded.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// ErrTooLarge indicates that an image has more pixels than allowed.
+var ErrTooLarge = errors.New("the image has too many pixels")
+
+// Decode decodes an image, unless it has more than {maxPixels} pixels. The dimensions are read from the header
+// first, so that small files of huge images (decompression bombs) are rejected without allocating their pixels.
+// A limit <= 0 disables the check.
+func Decode(r io.ReadSeeker, maxPixels int) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxPixels > 0 && int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return nil, "", fmt.Errorf("%w: %dx%d", ErrTooLarge, config.Width, config.Height)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(r)
+}
+
+// Resize downscales an image so that it fits into a size x size square while keeping its aspect ratio.
+// Every pixel of the thumbnail is the average of the pixels it covers in the original image.
+// Images that already fit are returned unchanged.
+func Resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if size <= 0 || (width <= size && height <= size) {
+		return img
+	}
+
+	newWidth, newHeight := size, size
+	if width > height {
+		newHeight = util.MaxInt(1, height*size/width)
+	} else {
+		newWidth = util.MaxInt(1, width*size/height)
+	}
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, newWidth, newHeight))
+
+	for y := 0; y < newHeight; y++ {
+		y0 := bounds.Min.Y + y*height/newHeight
+		y1 := util.MaxInt(y0+1, bounds.Min.Y+(y+1)*height/newHeight)
+
+		for x := 0; x < newWidth; x++ {
+			x0 := bounds.Min.X + x*width/newWidth
+			x1 := util.MaxInt(x0+1, bounds.Min.X+(x+1)*width/newWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			// The averaged colour is alpha-premultiplied, Set() converts it to the NRGBA model.
+			c := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+			thumb.Set(x, y, c)
+		}
+	}
+
+	return thumb
+}
+
+// Name returns the file name of the thumbnail of an image file. Thumbnails are encoded as jpeg or png,
+// so files of other formats get an additional ".png" extension, which matches the content of the thumbnail.
+func Name(file string) string {
+	if isJPEG(file) || strings.EqualFold(filepath.Ext(file), ".png") {
+		return file
+	}
+	return file + ".png"
+}
+
+// Create reads the image file {src}, downscales it via Resize() and writes the thumbnail to {dst}.
+// Images with more than {maxPixels} pixels are rejected with ErrTooLarge, see Decode().
+// The thumbnail is encoded by the extension of {dst}, as jpeg for ".jpg" and ".jpeg", as png otherwise, see Name().
+// The thumbnail is written to a temporary file first and then renamed, so that concurrent readers never see
+// a partially written thumbnail.
+func Create(src string, dst string, size int, maxPixels int) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, _, err := Decode(file, maxPixels)
+	if errors.Is(err, ErrTooLarge) {
+		return err
+	} else if err != nil {
+		return ErrUnsupportedFormat
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	thumb := Resize(img, size)
+	if isJPEG(dst) {
+		err = jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(tmp, thumb)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// isJPEG reports whether a file has the extension of a jpeg.
+func isJPEG(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".jpg" || ext == ".jpeg"
+}
@@ -0,0 +1,161 @@
+package thumbnail_test
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/thumbnail"
+)
+
+// createImage creates an image which is white on the left and black on the right half.
+func createImage(width int, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	return img
+}
+
+func TestResize(t *testing.T) {
+	thumb := thumbnail.Resize(createImage(400, 200), 100)
+
+	if size := thumb.Bounds().Size(); size != image.Pt(100, 50) {
+		format, args := testutil.FormatTestError(
+			"Expected the thumbnail to fit into the square and keep the aspect ratio.",
+			map[string]interface{}{
+				"size": size,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if r, _, _, _ := thumb.At(10, 10).RGBA(); r != 0xffff {
+		t.Error("Expected the left side of the thumbnail to stay white.")
+	}
+	if r, _, _, _ := thumb.At(90, 10).RGBA(); r != 0 {
+		t.Error("Expected the right side of the thumbnail to stay black.")
+	}
+
+	small := createImage(50, 50)
+	if thumb := thumbnail.Resize(small, 100); thumb != small {
+		t.Error("Expected images smaller than the thumbnail size to be returned unchanged.")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "image.png")
+	dst := filepath.Join(dir, "thumbs", "image.png")
+
+	file, err := os.Create(src)
+	if err == nil {
+		err = png.Encode(file, createImage(300, 300))
+		file.Close()
+	}
+	if err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+
+	if err := thumbnail.Create(src, dst, 64, 0); err != nil {
+		format, args := testutil.FormatTestError(
+			"Expected the thumbnail to be created.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Fatalf(format, args...)
+	}
+
+	file, err = os.Open(dst)
+	if err != nil {
+		t.Fatal("Unable to open the thumbnail.", err)
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil || format != "png" || config.Width != 64 || config.Height != 64 {
+		format, args := testutil.FormatTestError(
+			"Expected a png thumbnail of 64x64 pixels.",
+			map[string]interface{}{
+				"error":  err,
+				"format": format,
+				"config": config,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if err := testutil.TouchFile(filepath.Join(dir, "empty.jpg")); err != nil {
+		t.Fatal("Unable to create the test file.", err)
+	}
+	if err := thumbnail.Create(filepath.Join(dir, "empty.jpg"), dst, 64, 0); err != thumbnail.ErrUnsupportedFormat {
+		format, args := testutil.FormatTestError(
+			"Expected files that aren't images to be rejected.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestCreateTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "image.png")
+
+	file, err := os.Create(src)
+	if err == nil {
+		err = png.Encode(file, createImage(300, 200))
+		file.Close()
+	}
+	if err != nil {
+		t.Fatal("Unable to create the test image.", err)
+	}
+
+	if err := thumbnail.Create(src, filepath.Join(dir, "thumbs", "image.png"), 64, 300*200-1); !errors.Is(err, thumbnail.ErrTooLarge) {
+		format, args := testutil.FormatTestError(
+			"Expected images with more pixels than allowed to be rejected.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+	if err := thumbnail.Create(src, filepath.Join(dir, "thumbs", "image.png"), 64, 300*200); err != nil {
+		format, args := testutil.FormatTestError(
+			"Expected images within the limit to be accepted.",
+			map[string]interface{}{
+				"error": err,
+			})
+		t.Errorf(format, args...)
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := map[string]string{
+		"processed/a.jpg":  "processed/a.jpg",
+		"processed/b.JPEG": "processed/b.JPEG",
+		"processed/c.png":  "processed/c.png",
+		"processed/d.gif":  "processed/d.gif.png",
+	}
+
+	for file, expected := range tests {
+		if name := thumbnail.Name(file); name != expected {
+			format, args := testutil.FormatTestError(
+				"Expected the thumbnail name to have the extension of its format.",
+				map[string]interface{}{
+					"file":     file,
+					"expected": expected,
+					"got":      name,
+				})
+			t.Errorf(format, args...)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package util
 
-import "strings"
+import (
+	"strings"
+	"time"
+)
 
 // ContainsString checks if a given string exists in a slice.
 func ContainsString(s []string, str string, cs bool) bool {
@@ -17,6 +20,22 @@ func ContainsString(s []string, str string, cs bool) bool {
 	return false
 }
 
+// MinInt returns the smaller of two ints.
+func MinInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// MaxInt returns the larger of two ints.
+func MaxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // IntPtr creates an int and returns a pointer to it. Useful for struct inits.
 func IntPtr(i int) *int {
 	return &i
@@ -26,3 +45,18 @@ func IntPtr(i int) *int {
 func StringPtr(s string) *string {
 	return &s
 }
+
+// Float64Ptr creates a float64 and returns a pointer to it. Useful for struct inits.
+func Float64Ptr(f float64) *float64 {
+	return &f
+}
+
+// BoolPtr creates a bool and returns a pointer to it. Useful for struct inits.
+func BoolPtr(b bool) *bool {
+	return &b
+}
+
+// TimePtr creates a time.Time and returns a pointer to it. Useful for struct inits.
+func TimePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -2,6 +2,7 @@ package util_test
 
 import (
 	"testing"
+	"time"
 
 	"tagallery.com/api/util"
 )
@@ -18,6 +19,24 @@ func TestContainsString(t *testing.T) {
 	}
 }
 
+func TestMinInt(t *testing.T) {
+	if min := util.MinInt(2, 1); min != 1 {
+		t.Error("MinInt() should return the smaller int.")
+	}
+	if min := util.MinInt(-1, 3); min != -1 {
+		t.Error("MinInt() should return the smaller int.")
+	}
+}
+
+func TestMaxInt(t *testing.T) {
+	if max := util.MaxInt(2, 1); max != 2 {
+		t.Error("MaxInt() should return the larger int.")
+	}
+	if max := util.MaxInt(-1, 3); max != 3 {
+		t.Error("MaxInt() should return the larger int.")
+	}
+}
+
 func TestIntPtr(t *testing.T) {
 	if i := util.IntPtr(1); *i != 1 {
 		t.Error("IntPtr() should return pointer with the correct value.")
@@ -29,3 +48,22 @@ func TestStringPtr(t *testing.T) {
 		t.Error("StringPtr() should return pointer with the correct value.")
 	}
 }
+
+func TestFloat64Ptr(t *testing.T) {
+	if f := util.Float64Ptr(0.5); *f != 0.5 {
+		t.Error("Float64Ptr() should return pointer with the correct value.")
+	}
+}
+
+func TestBoolPtr(t *testing.T) {
+	if b := util.BoolPtr(true); !*b {
+		t.Error("BoolPtr() should return pointer with the correct value.")
+	}
+}
+
+func TestTimePtr(t *testing.T) {
+	now := time.Now()
+	if p := util.TimePtr(now); !p.Equal(now) {
+		t.Error("TimePtr() should return pointer with the correct value.")
+	}
+}
@@ -0,0 +1,242 @@
+// Package watcher keeps the images of the store in sync with the image folders while the API is running.
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/metadata"
+	"tagallery.com/api/model"
+)
+
+// Watcher indexes files of the unprocessed and processed folder as they are created, changed, renamed or deleted.
+// In recursive mode the subfolders are watched as well.
+// Events are collected until no event arrived for {delay}, so that files are only hashed once they are
+// completely written, and renamed files are indexed before their old name is removed, which lets them
+// keep the image (and categories) of their old name.
+type Watcher struct {
+	ctrl     *controller.Controller
+	delay    time.Duration
+	onChange func(images []model.Image)
+}
+
+// New creates a Watcher. {onChange} is called with the new and changed images after each batch of events,
+// e. g. to start downstream jobs. It may be nil.
+func New(ctrl *controller.Controller, delay time.Duration, onChange func(images []model.Image)) *Watcher {
+	return &Watcher{ctrl: ctrl, delay: delay, onChange: onChange}
+}
+
+// Run watches the image folders until the context is cancelled. Missing folders are created.
+func (w *Watcher) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := map[string]bool{}
+	for _, folder := range folders() {
+		path := filepath.Join(config.Get().Images, folder)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		if err := watch(watcher, dirs, path, nil); err != nil {
+			return err
+		}
+	}
+
+	pending := map[string]bool{}
+	reindex := false
+	timer := time.NewTimer(w.delay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			queued := false
+			if config.Get().Recursive && event.Op&fsnotify.Create != 0 {
+				// The files of a new folder may have been created before the folder was watched.
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watch(watcher, dirs, event.Name, pending); err != nil {
+						logger.Logger().Warnw("Unable to watch a folder.", "folder", event.Name, "error", err)
+					}
+					queued = true
+				}
+			}
+			if dirs[event.Name] && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				// The files of a removed folder don't get events of their own.
+				unwatch(watcher, dirs, event.Name)
+				reindex = true
+				queued = true
+			}
+			if file, ok := imageFile(event.Name); ok && !dirs[event.Name] {
+				pending[file] = true
+				queued = true
+			}
+
+			if queued {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(w.delay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Events may have been lost, e. g. because the event queue overflowed.
+			logger.Logger().Warnw("Error while watching the image folders, rescanning them.", "error", err)
+			if images, err := w.ctrl.Rescan(); err != nil {
+				logger.Logger().Warnw("Unable to rescan the image folders.", "error", err)
+			} else {
+				w.changed(images)
+			}
+		case <-timer.C:
+			w.sync(pending)
+			pending = map[string]bool{}
+
+			if reindex {
+				reindex = false
+				if images, _, err := w.ctrl.Reindex(); err != nil {
+					logger.Logger().Warnw("Unable to reindex the image folders.", "error", err)
+				} else {
+					w.changed(images)
+				}
+			}
+		}
+	}
+}
+
+// sync indexes the existing files and removes the images of the missing ones.
+func (w *Watcher) sync(pending map[string]bool) {
+	files := []string{}
+	for file := range pending {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	changed := []model.Image{}
+	missing := []string{}
+
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(config.Get().Images, file))
+		if os.IsNotExist(err) {
+			missing = append(missing, file)
+			continue
+		} else if err != nil || info.IsDir() {
+			continue
+		}
+
+		if image, updated, err := w.ctrl.IndexFile(file); err != nil {
+			logger.Logger().Warnw("Unable to index a file.", "file", file, "error", err)
+		} else if updated {
+			logger.Logger().Infow("File indexed.", "file", file)
+			changed = append(changed, *image)
+		}
+	}
+
+	for _, file := range missing {
+		if removed, err := w.ctrl.RemoveFile(file); err != nil {
+			logger.Logger().Warnw("Unable to remove the image of a deleted file.", "file", file, "error", err)
+		} else if removed {
+			logger.Logger().Infow("Image of a deleted file removed.", "file", file)
+		}
+	}
+
+	w.changed(changed)
+}
+
+// changed calls onChange if images were changed.
+func (w *Watcher) changed(images []model.Image) {
+	if len(images) > 0 && w.onChange != nil {
+		w.onChange(images)
+	}
+}
+
+// watch adds a folder and, in recursive mode, its (not hidden) subfolders to the watcher.
+// If {pending} is given, then the files found in the folders are added to it.
+func watch(watcher *fsnotify.Watcher, dirs map[string]bool, folder string, pending map[string]bool) error {
+	return filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != folder && (!config.Get().Recursive || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			if err := watcher.Add(path); err != nil {
+				return err
+			}
+			dirs[path] = true
+		} else if file, ok := imageFile(path); ok && pending != nil {
+			pending[file] = true
+		}
+
+		return nil
+	})
+}
+
+// unwatch removes a folder and its subfolders from the watcher.
+func unwatch(watcher *fsnotify.Watcher, dirs map[string]bool, folder string) {
+	for dir := range dirs {
+		if dir == folder || strings.HasPrefix(dir, folder+string(filepath.Separator)) {
+			// The watch of a removed folder is already gone.
+			_ = watcher.Remove(dir)
+			delete(dirs, dir)
+		}
+	}
+}
+
+// imageFile returns the file of an image relative to the image folder, if the path is a (not hidden) file
+// directly in one of the watched folders, or in one of their (not hidden) subfolders in recursive mode.
+// XMP sidecars are not images.
+func imageFile(path string) (string, bool) {
+	file, err := filepath.Rel(config.Get().Images, path)
+	if err != nil {
+		return "", false
+	}
+
+	parts := strings.Split(filepath.ToSlash(file), "/")
+	if len(parts) < 2 || (len(parts) > 2 && !config.Get().Recursive) {
+		return "", false
+	}
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") {
+			return "", false
+		}
+	}
+	if metadata.IsSidecar(file) {
+		return "", false
+	}
+
+	for _, folder := range folders() {
+		if parts[0] == folder {
+			return file, true
+		}
+	}
+
+	return "", false
+}
+
+// folders returns the watched image folders, relative to the image folder.
+func folders() []string {
+	return []string{config.Get().UnprocessedImagesFolder, config.Get().ProcessedImagesFolder}
+}
@@ -0,0 +1,174 @@
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tagallery.com/api/config"
+	"tagallery.com/api/controller"
+	"tagallery.com/api/logger"
+	"tagallery.com/api/memory"
+	"tagallery.com/api/model"
+	"tagallery.com/api/store"
+	"tagallery.com/api/testutil"
+	"tagallery.com/api/watcher"
+)
+
+func init() {
+	logger.Setup(true)
+}
+
+// waitFor polls {condition} until it is true or a second has passed.
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestWatcher(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+
+	db := memory.NewStore()
+	var mu sync.Mutex
+	changed := []model.Image{}
+
+	w := watcher.New(controller.New(store.Store{Images: db, Categories: db}), 20*time.Millisecond, func(images []model.Image) {
+		mu.Lock()
+		defer mu.Unlock()
+		changed = append(changed, images...)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	processed := filepath.Join(configuration.Images, configuration.ProcessedImagesFolder)
+	if !waitFor(func() bool { _, err := os.Stat(processed); return err == nil }) {
+		t.Fatal("Expected the image folders to be created.")
+	}
+	// Give the watcher the chance to register the folders.
+	time.Sleep(50 * time.Millisecond)
+
+	if err := ioutil.WriteFile(filepath.Join(processed, "a.jpg"), []byte("a"), 0644); err != nil {
+		t.Fatal("Unable to create the file.", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(processed, ".hidden.jpg"), []byte("b"), 0644); err != nil {
+		t.Fatal("Unable to create the file.", err)
+	}
+
+	if !waitFor(func() bool { _, err := db.GetImage("processed/a.jpg"); return err == nil }) {
+		t.Fatal("Expected a new file to be indexed.")
+	}
+	if _, err := db.GetImage("processed/.hidden.jpg"); !errors.Is(err, store.ErrNotFound) {
+		t.Error("Expected hidden files to be ignored.", err)
+	}
+
+	mu.Lock()
+	if len(changed) != 1 || changed[0].File != "processed/a.jpg" {
+		format, args := testutil.FormatTestError(
+			"Expected the new image to be passed to onChange.",
+			map[string]interface{}{
+				"changed": changed,
+			})
+		t.Errorf(format, args...)
+	}
+	mu.Unlock()
+
+	db.UpdateImage("processed/a.jpg", func(image *model.Image) error {
+		image.AssignedCategories = []string{"Category 1"}
+		return nil
+	})
+	if err := os.Rename(filepath.Join(processed, "a.jpg"), filepath.Join(processed, "b.jpg")); err != nil {
+		t.Fatal("Unable to rename the file.", err)
+	}
+
+	var renamed *model.Image
+	if !waitFor(func() bool { renamed, _ = db.GetImage("processed/b.jpg"); return renamed != nil }) {
+		t.Fatal("Expected a renamed file to be indexed.")
+	}
+	if _, err := db.GetImage("processed/a.jpg"); !errors.Is(err, store.ErrNotFound) ||
+		len(renamed.AssignedCategories) != 1 {
+		format, args := testutil.FormatTestError(
+			"Expected a renamed file to keep the image of its old name.",
+			map[string]interface{}{
+				"error": err,
+				"image": renamed,
+			})
+		t.Errorf(format, args...)
+	}
+
+	if err := os.Remove(filepath.Join(processed, "b.jpg")); err != nil {
+		t.Fatal("Unable to delete the file.", err)
+	}
+	if !waitFor(func() bool { _, err := db.GetImage("processed/b.jpg"); return errors.Is(err, store.ErrNotFound) }) {
+		t.Error("Expected the image of a deleted file to be removed.")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Expected the watcher to stop without an error.", err)
+	}
+}
+
+func TestWatcherRecursive(t *testing.T) {
+	configuration := config.Load()
+	configuration.Images = t.TempDir()
+	configuration.Recursive = true
+
+	db := memory.NewStore()
+	w := watcher.New(controller.New(store.Store{Images: db, Categories: db}), 20*time.Millisecond, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	unprocessed := filepath.Join(configuration.Images, configuration.UnprocessedImagesFolder)
+	if !waitFor(func() bool { _, err := os.Stat(unprocessed); return err == nil }) {
+		t.Fatal("Expected the image folders to be created.")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	folder := filepath.Join(unprocessed, "Vacation", "2019")
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal("Unable to create the subfolders.", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, "a.jpg"), []byte("a"), 0644); err != nil {
+		t.Fatal("Unable to create the file.", err)
+	}
+
+	if !waitFor(func() bool { _, err := db.GetImage("unprocessed/Vacation/2019/a.jpg"); return err == nil }) {
+		t.Fatal("Expected a file of a new subfolder to be indexed.")
+	}
+
+	if err := os.RemoveAll(filepath.Join(unprocessed, "Vacation")); err != nil {
+		t.Fatal("Unable to delete the subfolders.", err)
+	}
+	if !waitFor(func() bool {
+		_, err := db.GetImage("unprocessed/Vacation/2019/a.jpg")
+		return errors.Is(err, store.ErrNotFound)
+	}) {
+		t.Error("Expected the image of a file of a deleted subfolder to be removed.")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Expected the watcher to stop without an error.", err)
+	}
+}
@@ -1,8 +1,27 @@
+export interface Proposal {
+  category: string
+  score: number
+  source?: string
+  timestamp?: string
+}
+
+export interface Metadata {
+  takenAt?: string
+  make?: string
+  model?: string
+  lens?: string
+  orientation?: number
+  width?: number
+  height?: number
+  gps?: { latitude: number; longitude: number; altitude?: number }
+}
+
 export interface Image {
   file: string
   assignedCategories: string[]
-  proposedCategories: string[]
+  proposedCategories: Proposal[]
   starredCategory?: string
+  metadata?: Metadata
 }
 
 const state = () => ({